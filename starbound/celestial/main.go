@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/celestial"
+)
+
+// System is what get outputs and set reads.
+type System struct {
+	Location   celestial.Vec3        `json:"location"`
+	Parameters *celestial.Parameters `json:"parameters"`
+	Objects    []celestial.Object    `json:"objects"`
+}
+
+// find returns the key and chunk of the system at loc
+func find(h *btreedb5.BTreeDB5, loc celestial.Vec3) (btreedb5.Key, *celestial.Chunk, error) {
+	var key btreedb5.Key
+	var chunk *celestial.Chunk
+
+	e := celestial.Chunks(h, func(k btreedb5.Key, c *celestial.Chunk) error {
+		if chunk != nil {
+			return nil
+		}
+
+		for _, v := range c.SystemParameters {
+			if v.Location == loc {
+				key, chunk = append(btreedb5.Key{}, k...), c
+			}
+		}
+
+		return nil
+	})
+	if e != nil {
+		return nil, nil, e
+	}
+
+	if chunk == nil {
+		return nil, nil, fmt.Errorf("no system at %d,%d,%d", loc[0], loc[1], loc[2])
+	}
+
+	return key, chunk, nil
+}
+
+func main() {
+	var in, mode, coord, file string
+	flag.StringVar(&in, "i", "universe.chunks", "celestial chunk file")
+	flag.StringVar(&mode, "m", "list", "list/get/set")
+	flag.StringVar(&coord, "c", "", "system location x,y,z")
+	flag.StringVar(&file, "f", "stdin", "system json to set")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	var loc celestial.Vec3
+	if mode != "list" {
+		if _, e := fmt.Sscanf(coord, "%d,%d,%d", &loc[0], &loc[1], &loc[2]); e != nil {
+			log.Fatalf("%q is not x,y,z\n", coord)
+		}
+	}
+
+	var h *btreedb5.BTreeDB5
+	var e error
+	if mode == "set" {
+		h, e = btreedb5.Load(in)
+	} else {
+		h, e = btreedb5.LoadReadOnly(in)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	if h.Identifier != celestial.Identifier {
+		log.Fatalf("%s is a %q file, not %q\n", in, h.Identifier, celestial.Identifier)
+	}
+
+	switch mode {
+	case "list":
+		e := celestial.Chunks(h, func(key btreedb5.Key, c *celestial.Chunk) error {
+			for _, v := range c.SystemParameters {
+				_, objs := c.System(v.Location)
+				fmt.Printf("%d,%d,%d\t%s\t%d objects\n", v.Location[0], v.Location[1], v.Location[2], v.Parameters.Name, len(objs))
+			}
+			return nil
+		})
+		if e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+	case "get":
+		_, c, e := find(h, loc)
+		if e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+
+		p, objs := c.System(loc)
+
+		r, e := json.MarshalIndent(System{Location: loc, Parameters: p, Objects: objs}, "", "\t")
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		os.Stdout.Write(append(r, '\n'))
+	case "set":
+		rd := os.Stdin
+		if file != "stdin" {
+			rd, e = os.Open(file)
+			if e != nil {
+				log.Fatalln(e)
+			}
+			defer rd.Close()
+		}
+
+		var sys System
+
+		d := json.NewDecoder(rd)
+		d.UseNumber()
+		if e := d.Decode(&sys); e != nil {
+			log.Fatalln(e)
+		}
+
+		key, c, e := find(h, loc)
+		if e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+
+		if sys.Parameters != nil {
+			p, _ := c.System(loc)
+			*p = *sys.Parameters
+		}
+
+		found := false
+		for k := range c.SystemObjects {
+			if c.SystemObjects[k].Location == loc {
+				c.SystemObjects[k].Objects = sys.Objects
+				found = true
+			}
+		}
+
+		if !found && len(sys.Objects) != 0 {
+			c.SystemObjects = append(c.SystemObjects, celestial.SystemObjects{Location: loc, Objects: sys.Objects})
+		}
+
+		data, e := c.Bytes()
+		if e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+
+		if e := h.Insert(key, data); e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+
+		if e := h.Commit(); e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+	default:
+		log.Fatalf("unknown mode %s\n", mode)
+	}
+}
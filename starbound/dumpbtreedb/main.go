@@ -7,13 +7,16 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/xhebox/bstruct/byteorder"
 	"github.com/xhebox/sbutils/lib/btreedb5"
+	_ "github.com/xhebox/sbutils/lib/celestial"
+	"github.com/xhebox/sbutils/lib/codec"
 	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
 )
 
 func main() {
@@ -29,7 +32,41 @@ func main() {
 	}
 	defer h.Close()
 
-	switch mode {
+	format, _ := codec.Lookup(h.Identifier)
+
+	switch {
+	case h.Identifier != world.Identifier && format != nil:
+		// other known files are dumped to json_ files, which makebtreedb
+		// encodes back with the same codec
+		e = h.Ascend(func(key btreedb5.Key, data []byte) {
+			doc, e := format.Codec.Decode(key, data)
+			if e != nil {
+				log.Fatalf("%x: %+v\n", key, e)
+			}
+
+			name := fmt.Sprintf("json_%s", hex.EncodeToString(key))
+			raw, ok := doc.([]byte)
+			if ok {
+				name = fmt.Sprintf("data_%s", hex.EncodeToString(key))
+			} else {
+				raw, e = json.MarshalIndent(doc, "", "\t")
+				if e != nil {
+					log.Fatalln(e)
+				}
+			}
+
+			f, e := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			f.Write(raw)
+
+			f.Close()
+		})
+		if e != nil {
+			log.Fatalf("%+v\n", e)
+		}
 	default:
 		e = h.Ascend(func(key btreedb5.Key, data []byte) {
 			z, e := zlib.NewReader(bytes.NewReader(data))
@@ -37,6 +74,19 @@ func main() {
 				log.Fatalln(e)
 			}
 
+			raw, e := ioutil.ReadAll(z)
+			if e != nil {
+				log.Fatalln(e)
+			}
+			z.Close()
+
+			rd := bytes.NewReader(raw)
+
+			// offset of rd in the record, for errors
+			pos := func() int64 {
+				return int64(len(raw) - rd.Len())
+			}
+
 			switch key[0] {
 			case 0:
 				f, e := os.OpenFile("metadata", os.O_RDWR|os.O_CREATE, 0644)
@@ -44,24 +94,25 @@ func main() {
 					log.Fatalln(e)
 				}
 
-				x, e := byteorder.Uint32(z, byteorder.BigEndian)
+				x, e := byteorder.Uint32(rd, byteorder.BigEndian)
 				if e != nil {
 					log.Fatalln(e)
 				}
 
-				y, e := byteorder.Uint32(z, byteorder.BigEndian)
+				y, e := byteorder.Uint32(rd, byteorder.BigEndian)
 				if e != nil {
 					log.Fatalln(e)
 				}
 
-				hdr, e := sbvj01.ReadHdr(z)
+				hdr, e := sbvj01.ReadHdr(rd)
 				if e != nil {
 					log.Fatalln(e)
 				}
 
-				body, e := sbvj01.Read(z)
+				base := pos()
+				body, e := sbvj01.Read(rd)
 				if e != nil {
-					log.Fatalln(e)
+					log.Fatalf("metadata: %s", sbvj01.ErrorContext(e, raw, base))
 				}
 
 				json, e := json.MarshalIndent(map[string]interface{}{
@@ -82,7 +133,7 @@ func main() {
 					log.Fatalln(e)
 				}
 
-				cnt, e := byteorder.UVarint(z, byteorder.BigEndian)
+				cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
 				if e != nil {
 					log.Fatalln(e)
 				}
@@ -90,14 +141,15 @@ func main() {
 				vjs := []map[string]interface{}{}
 
 				for i, j := 0, int(cnt); i < j; i++ {
-					hdr, e := sbvj01.ReadHdr(z)
+					hdr, e := sbvj01.ReadHdr(rd)
 					if e != nil {
 						log.Fatalln(e)
 					}
 
-					body, e := sbvj01.Read(z)
+					base := pos()
+					body, e := sbvj01.Read(rd)
 					if e != nil {
-						log.Fatalln(e)
+						log.Fatalf("%x entity %d: %s", key, i, sbvj01.ErrorContext(e, raw, base))
 					}
 
 					vjs = append(vjs, map[string]interface{}{
@@ -121,12 +173,10 @@ func main() {
 					log.Fatalln(e)
 				}
 
-				io.Copy(f, z)
+				f.Write(raw)
 
 				f.Close()
 			}
-
-			z.Close()
 		})
 		if e != nil {
 			log.Fatalf("%+v\n", e)
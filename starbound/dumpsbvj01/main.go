@@ -9,32 +9,59 @@ import (
 	"log"
 	"os"
 
-	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/luatable"
 	"github.com/xhebox/sbutils/lib/sbvj01"
 )
 
 func main() {
-	var in, out, mode string
+	var in, out, mode, path string
 	var skip int
+	var annotated, lua bool
 	flag.StringVar(&in, "i", "input", "versioned json file")
 	flag.StringVar(&out, "o", "stdout", "output json")
-	flag.StringVar(&mode, "m", "vj", "vjmagic/vj/raw/nvj")
+	flag.StringVar(&mode, "m", "", "vjmagic/vj/raw/nvj, detected if empty")
 	flag.IntVar(&skip, "n", 0, "skip first n bytes")
+	flag.BoolVar(&annotated, "a", false, "annotated json that keeps the exact types")
+	flag.StringVar(&path, "p", "", "only dump the value at this path of the content, e.g. $.identity.name")
+	flag.BoolVar(&lua, "l", false, "output a lua expression instead of json")
 	flag.Parse()
 	log.SetFlags(log.Llongfile)
 
-	contents, e := ioutil.ReadFile(in)
+	if annotated && lua {
+		log.Fatalln("-a and -l can not be used together")
+	}
+
+	data, e := ioutil.ReadFile(in)
 	if e != nil {
 		log.Fatalln(e)
 	}
 
-	contents = contents[skip:]
+	if skip > len(data) {
+		log.Fatalf("can not skip %d bytes of %d\n", skip, len(data))
+	}
+
+	contents := data[skip:]
+
+	if mode == "" {
+		info, e := detect.Detect(contents)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		switch info.Kind {
+		case detect.VJMagic, detect.VJ, detect.Raw, detect.NVJ:
+			mode = info.Kind.String()
+		default:
+			log.Fatalf("%s is %s, not a versioned json, use -m to force a mode\n", in, info)
+		}
+	}
 
 	var outwt io.Writer
 	if out == "stdout" {
 		outwt = os.Stdout
 	} else {
-		f, e := os.OpenFile(out, os.O_CREATE|os.O_RDWR, 0644)
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 		if e != nil {
 			log.Fatalln(e)
 		}
@@ -45,66 +72,107 @@ func main() {
 
 	rd := bytes.NewReader(contents)
 
-	switch mode {
-	case "raw":
-		r, e := sbvj01.Read(rd, byteorder.BigEndian)
-		if e != nil {
-			log.Fatalln(e)
-		}
+	// offset of rd in the file, for errors
+	pos := func() int64 {
+		return int64(len(data) - rd.Len())
+	}
 
-		out, e := json.MarshalIndent(r, "", "\t")
-		if e != nil {
-			log.Fatalln(e)
-		}
+	var doc interface{}
 
-		_, e = io.Copy(outwt, bytes.NewReader(out))
-		if e != nil {
-			log.Fatalln(e)
-		}
-	case "vj":
-		r, e := sbvj01.ReadHdr(rd)
-		if e != nil {
-			log.Fatalln(e)
+	switch mode {
+	case "nvj":
+		if path != "" {
+			log.Fatalln("-p can not be used with nvj")
 		}
 
-		b, e := sbvj01.Read(rd, r.Endian)
+		l, e := sbvj01.ReadList(rd)
 		if e != nil {
-			log.Fatalln(e)
+			log.Fatal(sbvj01.ErrorContext(e, data, int64(skip)))
 		}
 
-		out, e := json.MarshalIndent(b, "", "\t")
-		if e != nil {
-			log.Fatalln(e)
+		if annotated {
+			for _, vj := range l {
+				vj.Content = sbvj01.Annotate(vj.Content)
+			}
 		}
 
-		_, e = io.Copy(outwt, bytes.NewReader(out))
+		doc = l.Document()
+	default:
+		form, e := sbvj01.ParseForm(mode)
 		if e != nil {
 			log.Fatalln(e)
 		}
-	case "nvj":
-		r := int(int8(contents[0]))
-		rd = bytes.NewReader(contents[1:])
 
-		for i := 0; i < r; i++ {
-			r, e := sbvj01.ReadHdr(rd)
-			if e != nil {
-				log.Fatalln(e)
+		if path != "" {
+			// seek to the value without decoding the rest
+			if form == sbvj01.FormMagic {
+				if e := sbvj01.ReadMagic(rd); e != nil {
+					log.Fatalln(e)
+				}
 			}
 
-			b, e := sbvj01.Read(rd, r.Endian)
-			if e != nil {
-				log.Fatalln(e)
+			if form != sbvj01.FormRaw {
+				if _, e := sbvj01.ReadHdr(rd); e != nil {
+					log.Fatalln(e)
+				}
 			}
 
-			out, e := json.MarshalIndent(b, "", "\t")
-			if e != nil {
-				log.Fatalln(e)
+			base := pos()
+			d := sbvj01.NewDecoder(rd)
+			if e := d.Seek(path); e != nil {
+				log.Fatal(sbvj01.ErrorContext(e, data, base))
 			}
 
-			_, e = io.Copy(outwt, bytes.NewReader(out))
+			doc, e = d.Decode()
 			if e != nil {
-				log.Fatalln(e)
+				log.Fatal(sbvj01.ErrorContext(e, data, base))
 			}
+
+			if annotated {
+				doc = sbvj01.Annotate(doc)
+			}
+			break
+		}
+
+		base := pos()
+		vj, e := sbvj01.ReadVersioned(rd, form)
+		if e != nil {
+			log.Fatal(sbvj01.ErrorContext(e, data, base))
+		}
+
+		if annotated {
+			vj.Content = sbvj01.Annotate(vj.Content)
+		}
+
+		if form == sbvj01.FormRaw {
+			doc = vj.Content
+		} else {
+			doc = vj.Document()
+		}
+	}
+
+	if rd.Len() != 0 && path == "" {
+		log.Printf("%d trailing bytes\n", rd.Len())
+	}
+
+	if lua {
+		r, e := luatable.Marshal(doc, "\t")
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		if _, e := outwt.Write(append(r, '\n')); e != nil {
+			log.Fatalln(e)
 		}
+		return
+	}
+
+	r, e := json.MarshalIndent(doc, "", "\t")
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	if _, e := outwt.Write(append(r, '\n')); e != nil {
+		log.Fatalln(e)
 	}
 }
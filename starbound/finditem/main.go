@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+type Match struct {
+	File      string `json:"file"`
+	X         int64  `json:"x"`
+	Y         int64  `json:"y"`
+	Container string `json:"container"`
+	Item      string `json:"item"`
+	Count     int64  `json:"count"`
+}
+
+// contains reports whether every member of pred is found in v
+func contains(pred, v interface{}) bool {
+	p, ok := pred.(map[string]interface{})
+	if !ok {
+		return jsonpatch.Equal(pred, v)
+	}
+
+	for k, w := range p {
+		x, e := jsonpatch.Get(v, jsonpatch.Pointer{k})
+		if e != nil || !contains(w, x) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// descriptor unwraps an item stored as versioned json
+func descriptor(item interface{}) interface{} {
+	if id, e := jsonpatch.Get(item, jsonpatch.Pointer{"id"}); e == nil && fmt.Sprint(id) == "Item" {
+		if content, e := jsonpatch.Get(item, jsonpatch.Pointer{"content"}); e == nil {
+			return content
+		}
+	}
+	return item
+}
+
+func integer(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
+
+func search(file, rel string, match func(interface{}) bool) ([]Match, error) {
+	h, e := btreedb5.LoadReadOnly(file)
+	if e != nil {
+		return nil, e
+	}
+	defer h.Close()
+
+	r := []Match{}
+
+	e = h.AscendRange(btreedb5.Key{world.EntitySectorType}, btreedb5.Key{world.EntitySectorType + 1}, func(key btreedb5.Key, data []byte) {
+		raw, e := world.Decompress(data)
+		if e != nil {
+			log.Printf("%s %x: %v\n", rel, key, e)
+			return
+		}
+
+		l, e := world.ReadEntities(raw)
+		if e != nil {
+			log.Printf("%s %x: %v\n", rel, key, e)
+			return
+		}
+
+		for _, v := range l {
+			if v.Hdr.Id != "ObjectEntity" {
+				continue
+			}
+
+			items, e := jsonpatch.Get(v.Body, jsonpatch.Pointer{"items"})
+			if e != nil {
+				continue
+			}
+
+			list, ok := items.([]interface{})
+			if !ok {
+				continue
+			}
+
+			name, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"name"})
+			x, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"tilePosition", "0"})
+			y, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"tilePosition", "1"})
+
+			counts := map[string]int64{}
+			for _, item := range list {
+				if item == nil {
+					continue
+				}
+
+				d := descriptor(item)
+				if !match(d) {
+					continue
+				}
+
+				n, _ := jsonpatch.Get(d, jsonpatch.Pointer{"name"})
+				c, _ := jsonpatch.Get(d, jsonpatch.Pointer{"count"})
+				counts[fmt.Sprint(n)] += integer(c)
+			}
+
+			names := []string{}
+			for k := range counts {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+
+			for _, k := range names {
+				r = append(r, Match{
+					File:      rel,
+					X:         integer(x),
+					Y:         integer(y),
+					Container: fmt.Sprint(name),
+					Item:      k,
+					Count:     counts[k],
+				})
+			}
+		}
+	})
+
+	return r, e
+}
+
+func main() {
+	var dir, name, pred string
+	var js bool
+	flag.StringVar(&dir, "d", "universe", "universe directory")
+	flag.StringVar(&name, "n", "*", "item name, could be a glob pattern")
+	flag.StringVar(&pred, "p", "", "json the item descriptor should contain, e.g. {\"parameters\":{\"rarity\":\"legendary\"}}")
+	flag.BoolVar(&js, "j", false, "output json")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	var predicate interface{}
+	if pred != "" {
+		d := json.NewDecoder(bytes.NewReader([]byte(pred)))
+		d.UseNumber()
+		if e := d.Decode(&predicate); e != nil {
+			log.Fatalln(e)
+		}
+	}
+
+	if _, e := path.Match(name, ""); e != nil {
+		log.Fatalln(e)
+	}
+
+	match := func(item interface{}) bool {
+		n, e := jsonpatch.Get(item, jsonpatch.Pointer{"name"})
+		if e != nil {
+			return false
+		}
+
+		if ok, _ := path.Match(name, fmt.Sprint(n)); !ok {
+			return false
+		}
+
+		return predicate == nil || contains(predicate, item)
+	}
+
+	matches := []Match{}
+
+	e := filepath.Walk(dir, func(file string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		switch filepath.Ext(file) {
+		case ".world", ".shipworld":
+		default:
+			return nil
+		}
+
+		rel, e := filepath.Rel(dir, file)
+		if e != nil {
+			rel = file
+		}
+
+		r, e := search(file, rel, match)
+		if e != nil {
+			log.Printf("%s: %v\n", rel, e)
+			return nil
+		}
+
+		matches = append(matches, r...)
+		return nil
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	if js {
+		out, e := json.MarshalIndent(matches, "", "\t")
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		os.Stdout.Write(append(out, '\n'))
+		return
+	}
+
+	// a container has a match for every item name it holds
+	type container struct {
+		file, name string
+		x, y       int64
+	}
+
+	total := map[string]int64{}
+	containers := map[container]bool{}
+	for _, m := range matches {
+		fmt.Printf("%s\t%d,%d\t%s\t%s\t%d\n", m.File, m.X, m.Y, m.Container, m.Item, m.Count)
+		total[m.Item] += m.Count
+		containers[container{m.File, m.Container, m.X, m.Y}] = true
+	}
+
+	names := []string{}
+	for k := range total {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\n%d containers\n", len(containers))
+	for _, k := range names {
+		fmt.Printf("\t%s: %d\n", k, total[k])
+	}
+}
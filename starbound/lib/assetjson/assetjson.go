@@ -0,0 +1,435 @@
+// Package assetjson parses the json dialect of Starbound assets, like
+// .config, .item, .object or .patch files: json with // and /* */ comments
+// and trailing commas in arrays and objects.
+//
+// Values are those of sbvj01.DecodeJSON: nil, bool, json.Number, string,
+// []interface{} and *sbvj01.Object in document order, so they can be passed
+// to sbvj01.Write as they are.
+package assetjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// SyntaxError is an error at a position of the input, Line and Column count
+// from 1, Column in bytes.
+type SyntaxError struct {
+	Offset int64
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// MaxDepth bounds the nesting of arrays and objects.
+var MaxDepth = 512
+
+// Parse parses one value, anything but comments and spaces after it is an
+// error.
+func Parse(data []byte) (interface{}, error) {
+	p := &parser{data: data}
+
+	if e := p.space(); e != nil {
+		return nil, e
+	}
+
+	r, e := p.value()
+	if e != nil {
+		return nil, e
+	}
+
+	if e := p.space(); e != nil {
+		return nil, e
+	}
+
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %s after the value", p.char())
+	}
+
+	return r, nil
+}
+
+// ReadFile parses a whole file, errors are prefixed by the file name.
+func ReadFile(name string) (interface{}, error) {
+	data, e := ioutil.ReadFile(name)
+	if e != nil {
+		return nil, e
+	}
+
+	r, e := Parse(data)
+	if e != nil {
+		return nil, errors.Wrapf(e, "%s", name)
+	}
+
+	return r, nil
+}
+
+type parser struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// errorf returns a SyntaxError at the current position.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.pos, format, args...)
+}
+
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range p.data[:pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+
+	return &SyntaxError{
+		Offset: int64(pos),
+		Line:   line,
+		Column: col,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// char describes the byte at the current position, for errors.
+func (p *parser) char() string {
+	if p.pos >= len(p.data) {
+		return "end of input"
+	}
+
+	r, _ := utf8.DecodeRune(p.data[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+// space skips spaces and comments.
+func (p *parser) space() error {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		case '/':
+			start := p.pos
+			if p.pos+1 >= len(p.data) {
+				return p.errorf("unexpected '/'")
+			}
+
+			switch p.data[p.pos+1] {
+			case '/':
+				for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+					p.pos++
+				}
+			case '*':
+				p.pos += 2
+				for {
+					if p.pos+1 >= len(p.data) {
+						return p.errorAt(start, "unterminated comment")
+					}
+					if p.data[p.pos] == '*' && p.data[p.pos+1] == '/' {
+						p.pos += 2
+						break
+					}
+					p.pos++
+				}
+			default:
+				return p.errorf("unexpected '/'")
+			}
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		return p.str()
+	case c == '-' || c >= '0' && c <= '9':
+		return p.number()
+	case p.literal("null"):
+		return nil, nil
+	case p.literal("true"):
+		return true, nil
+	case p.literal("false"):
+		return false, nil
+	default:
+		return nil, p.errorf("unexpected %s", p.char())
+	}
+}
+
+// literal consumes word if it is at the current position.
+func (p *parser) literal(word string) bool {
+	end := p.pos + len(word)
+	if end > len(p.data) || string(p.data[p.pos:end]) != word {
+		return false
+	}
+
+	if end < len(p.data) && isWord(p.data[end]) {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *parser) push() error {
+	if MaxDepth > 0 && p.depth >= MaxDepth {
+		return p.errorf("nested over %d levels", MaxDepth)
+	}
+	p.depth++
+	return nil
+}
+
+// list parses the elements of an array or object until end, each by elem.
+// A comma may follow the last element.
+func (p *parser) list(end byte, elem func() error) error {
+	if e := p.push(); e != nil {
+		return e
+	}
+	defer func() { p.depth-- }()
+
+	start := p.pos
+	p.pos++
+
+	for {
+		if e := p.space(); e != nil {
+			return e
+		}
+
+		if p.pos >= len(p.data) {
+			return p.errorAt(start, "unterminated %s", kind(end))
+		}
+
+		if p.data[p.pos] == end {
+			p.pos++
+			return nil
+		}
+
+		if e := elem(); e != nil {
+			return e
+		}
+
+		if e := p.space(); e != nil {
+			return e
+		}
+
+		switch {
+		case p.pos >= len(p.data):
+			return p.errorAt(start, "unterminated %s", kind(end))
+		case p.data[p.pos] == ',':
+			p.pos++
+		case p.data[p.pos] != end:
+			return p.errorf("expect ',' or '%c', got %s", end, p.char())
+		}
+	}
+}
+
+func kind(end byte) string {
+	if end == ']' {
+		return "array"
+	}
+	return "object"
+}
+
+func (p *parser) array() ([]interface{}, error) {
+	r := []interface{}{}
+
+	e := p.list(']', func() error {
+		v, e := p.value()
+		if e != nil {
+			return e
+		}
+
+		r = append(r, v)
+		return nil
+	})
+
+	return r, e
+}
+
+// object parses an object, a repeated key replaces the value in place.
+func (p *parser) object() (*sbvj01.Object, error) {
+	r := sbvj01.NewObject()
+
+	e := p.list('}', func() error {
+		if p.data[p.pos] != '"' {
+			return p.errorf("expect a key, got %s", p.char())
+		}
+
+		k, e := p.str()
+		if e != nil {
+			return e
+		}
+
+		if e := p.space(); e != nil {
+			return e
+		}
+
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			return p.errorf("expect ':' after key %q, got %s", k, p.char())
+		}
+		p.pos++
+
+		if e := p.space(); e != nil {
+			return e
+		}
+
+		v, e := p.value()
+		if e != nil {
+			return e
+		}
+
+		r.Set(k, v)
+		return nil
+	})
+
+	return r, e
+}
+
+func (p *parser) str() (string, error) {
+	start := p.pos
+	p.pos++
+
+	buf := []byte{}
+	for {
+		if p.pos >= len(p.data) {
+			return "", p.errorAt(start, "unterminated string")
+		}
+
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return string(buf), nil
+		case c < 0x20:
+			return "", p.errorf("control character %q in string", c)
+		case c != '\\':
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+
+		if p.pos+1 >= len(p.data) {
+			return "", p.errorAt(start, "unterminated string")
+		}
+
+		switch c := p.data[p.pos+1]; c {
+		case '"', '\\', '/':
+			buf = append(buf, c)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, ok := p.hex4(p.pos + 2)
+			if !ok {
+				return "", p.errorf("bad unicode escape")
+			}
+			p.pos += 6
+
+			if utf16.IsSurrogate(r) {
+				if s, ok := p.hex4(p.pos + 2); ok && p.data[p.pos] == '\\' && p.data[p.pos+1] == 'u' {
+					if d := utf16.DecodeRune(r, s); d != utf8.RuneError {
+						r = d
+						p.pos += 6
+					}
+				}
+			}
+
+			buf = append(buf, string(r)...)
+			continue
+		default:
+			return "", p.errorf("bad escape \\%c", c)
+		}
+		p.pos += 2
+	}
+}
+
+// hex4 reads 4 hex digits at pos.
+func (p *parser) hex4(pos int) (rune, bool) {
+	if pos+4 > len(p.data) {
+		return 0, false
+	}
+
+	r, e := strconv.ParseUint(string(p.data[pos:pos+4]), 16, 32)
+	return rune(r), e == nil
+}
+
+func (p *parser) number() (json.Number, error) {
+	start := p.pos
+
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+
+	if n := digits(); n == 0 {
+		return "", p.errorf("expect a digit, got %s", p.char())
+	} else if n > 1 && p.data[p.pos-n] == '0' {
+		return "", p.errorAt(p.pos-n, "leading zero in number")
+	}
+
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return "", p.errorf("expect a digit, got %s", p.char())
+		}
+	}
+
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return "", p.errorf("expect a digit, got %s", p.char())
+		}
+	}
+
+	if p.pos < len(p.data) && isWord(p.data[p.pos]) {
+		return "", p.errorf("unexpected %s in number", p.char())
+	}
+
+	return json.Number(p.data[start:p.pos]), nil
+}
@@ -0,0 +1,129 @@
+package assetjson
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, v := range []struct {
+		in, out string
+	}{
+		// comments
+		{"// a\n{/* b */\"a\": /* c\n */ 1 // d\n}\n/* e */", `{"a":1}`},
+		{"[1 /* ] */, 2] // ]", `[1,2]`},
+		{`"// not a comment"`, `"// not a comment"`},
+
+		// trailing commas
+		{`[1, 2,]`, `[1,2]`},
+		{`{"a": [1,], "b": {"c": 1,},}`, `{"a":[1],"b":{"c":1}}`},
+		{`[[],{},]`, `[[],{}]`},
+
+		// members keep the order of the file, a repeated key replaces the
+		// value in place
+		{`{"z": 1, "a": 2, "z": 3}`, `{"z":3,"a":2}`},
+
+		// numbers are kept as they are written
+		{`[0, -0, 1.5, -2e10, 3E+2, 0.0, 10]`, `[0,-0,1.5,-2e10,3E+2,0.0,10]`},
+
+		{`[true, false, null]`, `[true,false,null]`},
+		{` "a" `, `"a"`},
+	} {
+		r, e := Parse([]byte(v.in))
+		if e != nil {
+			t.Fatalf("%q: %v", v.in, e)
+		}
+
+		data, e := json.Marshal(r)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if string(data) != v.out {
+			t.Fatalf("%q: got %s, expect %s", v.in, data, v.out)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, v := range []struct {
+		in, out string
+	}{
+		{`"\"\\\/\b\f\n\r\t"`, "\"\\/\b\f\n\r\t"},
+		{`"\u00e9t\u00C9"`, "étÉ"},
+		{`"été"`, "été"},
+		// a surrogate pair is one rune
+		{`"\ud83d\ude00"`, "\U0001F600"},
+		{`"a\uD83D\uDE00b"`, "a\U0001F600b"},
+		// lone surrogates are replaced
+		{`"\ud83d"`, "\uFFFD"},
+		{`"\ude00\ud83d"`, "\uFFFD\uFFFD"},
+		{`"\ud83d\u0041"`, "\uFFFDA"},
+	} {
+		r, e := Parse([]byte(v.in))
+		if e != nil {
+			t.Fatalf("%s: %v", v.in, e)
+		}
+
+		if r != v.out {
+			t.Fatalf("%s: got %q, expect %q", v.in, r, v.out)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, v := range []struct {
+		in           string
+		line, column int
+	}{
+		{"{\n  \"a\": 1,\n  \"b\" 2\n}", 3, 7},
+		// leading zeros
+		{"[1,\n 01]", 2, 2},
+		{"-00", 1, 2},
+		{"[0, 007]", 1, 5},
+		{"/* open", 1, 1},
+		{"[1, 2", 1, 1},
+		{"{\"a\": [1, 2]", 1, 1},
+		{"\"a\tb\"", 1, 3},
+		{"\"abc", 1, 1},
+		{"{\"a\": 1} x", 1, 10},
+		{"[1,,2]", 1, 4},
+		{"[,]", 1, 2},
+		{"{1: 2}", 1, 2},
+		{"{\"a\" 1}", 1, 6},
+		{"\n\n  tru", 3, 3},
+		{"nulls", 1, 1},
+		{"-", 1, 2},
+		{"1.", 1, 3},
+		{"1e", 1, 3},
+		{"1x", 1, 2},
+		{"\"\\x\"", 1, 2},
+		{"\"\\u12\"", 1, 2},
+		{"/ 1", 1, 1},
+		{"// only a comment", 1, 18},
+		{"", 1, 1},
+		// columns count bytes
+		{"\"é\" x", 1, 6},
+	} {
+		_, e := Parse([]byte(v.in))
+
+		var se *SyntaxError
+		if !errors.As(e, &se) {
+			t.Fatalf("%q: got %v, not a SyntaxError", v.in, e)
+		}
+
+		if se.Line != v.line || se.Column != v.column {
+			t.Fatalf("%q: got %v, expect line %d column %d", v.in, se, v.line, v.column)
+		}
+	}
+
+	if _, e := Parse([]byte(strings.Repeat("[", MaxDepth+1))); e == nil {
+		t.Fatal("nested over MaxDepth")
+	}
+
+	if _, e := Parse([]byte(strings.Repeat("[", MaxDepth) + strings.Repeat("]", MaxDepth))); e != nil {
+		t.Fatalf("nested MaxDepth: %v", e)
+	}
+}
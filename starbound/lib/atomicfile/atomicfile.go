@@ -0,0 +1,36 @@
+// Package atomicfile replaces files so that they are never left half written.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file next to name, then renames it over
+// name, so name is either the old or the new file. The file gets perm, even if
+// it exists.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, e := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if e != nil {
+		return e
+	}
+
+	if _, e := f.Write(data); e != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return e
+	}
+
+	if e := f.Close(); e != nil {
+		os.Remove(f.Name())
+		return e
+	}
+
+	if e := os.Chmod(f.Name(), perm); e != nil {
+		os.Remove(f.Name())
+		return e
+	}
+
+	return os.Rename(f.Name(), name)
+}
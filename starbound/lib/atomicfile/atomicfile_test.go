@@ -0,0 +1,42 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.player")
+
+	if e := WriteFile(name, []byte("a"), 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	if e := WriteFile(name, []byte("bc"), 0640); e != nil {
+		t.Fatal(e)
+	}
+
+	data, e := ioutil.ReadFile(name)
+	if e != nil || string(data) != "bc" {
+		t.Fatalf("got %q, %v", data, e)
+	}
+
+	fi, e := os.Stat(name)
+	if e != nil || fi.Mode().Perm() != 0640 {
+		t.Fatalf("got %v, %v", fi.Mode(), e)
+	}
+
+	// no temporary file is left
+	l, e := ioutil.ReadDir(dir)
+	if e != nil || len(l) != 1 {
+		t.Fatalf("got %d files, %v", len(l), e)
+	}
+
+	// nor on failure, the directory of name is missing
+	if e := WriteFile(filepath.Join(dir, "x", "a"), nil, 0644); e == nil {
+		t.Fatal("written in a missing directory")
+	}
+}
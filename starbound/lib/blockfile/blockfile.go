@@ -8,6 +8,7 @@ import (
 )
 
 type BlockFile struct {
+	readonly bool
 	hdrsz    int
 	blksz    int
 	blks     uint
@@ -51,6 +52,47 @@ func NewBlockFile(filename string, hdrsz int) (h *BlockFile, e error) {
 	return h, nil
 }
 
+// OpenBlockFile maps an existing file read only, any write to the blocks
+// will fault.
+func OpenBlockFile(filename string, hdrsz int) (h *BlockFile, e error) {
+	h = &BlockFile{
+		readonly: true,
+		hdrsz:    hdrsz,
+		blks:     0,
+		fmap:     nil,
+	}
+
+	h.file, e = os.Open(filename)
+	if e != nil {
+		return nil, errors.Wrapf(e, "fail to read")
+	}
+
+	fileinfo, e := h.file.Stat()
+	if e != nil {
+		h.file.Close()
+		return nil, errors.Wrapf(e, "fail to stat")
+	}
+
+	h.filesize = fileinfo.Size()
+
+	if h.filesize < int64(hdrsz) {
+		h.file.Close()
+		return nil, errors.New("file is smaller than the header")
+	}
+
+	h.fmap, e = mmap.Map(h.file, mmap.RDONLY, 0)
+	if e != nil {
+		h.file.Close()
+		return nil, errors.Wrapf(e, "fail to mmap")
+	}
+
+	return h, nil
+}
+
+func (h *BlockFile) ReadOnly() bool {
+	return h.readonly
+}
+
 func (h *BlockFile) SetBlksz(blksz int) {
 	h.blksz = blksz
 	h.blks = uint((len(h.fmap) - h.hdrsz) / h.blksz)
@@ -61,6 +103,10 @@ func (h *BlockFile) SetBlksz(blksz int) {
 }
 
 func (h *BlockFile) Grow(blks uint) error {
+	if h.readonly {
+		return errors.New("read only")
+	}
+
 	var e error
 
 	h.filesize += int64(int(blks)) * int64(h.blksz)
@@ -83,6 +129,10 @@ func (h *BlockFile) Grow(blks uint) error {
 }
 
 func (h *BlockFile) Resize(blks uint) error {
+	if h.readonly {
+		return errors.New("read only")
+	}
+
 	var e error
 
 	h.filesize = int64(h.hdrsz) + int64(int(blks))*int64(h.blksz)
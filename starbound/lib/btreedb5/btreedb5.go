@@ -102,14 +102,49 @@ func New(file string, ident string, blksz, keysz int) (h *BTreeDB5, e error) {
 }
 
 func Load(file string) (h *BTreeDB5, e error) {
-	h = &BTreeDB5{}
+	h = &BTreeDB5{
+		used_uncommitted: make(map[uint]bool),
+		free_committed:   make(map[uint]bool),
+		free_uncommitted: make(map[uint]bool),
+		leafmax:          2,
+	}
 
 	h.file, e = blockfile.NewBlockFile(file, 512)
 	if e != nil {
 		return nil, errors.Wrapf(e, "failed to open a block file")
 	}
 
-	h.unmarshalHeader()
+	if e := h.unmarshalHeader(); e != nil {
+		h.file.Close()
+		return nil, e
+	}
+
+	h.file.SetBlksz(h.BlockSize)
+
+	h.readRoot()
+
+	return h, nil
+}
+
+// LoadReadOnly opens a file without ever writing to it, Insert, Remove,
+// Commit and Rollback will fail, and Close will not commit.
+func LoadReadOnly(file string) (h *BTreeDB5, e error) {
+	h = &BTreeDB5{}
+
+	h.file, e = blockfile.OpenBlockFile(file, 512)
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to open a block file")
+	}
+
+	if e := h.unmarshalHeader(); e != nil {
+		h.file.Close()
+		return nil, e
+	}
+
+	if (h.file.Size()-512)%int64(h.BlockSize) != 0 {
+		h.file.Close()
+		return nil, errors.New("file size is not a multiple of the block size")
+	}
 
 	h.file.SetBlksz(h.BlockSize)
 
@@ -119,6 +154,10 @@ func Load(file string) (h *BTreeDB5, e error) {
 }
 
 func (h *BTreeDB5) Close() error {
+	if h.file.ReadOnly() {
+		return h.file.Close()
+	}
+
 	e := h.Commit()
 	if e != nil {
 		return e
@@ -156,14 +195,28 @@ func (h *BTreeDB5) writeRoot() {
 	}
 }
 
-func (h *BTreeDB5) unmarshalHeader() {
-	hdr := h.file.Header()
+// ParseHeader reads the identifier, block size and key size from the first
+// 32 bytes of a btreedb5 file, without loading it.
+func ParseHeader(hdr []byte) (ident string, blksz, keysz int, e error) {
+	if len(hdr) < 32 || !bytes.Equal(hdr[:8], Magic) {
+		return "", 0, 0, errors.New("not a btreedb5 file")
+	}
+
+	blksz = int(byteorder.BigEndian.Int32(hdr[8:]))
+	if blksz <= 0 {
+		return "", 0, 0, errors.Errorf("invalid block size %d", blksz)
+	}
+
+	ident = string(bytes.TrimRight(hdr[12:28], "\x00"))
 
-	h.BlockSize = int(byteorder.BigEndian.Int32(hdr[8:]))
+	keysz = int(byteorder.BigEndian.Int32(hdr[28:]))
 
-	h.Identifier = string(hdr[12:28])
+	return ident, blksz, keysz, nil
+}
 
-	h.KeySize = int(byteorder.BigEndian.Int32(hdr[28:]))
+func (h *BTreeDB5) unmarshalHeader() (e error) {
+	h.Identifier, h.BlockSize, h.KeySize, e = ParseHeader(h.file.Header())
+	return e
 }
 
 func (h *BTreeDB5) readRoot() {
@@ -184,6 +237,7 @@ func (h *BTreeDB5) readRoot() {
 	}
 
 	h.intermax = intermax(h.BlockSize, h.KeySize)
+	h.freemax = freemax(h.BlockSize)
 }
 
 func (h *BTreeDB5) freeNode(ptr uint) *freeNode {
@@ -315,8 +369,6 @@ func (h *BTreeDB5) freelist_gpop() (uint, bool) {
 func (h *BTreeDB5) freelist_pop() (uint, bool) {
 	h.freemu.Lock()
 
-	var r uint
-
 	if len(h.free_uncommitted) == 0 {
 		if h.Tree.FreeIndex == maxptr {
 			h.freemu.Unlock()
@@ -338,10 +390,10 @@ func (h *BTreeDB5) freelist_pop() (uint, bool) {
 		h.Tree.FreeIndex = res.next
 	}
 
-	h.used_uncommitted[r] = true
 	m := h.free_uncommitted
 	for k := range m {
 		delete(m, k)
+		h.used_uncommitted[k] = true
 		h.freemu.Unlock()
 		return k, false
 	}
@@ -368,6 +420,10 @@ func (h *BTreeDB5) freelist_clear() {
 }
 
 func (h *BTreeDB5) Rollback() (e error) {
+	if h.file.ReadOnly() {
+		return errors.New("read only")
+	}
+
 	defer func() {
 		k := recover()
 		if k != nil {
@@ -426,6 +482,10 @@ func (h *BTreeDB5) commit(total map[uint]bool) {
 }
 
 func (h *BTreeDB5) Commit() (e error) {
+	if h.file.ReadOnly() {
+		return errors.New("read only")
+	}
+
 	defer func() {
 		k := recover()
 		if k != nil {
@@ -479,9 +539,7 @@ func (h *BTreeDB5) writeIndexNode(node *indexNode) uint {
 	return node.self
 }
 
-func (h *BTreeDB5) writeLeafNode(node *leafNode) uint {
-	ptr := node.self
-
+func (h *BTreeDB5) freeLeafNode(ptr uint) {
 	for ptr != maxptr {
 		block := h.file.Block(ptr)
 
@@ -493,6 +551,10 @@ func (h *BTreeDB5) writeLeafNode(node *leafNode) uint {
 
 		ptr = uint(byteorder.BigEndian.Uint32(block[h.BlockSize-4:]))
 	}
+}
+
+func (h *BTreeDB5) writeLeafNode(node *leafNode) uint {
+	h.freeLeafNode(node.self)
 
 	buf := &bytes.Buffer{}
 
@@ -1046,6 +1108,10 @@ func (h *BTreeDB5) insertIndex(ptr uint, key Key, data ByteArray) (uint, uint, K
 }
 
 func (h *BTreeDB5) Insert(key Key, data ByteArray) (e error) {
+	if h.file.ReadOnly() {
+		return errors.New("read only")
+	}
+
 	defer func() {
 		k := recover()
 		if k != nil {
@@ -1077,7 +1143,7 @@ func (h *BTreeDB5) Insert(key Key, data ByteArray) (e error) {
 	return nil
 }
 
-func (h *BTreeDB5) removeLeaf(ptr uint, key Key) *leafNode {
+func (h *BTreeDB5) removeLeaf(ptr uint, key Key) (*leafNode, bool) {
 	node := h.leafNode(ptr)
 
 	index, ok := node.find(key)
@@ -1086,10 +1152,91 @@ func (h *BTreeDB5) removeLeaf(ptr uint, key Key) *leafNode {
 		node.removeAt(index)
 	}
 
-	return node
+	return node, ok
+}
+
+// mergeLeaf writes back a changed child of node, merging it into a sibling
+// if it became too small.
+func (h *BTreeDB5) mergeLeaf(node *indexNode, index int, mnode *leafNode) {
+	if (h.BlockSize - 6) <= mnode.size() {
+		node.replaceAtPtr(index, h.writeLeafNode(mnode))
+		return
+	}
+
+	if len(mnode.keys) == 0 && len(node.ptrs) > 1 {
+		h.freeLeafNode(mnode.self)
+		if index > 0 {
+			node.removeAtKey(index - 1)
+		} else {
+			node.removeAtKey(index)
+		}
+		node.removeAtPtr(index)
+		return
+	}
+
+	if index > 0 {
+		lnode := h.leafNode(node.ptrs[index-1])
+		if lnode.size()+mnode.size() < 2*(h.BlockSize-6) {
+			lnode.keys = append(lnode.keys, mnode.keys...)
+			lnode.data = append(lnode.data, mnode.data...)
+			h.freeLeafNode(mnode.self)
+			node.replaceAtPtr(index-1, h.writeLeafNode(lnode))
+			node.removeAtKey(index - 1)
+			node.removeAtPtr(index)
+			return
+		}
+	} else if index+1 < len(node.ptrs) {
+		rnode := h.leafNode(node.ptrs[index+1])
+		if rnode.size()+mnode.size() < 2*(h.BlockSize-6) {
+			mnode.keys = append(mnode.keys, rnode.keys...)
+			mnode.data = append(mnode.data, rnode.data...)
+			h.freeLeafNode(rnode.self)
+			node.replaceAtPtr(index, h.writeLeafNode(mnode))
+			node.removeAtKey(index)
+			node.removeAtPtr(index + 1)
+			return
+		}
+	}
+
+	node.replaceAtPtr(index, h.writeLeafNode(mnode))
+}
+
+// mergeIndex is mergeLeaf for index nodes, the separator key of node moves
+// down into the merged node.
+func (h *BTreeDB5) mergeIndex(node *indexNode, index int, mnode *indexNode) {
+	if len(mnode.ptrs) > h.intermax/2 {
+		node.replaceAtPtr(index, h.writeIndexNode(mnode))
+		return
+	}
+
+	if index > 0 {
+		lnode := h.indexNode(node.ptrs[index-1])
+		if len(lnode.ptrs)+len(mnode.ptrs) <= h.intermax {
+			lnode.keys = append(append(lnode.keys, node.keys[index-1]), mnode.keys...)
+			lnode.ptrs = append(lnode.ptrs, mnode.ptrs...)
+			h.freelist_push(mnode.self)
+			node.replaceAtPtr(index-1, h.writeIndexNode(lnode))
+			node.removeAtKey(index - 1)
+			node.removeAtPtr(index)
+			return
+		}
+	} else if index+1 < len(node.ptrs) {
+		rnode := h.indexNode(node.ptrs[index+1])
+		if len(rnode.ptrs)+len(mnode.ptrs) <= h.intermax {
+			mnode.keys = append(append(mnode.keys, node.keys[index]), rnode.keys...)
+			mnode.ptrs = append(mnode.ptrs, rnode.ptrs...)
+			h.freelist_push(rnode.self)
+			node.replaceAtPtr(index, h.writeIndexNode(mnode))
+			node.removeAtKey(index)
+			node.removeAtPtr(index + 1)
+			return
+		}
+	}
+
+	node.replaceAtPtr(index, h.writeIndexNode(mnode))
 }
 
-func (h *BTreeDB5) removeIndex(ptr uint, key Key) *indexNode {
+func (h *BTreeDB5) removeIndex(ptr uint, key Key) (*indexNode, bool) {
 	node := h.indexNode(ptr)
 
 	index, ok := node.find(key)
@@ -1099,95 +1246,25 @@ func (h *BTreeDB5) removeIndex(ptr uint, key Key) *indexNode {
 	}
 
 	if node.height == 0 {
-		mnode := h.removeLeaf(node.ptrs[index], key)
-
-		if (h.BlockSize - 6) > mnode.size() {
-			if index > 0 {
-				lnode := h.leafNode(node.ptrs[index-1])
-				if (h.BlockSize - 6) < lnode.size() {
-					rkey, rdata := lnode.removeAt(len(lnode.keys) - 1)
-					mnode.insertAt(0, rkey, rdata)
-					node.replaceAtKey(index-1, rkey)
-					node.replaceAtPtr(index-1, h.writeLeafNode(lnode))
-					node.replaceAtPtr(index, h.writeLeafNode(mnode))
-				} else {
-					mnode.keys = append(lnode.keys, mnode.keys...)
-					mnode.data = append(lnode.data, mnode.data...)
-					node.replaceAtPtr(index, h.writeLeafNode(mnode))
-					node.removeAtKey(index - 1)
-					node.removeAtPtr(index - 1)
-					h.freelist_push(lnode.self)
-				}
-			} else if index+1 < len(node.ptrs) {
-				rnode := h.leafNode(node.ptrs[index+1])
-				if (h.BlockSize - 6) < rnode.size() {
-					rkey, rdata := rnode.removeAt(0)
-					mnode.insertAt(len(mnode.keys), rkey, rdata)
-					node.replaceAtKey(index, rkey)
-					node.replaceAtPtr(index+1, h.writeLeafNode(rnode))
-					node.replaceAtPtr(index, h.writeLeafNode(mnode))
-				} else {
-					mnode.keys = append(mnode.keys, rnode.keys...)
-					mnode.data = append(mnode.data, rnode.data...)
-					node.replaceAtPtr(index, h.writeLeafNode(mnode))
-					node.removeAtKey(index)
-					node.removeAtPtr(index + 1)
-					h.freelist_push(rnode.self)
-				}
-			}
-			// no more siblings
-		}
-	} else {
-		mnode := h.removeIndex(node.ptrs[index], key)
-		node.height = mnode.height + 1
-
-		if len(mnode.ptrs) <= (h.freemax+1)/2 {
-			if index > 0 {
-				lnode := h.indexNode(node.ptrs[index-1])
-				if len(lnode.ptrs) > (h.freemax+1)/2 {
-					mnode.insertAtPtr(0, lnode.removeAtPtr(len(lnode.ptrs)-1))
-					mnode.insertAtKey(0, node.keys[index-1])
-					node.replaceAtKey(index-1, lnode.removeAtKey(len(lnode.keys)-1))
-					node.replaceAtPtr(index-1, h.writeIndexNode(lnode))
-					node.replaceAtPtr(index, h.writeIndexNode(mnode))
-				} else {
-					lnode.keys = append(lnode.keys, node.keys[index-1])
-					mnode.keys = append(lnode.keys, mnode.keys...)
-					mnode.ptrs = append(lnode.ptrs, mnode.ptrs...)
-					node.replaceAtPtr(index, h.writeIndexNode(mnode))
-					node.removeAtKey(index - 1)
-					node.removeAtPtr(index - 1)
-					h.freelist_push(lnode.self)
-				}
-			} else if index+1 < len(node.ptrs) {
-				rnode := h.indexNode(node.ptrs[index+1])
-				if len(rnode.ptrs) > (h.freemax+1)/2 {
-					mnode.insertAtPtr(len(mnode.ptrs), rnode.removeAtPtr(0))
-					mnode.insertAtKey(len(mnode.keys), node.keys[index])
-					node.replaceAtKey(index, rnode.removeAtKey(0))
-					node.replaceAtPtr(index, h.writeIndexNode(mnode))
-					node.replaceAtPtr(index+1, h.writeIndexNode(rnode))
-				} else {
-					mnode.keys = append(mnode.keys, node.keys[index])
-					mnode.keys = append(mnode.keys, rnode.keys...)
-					mnode.ptrs = append(mnode.ptrs, rnode.ptrs...)
-					node.replaceAtPtr(index, h.writeIndexNode(mnode))
-					node.removeAtKey(index)
-					node.removeAtPtr(index + 1)
-					h.freelist_push(rnode.self)
-				}
-			} else {
-				// no more siblings
-				h.freelist_push(node.self)
-				return mnode
-			}
+		mnode, ok := h.removeLeaf(node.ptrs[index], key)
+		if ok {
+			h.mergeLeaf(node, index, mnode)
 		}
+		return node, ok
 	}
 
-	return node
+	mnode, ok := h.removeIndex(node.ptrs[index], key)
+	if ok {
+		h.mergeIndex(node, index, mnode)
+	}
+	return node, ok
 }
 
 func (h *BTreeDB5) Remove(key Key) (e error) {
+	if h.file.ReadOnly() {
+		return errors.New("read only")
+	}
+
 	defer func() {
 		k := recover()
 		if k != nil {
@@ -1196,20 +1273,35 @@ func (h *BTreeDB5) Remove(key Key) (e error) {
 	}()
 
 	if h.Tree.RootIsLeaf {
-		lnode := h.removeLeaf(h.Tree.RootBlock, key)
-		h.Tree.RootBlock = h.writeLeafNode(lnode)
-	} else {
-		rnode := h.removeIndex(h.Tree.RootBlock, key)
-		if len(rnode.ptrs) > 1 {
-			h.Tree.RootBlock = h.writeIndexNode(rnode)
-		} else {
-			h.freelist_push(rnode.self)
-			h.Tree.RootBlock = rnode.ptrs[0]
-			if rnode.height == 0 {
-				h.Tree.RootIsLeaf = true
-			}
+		lnode, ok := h.removeLeaf(h.Tree.RootBlock, key)
+		if ok {
+			h.Tree.RootBlock = h.writeLeafNode(lnode)
 		}
+		return nil
 	}
 
-	return nil
+	rnode, ok := h.removeIndex(h.Tree.RootBlock, key)
+	if !ok {
+		return nil
+	}
+
+	if len(rnode.ptrs) > 1 {
+		h.Tree.RootBlock = h.writeIndexNode(rnode)
+		return nil
+	}
+
+	// the root has a single child left, let it be the new root
+	for {
+		h.freelist_push(rnode.self)
+		h.Tree.RootBlock = rnode.ptrs[0]
+		if rnode.height == 0 {
+			h.Tree.RootIsLeaf = true
+			return nil
+		}
+
+		rnode = h.indexNode(h.Tree.RootBlock)
+		if len(rnode.ptrs) > 1 {
+			return nil
+		}
+	}
 }
@@ -1,5 +1,190 @@
 package btreedb5
 
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// check compares the tree with want, by Get, Has and Ascend.
+func check(t *testing.T, h *BTreeDB5, want map[string][]byte, removed map[string]bool) {
+	t.Helper()
+
+	for k, v := range want {
+		r, e := h.Get(Key(k))
+		if e != nil {
+			t.Fatalf("get %x: %v", k, e)
+		}
+		if !bytes.Equal(r, v) {
+			t.Fatalf("get %x: got %d bytes, expect %d", k, len(r), len(v))
+		}
+	}
+
+	for k := range removed {
+		if _, e := h.Get(Key(k)); e == nil {
+			t.Fatalf("get %x: removed key found", k)
+		}
+	}
+
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	i := 0
+	e := h.Ascend(func(key Key, data []byte) {
+		if i >= len(keys) || string(key) != keys[i] {
+			t.Fatalf("ascend %d: got %x", i, key)
+		}
+		i++
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if i != len(keys) {
+		t.Fatalf("ascend: %d keys, expect %d", i, len(keys))
+	}
+}
+
+func TestInsertRemove(t *testing.T) {
+	for _, blksz := range []int{512, 2048} {
+		name := filepath.Join(t.TempDir(), "test.db")
+		rnd := rand.New(rand.NewSource(int64(blksz)))
+
+		h, e := New(name, "Test", blksz, 5)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		want := map[string][]byte{}
+		removed := map[string]bool{}
+
+		key := func() Key {
+			k := make(Key, 5)
+			rnd.Read(k[:3])
+			return k
+		}
+
+		// values up to a block, so leaves overflow into more blocks
+		for i := 0; i < 3000; i++ {
+			k := key()
+			v := make([]byte, rnd.Intn(blksz))
+			rnd.Read(v)
+
+			if e := h.Insert(k, v); e != nil {
+				t.Fatalf("%d: insert %x: %v", blksz, k, e)
+			}
+			want[string(k)] = v
+			delete(removed, string(k))
+		}
+		check(t, h, want, removed)
+
+		// remove about half, then put some back
+		for k := range want {
+			if rnd.Intn(2) == 0 {
+				if e := h.Remove(Key(k)); e != nil {
+					t.Fatalf("%d: remove %x: %v", blksz, k, e)
+				}
+				delete(want, k)
+				removed[k] = true
+			}
+		}
+		check(t, h, want, removed)
+
+		n := 0
+		for k := range removed {
+			if n++; n > 500 {
+				break
+			}
+			if e := h.Insert(Key(k), []byte{byte(n)}); e != nil {
+				t.Fatal(e)
+			}
+			want[k] = []byte{byte(n)}
+			delete(removed, k)
+		}
+		check(t, h, want, removed)
+
+		if e := h.Close(); e != nil {
+			t.Fatal(e)
+		}
+
+		h, e = Load(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		check(t, h, want, removed)
+
+		// remove all, in a random order
+		keys := []string{}
+		for k := range want {
+			keys = append(keys, k)
+		}
+		rnd.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+		for _, k := range keys {
+			if e := h.Remove(Key(k)); e != nil {
+				t.Fatalf("%d: remove %x: %v", blksz, k, e)
+			}
+			delete(want, k)
+			removed[k] = true
+		}
+		check(t, h, want, removed)
+
+		if !h.Tree.RootIsLeaf {
+			t.Fatalf("%d: root is not a leaf after removing all", blksz)
+		}
+
+		if _, _, e := h.First(); e == nil {
+			t.Fatalf("%d: empty tree has a first key", blksz)
+		}
+
+		// the empty tree is still usable
+		if e := h.Insert(Key("abcde"), []byte{1}); e != nil {
+			t.Fatal(e)
+		}
+		want["abcde"] = []byte{1}
+
+		if e := h.Close(); e != nil {
+			t.Fatal(e)
+		}
+
+		h, e = LoadReadOnly(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		check(t, h, want, nil)
+		h.Close()
+	}
+}
+
+func TestRemoveMissing(t *testing.T) {
+	h, e := New(filepath.Join(t.TempDir(), "test.db"), "Test", 512, 5)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer h.Close()
+
+	for i := 0; i < 200; i++ {
+		if e := h.Insert(Key{0, 0, byte(i), 0, 0}, []byte{byte(i)}); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// a missing key changes nothing
+	if e := h.Remove(Key{1, 0, 0, 0, 0}); e != nil {
+		t.Fatal(e)
+	}
+
+	want := map[string][]byte{}
+	for i := 0; i < 200; i++ {
+		want[string([]byte{0, 0, byte(i), 0, 0})] = []byte{byte(i)}
+	}
+	check(t, h, want, nil)
+}
+
 /*
 func TestApi(b *testing.T) {
 	h, e := New("test2", "fuck", 512, 5)
@@ -0,0 +1,505 @@
+package celestial
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/codec"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// universe.chunks, every record is a zlib compressed versioned json of a
+// chunk, with its header
+const (
+	Identifier = "Celestial2"
+	BlockSize  = 2048
+	KeySize    = 16
+	// ChunkId is the id in the header of chunks.
+	ChunkId = "CelestialChunk"
+)
+
+type Vec2 [2]int64
+type Vec3 [3]int64
+
+type Coordinate struct {
+	Location  Vec3  `json:"location"`
+	Planet    int64 `json:"planet"`
+	Satellite int64 `json:"satellite"`
+}
+
+// Parameters of a system, planet or satellite. Parameters and
+// VisitableParameters are kept as sbvj01 values, members not known here are
+// kept in Extra, in their order.
+type Parameters struct {
+	Coordinate          Coordinate     `json:"coordinate"`
+	Seed                int64          `json:"seed"`
+	Name                string         `json:"name"`
+	Parameters          interface{}    `json:"parameters"`
+	VisitableParameters interface{}    `json:"visitableParameters"`
+	Extra               *sbvj01.Object `json:"extra,omitempty"`
+}
+
+type SystemParameters struct {
+	Location   Vec3       `json:"location"`
+	Parameters Parameters `json:"parameters"`
+}
+
+// Object is a planet, or a satellite if Orbit[1] is not 0.
+type Object struct {
+	Orbit      Vec2       `json:"orbit"`
+	Parameters Parameters `json:"parameters"`
+}
+
+type SystemObjects struct {
+	Location Vec3     `json:"location"`
+	Objects  []Object `json:"objects"`
+}
+
+// Chunk is a region of the universe, with the systems generated in it. Hdr is
+// the versioned json header of its record, which is written back as it is.
+type Chunk struct {
+	Hdr              sbvj01.VerJsonHdr  `json:"-"`
+	Index            Vec2               `json:"chunkIndex"`
+	Constellations   [][][2]Vec2        `json:"constellations"`
+	SystemParameters []SystemParameters `json:"systemParameters"`
+	SystemObjects    []SystemObjects    `json:"systemObjects"`
+}
+
+func integer(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case data_types.Varint:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	default:
+		return 0, errors.Errorf("%v is not a number", v)
+	}
+}
+
+func array(v interface{}, n int) ([]interface{}, error) {
+	l, ok := v.([]interface{})
+	if !ok || (n >= 0 && len(l) != n) {
+		return nil, errors.Errorf("%v is not an array of %d", v, n)
+	}
+	return l, nil
+}
+
+func vec(v interface{}, r []int64) error {
+	l, e := array(v, len(r))
+	if e != nil {
+		return e
+	}
+
+	for k := range r {
+		r[k], e = integer(l[k])
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func member(doc interface{}, name string) (interface{}, error) {
+	return jsonpatch.Get(doc, jsonpatch.Pointer{name})
+}
+
+func (c *Coordinate) SetDocument(doc interface{}) error {
+	v, e := member(doc, "location")
+	if e != nil {
+		return e
+	}
+
+	if e := vec(v, c.Location[:]); e != nil {
+		return errors.Wrapf(e, "location")
+	}
+
+	if v, e = member(doc, "planet"); e != nil {
+		return e
+	}
+
+	if c.Planet, e = integer(v); e != nil {
+		return errors.Wrapf(e, "planet")
+	}
+
+	if v, e = member(doc, "satellite"); e != nil {
+		return e
+	}
+
+	if c.Satellite, e = integer(v); e != nil {
+		return errors.Wrapf(e, "satellite")
+	}
+
+	return nil
+}
+
+// Document is the reverse of SetDocument, members are in the order of the
+// game.
+func (c Coordinate) Document() *sbvj01.Object {
+	r := sbvj01.NewObject()
+	r.Set("location", []interface{}{c.Location[0], c.Location[1], c.Location[2]})
+	r.Set("planet", c.Planet)
+	r.Set("satellite", c.Satellite)
+	return r
+}
+
+func (p *Parameters) SetDocument(doc interface{}) error {
+	m, ok := sbvj01.Members(doc)
+	if !ok {
+		return errors.New("parameters is not an object")
+	}
+
+	*p = Parameters{}
+	for _, k := range sbvj01.Keys(doc) {
+		v := m[k]
+		var e error
+		switch k {
+		case "coordinate":
+			e = p.Coordinate.SetDocument(v)
+		case "seed":
+			p.Seed, e = integer(v)
+		case "name":
+			switch n := v.(type) {
+			case string:
+				p.Name = n
+			case data_types.String:
+				p.Name = string(n)
+			default:
+				e = errors.New("not a string")
+			}
+		case "parameters":
+			p.Parameters = v
+		case "visitableParameters":
+			p.VisitableParameters = v
+		default:
+			if p.Extra == nil {
+				p.Extra = sbvj01.NewObject()
+			}
+			p.Extra.Set(k, v)
+		}
+		if e != nil {
+			return errors.Wrapf(e, "%s", k)
+		}
+	}
+
+	return nil
+}
+
+// Document is the reverse of SetDocument, members are in the order of the
+// game, then Extra.
+func (p Parameters) Document() *sbvj01.Object {
+	r := sbvj01.NewObject()
+	r.Set("coordinate", p.Coordinate.Document())
+	r.Set("seed", p.Seed)
+	r.Set("name", p.Name)
+	r.Set("parameters", p.Parameters)
+	r.Set("visitableParameters", p.VisitableParameters)
+	if p.Extra != nil {
+		for _, k := range p.Extra.Keys() {
+			v, _ := p.Extra.Get(k)
+			r.Set(k, v)
+		}
+	}
+	return r
+}
+
+// SetDocument decodes the json layout the game stores.
+func (c *Chunk) SetDocument(doc interface{}) error {
+	v, e := member(doc, "chunkIndex")
+	if e != nil {
+		return e
+	}
+
+	if e := vec(v, c.Index[:]); e != nil {
+		return errors.Wrapf(e, "chunkIndex")
+	}
+
+	if v, e = member(doc, "constellations"); e != nil {
+		return e
+	}
+
+	l, e := array(v, -1)
+	if e != nil {
+		return errors.Wrapf(e, "constellations")
+	}
+
+	c.Constellations = make([][][2]Vec2, len(l))
+	for k := range l {
+		lines, e := array(l[k], -1)
+		if e != nil {
+			return errors.Wrapf(e, "constellations/%d", k)
+		}
+
+		c.Constellations[k] = make([][2]Vec2, len(lines))
+		for i := range lines {
+			line, e := array(lines[i], 2)
+			if e != nil {
+				return errors.Wrapf(e, "constellations/%d/%d", k, i)
+			}
+
+			for j := range line {
+				if e := vec(line[j], c.Constellations[k][i][j][:]); e != nil {
+					return errors.Wrapf(e, "constellations/%d/%d/%d", k, i, j)
+				}
+			}
+		}
+	}
+
+	if v, e = member(doc, "systemParameters"); e != nil {
+		return e
+	}
+
+	if l, e = array(v, -1); e != nil {
+		return errors.Wrapf(e, "systemParameters")
+	}
+
+	c.SystemParameters = make([]SystemParameters, len(l))
+	for k := range l {
+		pair, e := array(l[k], 2)
+		if e != nil {
+			return errors.Wrapf(e, "systemParameters/%d", k)
+		}
+
+		if e := vec(pair[0], c.SystemParameters[k].Location[:]); e != nil {
+			return errors.Wrapf(e, "systemParameters/%d/0", k)
+		}
+
+		if e := c.SystemParameters[k].Parameters.SetDocument(pair[1]); e != nil {
+			return errors.Wrapf(e, "systemParameters/%d/1", k)
+		}
+	}
+
+	if v, e = member(doc, "systemObjects"); e != nil {
+		return e
+	}
+
+	if l, e = array(v, -1); e != nil {
+		return errors.Wrapf(e, "systemObjects")
+	}
+
+	c.SystemObjects = make([]SystemObjects, len(l))
+	for k := range l {
+		pair, e := array(l[k], 2)
+		if e != nil {
+			return errors.Wrapf(e, "systemObjects/%d", k)
+		}
+
+		if e := vec(pair[0], c.SystemObjects[k].Location[:]); e != nil {
+			return errors.Wrapf(e, "systemObjects/%d/0", k)
+		}
+
+		objs, e := array(pair[1], -1)
+		if e != nil {
+			return errors.Wrapf(e, "systemObjects/%d/1", k)
+		}
+
+		c.SystemObjects[k].Objects = make([]Object, len(objs))
+		for i := range objs {
+			obj, e := array(objs[i], 2)
+			if e != nil {
+				return errors.Wrapf(e, "systemObjects/%d/1/%d", k, i)
+			}
+
+			if e := vec(obj[0], c.SystemObjects[k].Objects[i].Orbit[:]); e != nil {
+				return errors.Wrapf(e, "systemObjects/%d/1/%d/0", k, i)
+			}
+
+			if e := c.SystemObjects[k].Objects[i].Parameters.SetDocument(obj[1]); e != nil {
+				return errors.Wrapf(e, "systemObjects/%d/1/%d/1", k, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Document is the reverse of SetDocument, members are in the order of the
+// game.
+func (c *Chunk) Document() *sbvj01.Object {
+	vec2 := func(v Vec2) []interface{} {
+		return []interface{}{v[0], v[1]}
+	}
+	vec3 := func(v Vec3) []interface{} {
+		return []interface{}{v[0], v[1], v[2]}
+	}
+
+	constellations := make([]interface{}, len(c.Constellations))
+	for k, v := range c.Constellations {
+		lines := make([]interface{}, len(v))
+		for i, line := range v {
+			lines[i] = []interface{}{vec2(line[0]), vec2(line[1])}
+		}
+		constellations[k] = lines
+	}
+
+	params := make([]interface{}, len(c.SystemParameters))
+	for k, v := range c.SystemParameters {
+		params[k] = []interface{}{vec3(v.Location), v.Parameters.Document()}
+	}
+
+	objects := make([]interface{}, len(c.SystemObjects))
+	for k, v := range c.SystemObjects {
+		objs := make([]interface{}, len(v.Objects))
+		for i, obj := range v.Objects {
+			objs[i] = []interface{}{vec2(obj.Orbit), obj.Parameters.Document()}
+		}
+		objects[k] = []interface{}{vec3(v.Location), objs}
+	}
+
+	r := sbvj01.NewObject()
+	r.Set("chunkIndex", vec2(c.Index))
+	r.Set("constellations", constellations)
+	r.Set("systemParameters", params)
+	r.Set("systemObjects", objects)
+	return r
+}
+
+// System returns the parameters and objects of the system at loc.
+func (c *Chunk) System(loc Vec3) (*Parameters, []Object) {
+	var p *Parameters
+	for k := range c.SystemParameters {
+		if c.SystemParameters[k].Location == loc {
+			p = &c.SystemParameters[k].Parameters
+		}
+	}
+
+	for k := range c.SystemObjects {
+		if c.SystemObjects[k].Location == loc {
+			return p, c.SystemObjects[k].Objects
+		}
+	}
+
+	return p, nil
+}
+
+// ReadChunk decodes a compressed record.
+func ReadChunk(data []byte) (*Chunk, error) {
+	z, e := zlib.NewReader(bytes.NewReader(data))
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to inflate record")
+	}
+	defer z.Close()
+
+	raw, e := ioutil.ReadAll(z)
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to inflate record")
+	}
+
+	rd := bytes.NewReader(raw)
+
+	vj, e := sbvj01.ReadVersioned(rd, sbvj01.FormHdr)
+	if e != nil {
+		return nil, e
+	}
+
+	if rd.Len() != 0 {
+		return nil, errors.Errorf("%d trailing bytes", rd.Len())
+	}
+
+	if vj.Id != ChunkId {
+		return nil, errors.Errorf("versioned json %q is not a chunk", vj.Id)
+	}
+
+	c := &Chunk{}
+	if e := c.SetDocument(vj.Content); e != nil {
+		return nil, e
+	}
+	c.Hdr = vj.VerJsonHdr
+
+	return c, nil
+}
+
+// Bytes encodes and compresses the chunk, with Hdr.
+func (c *Chunk) Bytes() ([]byte, error) {
+	if c.Hdr.Id != ChunkId {
+		return nil, errors.Errorf("versioned json %q is not a chunk", c.Hdr.Id)
+	}
+
+	buf := &bytes.Buffer{}
+
+	zw, e := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if e != nil {
+		return nil, e
+	}
+
+	if e := sbvj01.WriteVersioned(zw, &sbvj01.VersionedJson{VerJsonHdr: c.Hdr, Content: c.Document()}, sbvj01.FormHdr); e != nil {
+		return nil, e
+	}
+
+	if e := zw.Close(); e != nil {
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Chunks calls fn for every chunk in a celestial database.
+func Chunks(h *btreedb5.BTreeDB5, fn func(btreedb5.Key, *Chunk) error) error {
+	var err error
+	e := h.Ascend(func(key btreedb5.Key, data []byte) {
+		if err != nil {
+			return
+		}
+
+		c, e := ReadChunk(data)
+		if e != nil {
+			err = errors.Wrapf(e, "%x", key)
+			return
+		}
+
+		err = fn(key, c)
+	})
+	if e != nil {
+		return e
+	}
+
+	return err
+}
+
+func init() {
+	codec.Register(&codec.Format{
+		Identifier: Identifier,
+		BlockSize:  BlockSize,
+		KeySize:    KeySize,
+		Codec:      chunkCodec{},
+	})
+}
+
+// chunkCodec decodes records to the json layout the game stores, within the
+// header as dumpsbvj01 outputs, so that the version is kept.
+type chunkCodec struct{}
+
+func (chunkCodec) Decode(key btreedb5.Key, data []byte) (interface{}, error) {
+	c, e := ReadChunk(data)
+	if e != nil {
+		return nil, e
+	}
+
+	vj := &sbvj01.VersionedJson{VerJsonHdr: c.Hdr, Content: c.Document()}
+	return vj.Document(), nil
+}
+
+func (chunkCodec) Encode(key btreedb5.Key, doc interface{}) ([]byte, error) {
+	vj := &sbvj01.VersionedJson{}
+	if e := vj.SetDocument(doc); e != nil {
+		return nil, e
+	}
+
+	c := &Chunk{}
+	if e := c.SetDocument(vj.Content); e != nil {
+		return nil, e
+	}
+	c.Hdr = vj.VerJsonHdr
+
+	return c.Bytes()
+}
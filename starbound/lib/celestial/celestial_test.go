@@ -0,0 +1,177 @@
+package celestial
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/codec"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// str is a string of sbvj01, its length then the bytes
+func str(s string) string {
+	return fmt.Sprintf("%02x", len(s)) + hex.EncodeToString([]byte(s))
+}
+
+// the parameters of a system named Alpha at 1,2,3 with seed 7, and two
+// members unknown here, not sorted. varints are zigzag encoded
+var params = "0707" +
+	str("coordinate") + "0703" +
+	str("location") + "0603" + "0402" + "0404" + "0406" +
+	str("planet") + "0400" +
+	str("satellite") + "0400" +
+	str("seed") + "040e" +
+	str("name") + "05" + str("Alpha") +
+	str("parameters") + "0700" +
+	str("visitableParameters") + "01" +
+	str("zeta") + "0401" +
+	str("alpha") + "01"
+
+// chunk -1,2 with a constellation line from 0,1 to 2,3, and a planet of the
+// system at orbit 5
+var chunk = "0704" +
+	str("chunkIndex") + "0602" + "0402" + "0403" +
+	str("constellations") + "0601" + "0601" + "0602" + "0602" + "0400" + "0402" + "0602" + "0404" + "0406" +
+	str("systemParameters") + "0601" + "0602" + "0603" + "0402" + "0404" + "0406" + params +
+	str("systemObjects") + "0601" + "0602" + "0603" + "0402" + "0404" + "0406" + "0601" + "0602" + "0602" + "040a" + "0400" + params
+
+// record compresses a chunk as the game stores it
+func record(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, e := hex.DecodeString(s)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+// inflate is the reverse of record
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	z, e := zlib.NewReader(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	r, e := ioutil.ReadAll(z)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return r
+}
+
+func TestChunk(t *testing.T) {
+	// CelestialChunk, versioned, version 2
+	raw := str(ChunkId) + "01" + "00000002" + chunk
+	data := record(t, raw)
+
+	c, e := ReadChunk(data)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if c.Hdr != (sbvj01.VerJsonHdr{Id: ChunkId, Versioned: true, Version: 2}) {
+		t.Fatalf("header %+v", c.Hdr)
+	}
+
+	if c.Index != (Vec2{1, -2}) {
+		t.Fatalf("index %v", c.Index)
+	}
+
+	if !reflect.DeepEqual(c.Constellations, [][][2]Vec2{{{{0, 1}, {2, 3}}}}) {
+		t.Fatalf("constellations %v", c.Constellations)
+	}
+
+	p, objs := c.System(Vec3{1, 2, 3})
+	if p == nil || p.Name != "Alpha" || p.Seed != 7 || p.Coordinate.Location != (Vec3{1, 2, 3}) {
+		t.Fatalf("system parameters %+v", p)
+	}
+
+	if len(objs) != 1 || objs[0].Orbit != (Vec2{5, 0}) || objs[0].Parameters.Name != "Alpha" {
+		t.Fatalf("system objects %+v", objs)
+	}
+
+	if p.Extra == nil || !reflect.DeepEqual(p.Extra.Keys(), []string{"zeta", "alpha"}) {
+		t.Fatalf("extra %+v", p.Extra)
+	}
+
+	// members are written in the order of the game, each time
+	var r []byte
+	for i := 0; i < 5; i++ {
+		if r, e = c.Bytes(); e != nil {
+			t.Fatal(e)
+		}
+
+		if out := hex.EncodeToString(inflate(t, r)); out != raw {
+			t.Fatalf("got\n%s\nexpect\n%s", out, raw)
+		}
+	}
+
+	c2, e := ReadChunk(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if !reflect.DeepEqual(c, c2) {
+		t.Fatalf("got %+v, expect %+v", c2, c)
+	}
+
+	// the codec keeps the header
+	f, e := codec.Lookup(Identifier)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	doc, e := f.Codec.Decode(nil, data)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if r, e = f.Codec.Encode(nil, doc); e != nil {
+		t.Fatal(e)
+	}
+
+	if out := hex.EncodeToString(inflate(t, r)); out != raw {
+		t.Fatalf("codec: got\n%s\nexpect\n%s", out, raw)
+	}
+
+	if c2, e = ReadChunk(r); e != nil {
+		t.Fatal(e)
+	}
+
+	if !reflect.DeepEqual(c, c2) {
+		t.Fatalf("got %+v, expect %+v", c2, c)
+	}
+
+	for _, v := range []struct {
+		name string
+		data []byte
+	}{
+		{"no header", record(t, chunk)},
+		{"not a chunk", record(t, str("CelestialSystem")+"01"+"00000002"+chunk)},
+		{"trailing", record(t, str(ChunkId)+"01"+"00000002"+chunk+"01")},
+		{"not compressed", []byte(chunk)},
+	} {
+		if _, e := ReadChunk(v.data); e == nil {
+			t.Fatalf("%s: no error", v.name)
+		}
+	}
+
+	c.Hdr = sbvj01.VerJsonHdr{}
+	if _, e := c.Bytes(); e == nil {
+		t.Fatal("chunk without header written")
+	}
+}
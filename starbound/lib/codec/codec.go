@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+)
+
+// Codec converts the records of a btreedb5 file from and to documents that
+// encoding/json could marshal. Records it does not understand are decoded to
+// their raw []byte content, and Encode accepts them back.
+type Codec interface {
+	Decode(key btreedb5.Key, data []byte) (interface{}, error)
+	Encode(key btreedb5.Key, doc interface{}) ([]byte, error)
+}
+
+// Format describes the files with a given header identifier.
+type Format struct {
+	Identifier string
+	BlockSize  int
+	KeySize    int
+	Codec      Codec
+}
+
+var formats = map[string]*Format{}
+
+func Register(f *Format) {
+	formats[f.Identifier] = f
+}
+
+func Lookup(identifier string) (*Format, error) {
+	f, ok := formats[identifier]
+	if !ok {
+		return nil, errors.Errorf("unknown identifier %q", identifier)
+	}
+	return f, nil
+}
+
+func Formats() []*Format {
+	r := make([]*Format, 0, len(formats))
+	for _, v := range formats {
+		r = append(r, v)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Identifier < r[j].Identifier
+	})
+	return r
+}
+
+// Open loads a file and looks up its format.
+func Open(file string, readonly bool) (*btreedb5.BTreeDB5, *Format, error) {
+	var h *btreedb5.BTreeDB5
+	var e error
+	if readonly {
+		h, e = btreedb5.LoadReadOnly(file)
+	} else {
+		h, e = btreedb5.Load(file)
+	}
+	if e != nil {
+		return nil, nil, e
+	}
+
+	f, e := Lookup(h.Identifier)
+	if e != nil {
+		h.Close()
+		return nil, nil, e
+	}
+
+	return h, f, nil
+}
@@ -1,6 +1,9 @@
 package data_types
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/xhebox/bstruct/byteorder"
@@ -8,20 +11,60 @@ import (
 
 type ByteArray []byte
 
-func (this *ByteArray) Read(rd io.Reader, endian byteorder.ByteOrder) error {
+// MaxByteArray is the longest ByteArray, or String, that Read and ReadBuf
+// accept, and the default of ReadByteArray.
+var MaxByteArray uint64 = 64 << 20
+
+var ErrTooLong = errors.New("too long")
+
+// ReadN reads n bytes. The buffer grows as the bytes arrive, so a forged
+// length fails at the end of the input instead of being allocated first.
+func ReadN(rd io.Reader, n uint64) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if n < bytes.MinRead {
+		buf.Grow(int(n))
+	}
+
+	r, e := io.CopyN(buf, rd, int64(n))
+	if uint64(r) != n {
+		if e == io.EOF || e == nil {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ReadByteArray reads a ByteArray of at most max bytes, MaxByteArray if max
+// is 0, so that callers with their own limits, like sbvj01.Limits, apply
+// them instead of the global one.
+func ReadByteArray(rd io.Reader, endian byteorder.ByteOrder, max uint64) (ByteArray, error) {
+	if max == 0 {
+		max = MaxByteArray
+	}
+
 	u := UVarint(0)
 
 	e := u.Read(rd, endian)
 	if e != nil {
-		return e
+		return nil, e
 	}
 
-	*this = make([]byte, u)
+	if uint64(u) > max {
+		return nil, fmt.Errorf("%d bytes, max %d: %w", u, max, ErrTooLong)
+	}
 
-	if _, e := io.ReadFull(rd, *this); e != nil {
+	return ReadN(rd, uint64(u))
+}
+
+func (this *ByteArray) Read(rd io.Reader, endian byteorder.ByteOrder) error {
+	r, e := ReadByteArray(rd, endian, 0)
+	if e != nil {
 		return e
 	}
 
+	*this = r
 	return nil
 }
 
@@ -33,6 +76,14 @@ func (this *ByteArray) ReadBuf(buf []byte, endian byteorder.ByteOrder) (int, err
 		return 0, e
 	}
 
+	if uint64(u) > MaxByteArray {
+		return l, fmt.Errorf("%d bytes, max %d: %w", u, MaxByteArray, ErrTooLong)
+	}
+
+	if uint64(u) > uint64(len(buf)-l) {
+		return l, io.ErrUnexpectedEOF
+	}
+
 	*this = make([]byte, u)
 
 	copy(*this, buf[l:])
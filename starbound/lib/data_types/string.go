@@ -6,9 +6,16 @@ import (
 	"github.com/xhebox/bstruct/byteorder"
 )
 
+// String is a ByteArray of utf8. Its methods keep the bytes as they are, a
+// UTF8Policy is applied by ReadString and WriteString of the policy.
 type String string
 
 func ReadString(rd io.Reader, endian byteorder.ByteOrder) (String, error) {
+	return UTF8Accept.ReadString(rd, endian)
+}
+
+// ReadString reads a String and applies the policy to it.
+func (p UTF8Policy) ReadString(rd io.Reader, endian byteorder.ByteOrder) (String, error) {
 	buf := ByteArray{}
 
 	e := buf.Read(rd, endian)
@@ -16,18 +23,26 @@ func ReadString(rd io.Reader, endian byteorder.ByteOrder) (String, error) {
 		return "", e
 	}
 
-	return String(buf), nil
+	return p.Apply(String(buf))
 }
 
-func (this *String) Read(rd io.Reader, endian byteorder.ByteOrder) error {
-	buf := ByteArray{}
+// WriteString applies the policy to s and writes it.
+func (p UTF8Policy) WriteString(wt io.Writer, endian byteorder.ByteOrder, s String) error {
+	r, e := p.Apply(s)
+	if e != nil {
+		return e
+	}
 
-	e := buf.Read(rd, endian)
+	return r.Write(wt, endian)
+}
+
+func (this *String) Read(rd io.Reader, endian byteorder.ByteOrder) error {
+	r, e := ReadString(rd, endian)
 	if e != nil {
 		return e
 	}
 
-	*this = String(buf)
+	*this = r
 	return nil
 }
 
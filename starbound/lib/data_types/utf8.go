@@ -0,0 +1,86 @@
+package data_types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UTF8Policy is what a reader or writer does with invalid utf8 in strings,
+// which the game rejects. It is passed to each of them, like sbvj01.Limits.
+type UTF8Policy int
+
+const (
+	// UTF8Accept keeps the bytes as they are.
+	UTF8Accept UTF8Policy = iota
+	// UTF8Reject fails with ErrInvalidUTF8.
+	UTF8Reject
+	// UTF8Replace replaces every invalid byte by Substitute.
+	UTF8Replace
+)
+
+var policies = []string{"accept", "reject", "replace"}
+
+func (p UTF8Policy) String() string {
+	if p < 0 || int(p) >= len(policies) {
+		return fmt.Sprintf("UTF8Policy(%d)", int(p))
+	}
+	return policies[p]
+}
+
+func ParseUTF8Policy(s string) (UTF8Policy, error) {
+	for k, v := range policies {
+		if v == s {
+			return UTF8Policy(k), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown utf8 policy %q", s)
+}
+
+// Substitute replaces invalid bytes with UTF8Replace.
+var Substitute = "\uFFFD"
+
+var ErrInvalidUTF8 = errors.New("invalid utf8")
+
+// ValidUTF8 tells whether s is utf8 the game accepts: no sequence is longer
+// than four bytes, overlong or a surrogate.
+func ValidUTF8(s string) bool {
+	return utf8.ValidString(s)
+}
+
+// ReplaceUTF8 replaces every invalid byte of s by sub.
+func ReplaceUTF8(s string, sub string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			b.WriteString(sub)
+		} else {
+			b.WriteString(s[i : i+n])
+		}
+		i += n
+	}
+
+	return b.String()
+}
+
+// Apply applies the policy to s.
+func (p UTF8Policy) Apply(s String) (String, error) {
+	switch p {
+	case UTF8Reject:
+		if !utf8.ValidString(string(s)) {
+			return s, fmt.Errorf("%q: %w", string(s), ErrInvalidUTF8)
+		}
+	case UTF8Replace:
+		return String(ReplaceUTF8(string(s), Substitute)), nil
+	}
+
+	return s, nil
+}
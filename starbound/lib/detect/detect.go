@@ -0,0 +1,289 @@
+// Package detect tells what a starbound file is by its content, so that the
+// commands do not need a mode for it.
+//
+// Files with a magic, BTreeDB5, SBVJ01 and asset paks, are known by their
+// first bytes. The others are decoded as a whole: a versioned json with its
+// header, a list of them, a body without header, then json text. A form is
+// only taken if it reads to the end of the data, so a valid prefix is not
+// enough.
+package detect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/celestial"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// Kind is the format of a file.
+type Kind int
+
+const (
+	Unknown Kind = iota
+	// BTreeDB5 is a database, like worlds and universe.chunks.
+	BTreeDB5
+	// VJMagic is an SBVJ01 file, like .player.
+	VJMagic
+	// VJ is a versioned json with its header, but without magic.
+	VJ
+	// NVJ is an sbvj01.List, a count, then versioned jsons with their header.
+	NVJ
+	// Raw is the body of a versioned json only.
+	Raw
+	// JSON is json text, comments and trailing commas are allowed as in
+	// assets.
+	JSON
+	// Pak is an asset pak, like packed.pak.
+	Pak
+)
+
+// the names of VJMagic to NVJ are the -m modes of dumpsbvj01
+var kinds = []string{"unknown", "btreedb5", "vjmagic", "vj", "nvj", "raw", "json", "pak"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kinds) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kinds[k]
+}
+
+// Form is the sbvj01 form of VJMagic, VJ and Raw.
+func (k Kind) Form() (sbvj01.Form, bool) {
+	switch k {
+	case VJMagic:
+		return sbvj01.FormMagic, true
+	case VJ:
+		return sbvj01.FormHdr, true
+	case Raw:
+		return sbvj01.FormRaw, true
+	default:
+		return 0, false
+	}
+}
+
+var (
+	// PakMagic starts an asset pak.
+	PakMagic = []byte("SBAsset6")
+
+	// MaxSize is the largest file File decodes to detect it, bigger files
+	// without a magic are Unknown.
+	MaxSize int64 = 64 << 20
+)
+
+// Info is what a file is.
+type Info struct {
+	Kind Kind
+
+	// Identifier, BlockSize and KeySize are the header of a BTreeDB5.
+	Identifier string
+	BlockSize  int
+	KeySize    int
+
+	// Hdrs are the headers of VJMagic, VJ and NVJ, one for each versioned
+	// json.
+	Hdrs []sbvj01.VerJsonHdr
+}
+
+func hdrString(hdr sbvj01.VerJsonHdr) string {
+	if !hdr.Versioned {
+		return fmt.Sprintf("%s, not versioned", hdr.Id)
+	}
+	return fmt.Sprintf("%s, version %d", hdr.Id, hdr.Version)
+}
+
+// String describes the file in one line.
+func (i *Info) String() string {
+	switch i.Kind {
+	case BTreeDB5:
+		return fmt.Sprintf("BTreeDB5 %s, block size %d, key size %d", i.Identifier, i.BlockSize, i.KeySize)
+	case VJMagic:
+		return "SBVJ01 versioned json " + hdrString(i.Hdrs[0])
+	case VJ:
+		return "versioned json without magic " + hdrString(i.Hdrs[0])
+	case NVJ:
+		l := []string{}
+		for k, v := range i.Hdrs {
+			if k == 8 {
+				l = append(l, "...")
+				break
+			}
+			l = append(l, hdrString(v))
+		}
+		return fmt.Sprintf("%d versioned jsons [%s]", len(i.Hdrs), strings.Join(l, "; "))
+	case Raw:
+		return "versioned json body without header"
+	case JSON:
+		return "json text"
+	case Pak:
+		return "asset pak"
+	default:
+		return "unknown"
+	}
+}
+
+// Commands are the commands of this repository that handle the file.
+func (i *Info) Commands() []string {
+	switch i.Kind {
+	case BTreeDB5:
+		switch i.Identifier {
+		case world.Identifier:
+			return []string{"worldmeta", "worlddiff", "worldtrim", "worldtmx", "worldwire", "worldstats", "sbjq", "sbutf8", "vjversions", "dumpbtreedb", "makebtreedb"}
+		case celestial.Identifier:
+			return []string{"celestial", "dumpbtreedb", "makebtreedb"}
+		default:
+			return []string{"dumpbtreedb", "makebtreedb"}
+		}
+	case VJMagic:
+		return []string{"dumpsbvj01", "makesbvj01", "sbvjedit", "sbjq", "sbutf8", "vjversions"}
+	case VJ, Raw:
+		return []string{"dumpsbvj01", "makesbvj01", "sbvjedit", "sbjq"}
+	case NVJ:
+		return []string{"dumpsbvj01", "makesbvj01"}
+	case JSON:
+		return []string{"sbpatch", "sbjq", "makesbvj01"}
+	default:
+		return nil
+	}
+}
+
+// Magic tells the kinds that have a magic by the first bytes of a file,
+// other kinds are Unknown. 8 bytes are enough.
+func Magic(data []byte) Kind {
+	switch {
+	case bytes.HasPrefix(data, btreedb5.Magic):
+		return BTreeDB5
+	case bytes.HasPrefix(data, sbvj01.Magic):
+		return VJMagic
+	case bytes.HasPrefix(data, PakMagic):
+		return Pak
+	default:
+		return Unknown
+	}
+}
+
+// whole reads a versioned json of the form, which must be all of data.
+func whole(data []byte, form sbvj01.Form) (*sbvj01.VersionedJson, bool) {
+	rd := bytes.NewReader(data)
+
+	vj, e := sbvj01.ReadVersioned(rd, form)
+	if e != nil || rd.Len() != 0 {
+		return nil, false
+	}
+
+	return vj, true
+}
+
+// list reads an sbvj01.List, which must be all of data. An empty list is a
+// single zero byte, which is too little to tell.
+func list(data []byte) ([]sbvj01.VerJsonHdr, bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return nil, false
+	}
+
+	rd := bytes.NewReader(data)
+
+	l, e := sbvj01.ReadList(rd)
+	if e != nil || rd.Len() != 0 {
+		return nil, false
+	}
+
+	r := make([]sbvj01.VerJsonHdr, len(l))
+	for k, v := range l {
+		r[k] = v.VerJsonHdr
+	}
+
+	return r, true
+}
+
+// Detect tells what data is, see the package comment for the order.
+func Detect(data []byte) (*Info, error) {
+	switch Magic(data) {
+	case BTreeDB5:
+		ident, blksz, keysz, e := btreedb5.ParseHeader(data)
+		if e != nil {
+			return nil, e
+		}
+		return &Info{Kind: BTreeDB5, Identifier: ident, BlockSize: blksz, KeySize: keysz}, nil
+	case VJMagic:
+		rd := bytes.NewReader(data[len(sbvj01.Magic):])
+
+		hdr, e := sbvj01.ReadHdr(rd)
+		if e != nil {
+			return nil, errors.Wrap(e, "SBVJ01 header")
+		}
+		return &Info{Kind: VJMagic, Hdrs: []sbvj01.VerJsonHdr{hdr}}, nil
+	case Pak:
+		return &Info{Kind: Pak}, nil
+	}
+
+	if vj, ok := whole(data, sbvj01.FormHdr); ok {
+		return &Info{Kind: VJ, Hdrs: []sbvj01.VerJsonHdr{vj.VerJsonHdr}}, nil
+	}
+
+	if hdrs, ok := list(data); ok {
+		return &Info{Kind: NVJ, Hdrs: hdrs}, nil
+	}
+
+	if _, ok := whole(data, sbvj01.FormRaw); ok {
+		return &Info{Kind: Raw}, nil
+	}
+
+	if _, e := assetjson.Parse(data); e == nil {
+		return &Info{Kind: JSON}, nil
+	}
+
+	return &Info{Kind: Unknown}, nil
+}
+
+// File tells what the file is. BTreeDB5 and paks are not read further than
+// their header, others are read as a whole, if they are not bigger than
+// MaxSize without a magic.
+func File(name string) (*Info, error) {
+	f, e := os.Open(name)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	fi, e := f.Stat()
+	if e != nil {
+		return nil, e
+	}
+
+	// the fixed header of btreedb5 is the longest
+	hdr := make([]byte, 32)
+	n, e := io.ReadFull(f, hdr)
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return nil, e
+	}
+	hdr = hdr[:n]
+
+	switch Magic(hdr) {
+	case BTreeDB5, Pak:
+		return Detect(hdr)
+	case Unknown:
+		if fi.Size() > MaxSize {
+			return &Info{Kind: Unknown}, nil
+		}
+	}
+
+	if _, e := f.Seek(0, io.SeekStart); e != nil {
+		return nil, e
+	}
+
+	data, e := ioutil.ReadAll(f)
+	if e != nil {
+		return nil, e
+	}
+
+	return Detect(data)
+}
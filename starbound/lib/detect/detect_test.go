@@ -0,0 +1,302 @@
+package detect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/celestial"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+var hdr = sbvj01.VerJsonHdr{Id: "PlayerEntity", Versioned: true, Version: 30}
+
+func versioned(id string) *sbvj01.VersionedJson {
+	return &sbvj01.VersionedJson{
+		VerJsonHdr: sbvj01.VerJsonHdr{Id: data_types.String(id)},
+		Content:    []interface{}{int64(1), "x"},
+	}
+}
+
+func encode(t *testing.T, form sbvj01.Form) []byte {
+	t.Helper()
+
+	vj := versioned("")
+	vj.VerJsonHdr = hdr
+
+	buf := &bytes.Buffer{}
+	if e := sbvj01.WriteVersioned(buf, vj, form); e != nil {
+		t.Fatal(e)
+	}
+	return buf.Bytes()
+}
+
+func encodeList(t *testing.T, l sbvj01.List) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if e := l.Write(buf); e != nil {
+		t.Fatal(e)
+	}
+	return buf.Bytes()
+}
+
+// btreeHdr is the fixed header of a btreedb5
+func btreeHdr(ident string, blksz, keysz int) []byte {
+	r := make([]byte, 32)
+	copy(r, btreedb5.Magic)
+	binary.BigEndian.PutUint32(r[8:], uint32(blksz))
+	copy(r[12:28], ident)
+	binary.BigEndian.PutUint32(r[28:], uint32(keysz))
+	return r
+}
+
+func TestDetect(t *testing.T) {
+	list := encodeList(t, sbvj01.List{versioned("a"), versioned("b")})
+	one := encodeList(t, sbvj01.List{versioned("a")})
+
+	for _, v := range []struct {
+		name string
+		data []byte
+		info Info
+	}{
+		{"btreedb5", btreeHdr(world.Identifier, 2048, 5), Info{Kind: BTreeDB5, Identifier: world.Identifier, BlockSize: 2048, KeySize: 5}},
+		{"btreedb5 with blocks", append(btreeHdr("Test", 512, 16), make([]byte, 1024)...), Info{Kind: BTreeDB5, Identifier: "Test", BlockSize: 512, KeySize: 16}},
+		{"vjmagic", encode(t, sbvj01.FormMagic), Info{Kind: VJMagic, Hdrs: []sbvj01.VerJsonHdr{hdr}}},
+		{"vj", encode(t, sbvj01.FormHdr), Info{Kind: VJ, Hdrs: []sbvj01.VerJsonHdr{hdr}}},
+		{"nvj", list, Info{Kind: NVJ, Hdrs: []sbvj01.VerJsonHdr{{Id: "a"}, {Id: "b"}}}},
+		{"raw", encode(t, sbvj01.FormRaw), Info{Kind: Raw}},
+		{"json", []byte("// a\n{\"a\": [1, 2,],}"), Info{Kind: JSON}},
+		{"pak", append(append([]byte{}, PakMagic...), 0, 0, 0, 1), Info{Kind: Pak}},
+		{"unknown", []byte{0xff, 0xff, 0xff}, Info{Kind: Unknown}},
+
+		// ambiguous, the first form in the order of the package comment is
+		// taken
+
+		// id "ab", not versioned, the string "cde", which is also the number
+		// type and 8 bytes of a double
+		{"vj and raw", []byte{2, 'a', 'b', 0, 5, 3, 'c', 'd', 'e'}, Info{Kind: VJ, Hdrs: []sbvj01.VerJsonHdr{{Id: "ab"}}}},
+		{"list of one", one, Info{Kind: NVJ, Hdrs: []sbvj01.VerJsonHdr{{Id: "a"}}}},
+		// the header is only read after a magic
+		{"vjmagic without body", append(append([]byte{}, sbvj01.Magic...), encode(t, sbvj01.FormHdr)[:len(hdr.Id)+6]...), Info{Kind: VJMagic, Hdrs: []sbvj01.VerJsonHdr{hdr}}},
+		// a valid prefix is not enough
+		{"vj with a trailing byte", append(encode(t, sbvj01.FormHdr), 0), Info{Kind: Unknown}},
+		{"nvj with a trailing byte", append(append([]byte{}, list...), 1), Info{Kind: Unknown}},
+		{"nvj with a missing element", append([]byte{3}, list[1:]...), Info{Kind: Unknown}},
+		// a magic is only one at the start
+		{"json of a magic", []byte(`"SBVJ01"`), Info{Kind: JSON}},
+
+		// short
+		{"empty", []byte{}, Info{Kind: Unknown}},
+		{"nil", nil, Info{Kind: Unknown}},
+		// an empty list, or the null of a raw body without type
+		{"zero", []byte{0}, Info{Kind: Unknown}},
+		{"null", []byte{1}, Info{Kind: Raw}},
+		{"truncated btreedb5 magic", btreedb5.Magic[:5], Info{Kind: Unknown}},
+		{"truncated sbvj01 magic", sbvj01.Magic[:5], Info{Kind: Unknown}},
+		{"truncated pak magic", PakMagic[:7], Info{Kind: Unknown}},
+		{"pak magic", PakMagic, Info{Kind: Pak}},
+		{"truncated vj", encode(t, sbvj01.FormHdr)[:4], Info{Kind: Unknown}},
+	} {
+		r, e := Detect(v.data)
+		if e != nil {
+			t.Fatalf("%s: %v", v.name, e)
+		}
+
+		if !reflect.DeepEqual(*r, v.info) {
+			t.Fatalf("%s: got %+v, expect %+v", v.name, *r, v.info)
+		}
+	}
+}
+
+func TestDetectError(t *testing.T) {
+	for _, v := range []struct {
+		name string
+		data []byte
+	}{
+		{"btreedb5 magic only", btreedb5.Magic},
+		{"truncated btreedb5 header", btreeHdr("Test", 512, 5)[:31]},
+		{"btreedb5 without block size", btreeHdr("Test", 0, 5)},
+		{"sbvj01 magic only", sbvj01.Magic},
+		{"truncated sbvj01 header", encode(t, sbvj01.FormMagic)[:len(sbvj01.Magic)+3]},
+	} {
+		if r, e := Detect(v.data); e == nil {
+			t.Fatalf("%s: got %+v, no error", v.name, r)
+		}
+	}
+}
+
+func TestMagic(t *testing.T) {
+	for _, v := range []struct {
+		data []byte
+		kind Kind
+	}{
+		{[]byte("BTreeDB5"), BTreeDB5},
+		{[]byte("SBVJ01\x00\x00"), VJMagic},
+		{[]byte("SBAsset6"), Pak},
+		{[]byte("BTreeDB4"), Unknown},
+		{[]byte("SBVJ0"), Unknown},
+		{[]byte("sbvj01"), Unknown},
+		{[]byte{}, Unknown},
+		// kinds without magic are not told
+		{encode(t, sbvj01.FormHdr), Unknown},
+		{[]byte("{}"), Unknown},
+	} {
+		if k := Magic(v.data); k != v.kind {
+			t.Fatalf("%q: got %s, expect %s", v.data, k, v.kind)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	for _, v := range []struct {
+		kind Kind
+		name string
+		form sbvj01.Form
+		ok   bool
+	}{
+		{Unknown, "unknown", 0, false},
+		{BTreeDB5, "btreedb5", 0, false},
+		{VJMagic, "vjmagic", sbvj01.FormMagic, true},
+		{VJ, "vj", sbvj01.FormHdr, true},
+		{NVJ, "nvj", 0, false},
+		{Raw, "raw", sbvj01.FormRaw, true},
+		{JSON, "json", 0, false},
+		{Pak, "pak", 0, false},
+		{Kind(8), "Kind(8)", 0, false},
+		{Kind(-1), "Kind(-1)", 0, false},
+	} {
+		if s := v.kind.String(); s != v.name {
+			t.Fatalf("%d: got %s, expect %s", int(v.kind), s, v.name)
+		}
+
+		if form, ok := v.kind.Form(); form != v.form || ok != v.ok {
+			t.Fatalf("%s: got form %v %v, expect %v %v", v.name, form, ok, v.form, v.ok)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	hdrs := []sbvj01.VerJsonHdr{}
+	for i := 0; i < 10; i++ {
+		hdrs = append(hdrs, sbvj01.VerJsonHdr{Id: "x", Versioned: i%2 == 0, Version: int32(i)})
+	}
+
+	for _, v := range []struct {
+		info    Info
+		out     string
+		command string
+	}{
+		{Info{Kind: BTreeDB5, Identifier: world.Identifier, BlockSize: 2048, KeySize: 5}, "BTreeDB5 World4, block size 2048, key size 5", "worldmeta"},
+		{Info{Kind: BTreeDB5, Identifier: celestial.Identifier, BlockSize: 2048, KeySize: 16}, "BTreeDB5 Celestial2, block size 2048, key size 16", "celestial"},
+		{Info{Kind: BTreeDB5, Identifier: "Other"}, "BTreeDB5 Other, block size 0, key size 0", "dumpbtreedb"},
+		{Info{Kind: VJMagic, Hdrs: []sbvj01.VerJsonHdr{hdr}}, "SBVJ01 versioned json PlayerEntity, version 30", "sbvjedit"},
+		{Info{Kind: VJ, Hdrs: []sbvj01.VerJsonHdr{{Id: "a"}}}, "versioned json without magic a, not versioned", "dumpsbvj01"},
+		{Info{Kind: NVJ, Hdrs: hdrs[:2]}, "2 versioned jsons [x, version 0; x, not versioned]", "makesbvj01"},
+		{Info{Kind: NVJ, Hdrs: hdrs}, "10 versioned jsons [x, version 0; x, not versioned; x, version 2; x, not versioned; x, version 4; x, not versioned; x, version 6; x, not versioned; ...]", "dumpsbvj01"},
+		{Info{Kind: Raw}, "versioned json body without header", "sbjq"},
+		{Info{Kind: JSON}, "json text", "sbpatch"},
+		{Info{Kind: Pak}, "asset pak", ""},
+		{Info{Kind: Unknown}, "unknown", ""},
+	} {
+		if s := v.info.String(); s != v.out {
+			t.Fatalf("%+v: got %q, expect %q", v.info, s, v.out)
+		}
+
+		l := v.info.Commands()
+		found := false
+		for _, c := range l {
+			found = found || c == v.command
+		}
+
+		if v.command == "" && len(l) != 0 || v.command != "" && !found {
+			t.Fatalf("%s: commands %v, expect %q", v.out, l, v.command)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+
+	db := filepath.Join(dir, "a.world")
+	h, e := btreedb5.New(db, world.Identifier, 512, 5)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := h.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	write := func(name string, data []byte) string {
+		name = filepath.Join(dir, name)
+		if e := ioutil.WriteFile(name, data, 0644); e != nil {
+			t.Fatal(e)
+		}
+		return name
+	}
+
+	player := write("a.player", encode(t, sbvj01.FormMagic))
+	patch := write("a.patch", []byte(`[{"op": "add", "path": "/a", "value": 1},]`))
+	raw := write("a.raw", encode(t, sbvj01.FormRaw))
+	empty := write("empty", nil)
+	short := write("short", btreedb5.Magic[:6])
+
+	for _, v := range []struct {
+		name string
+		kind Kind
+	}{
+		{db, BTreeDB5},
+		{player, VJMagic},
+		{patch, JSON},
+		{raw, Raw},
+		{empty, Unknown},
+		{short, Unknown},
+	} {
+		r, e := File(v.name)
+		if e != nil {
+			t.Fatalf("%s: %v", v.name, e)
+		}
+
+		if r.Kind != v.kind {
+			t.Fatalf("%s: got %s, expect %s", v.name, r.Kind, v.kind)
+		}
+	}
+
+	r, e := File(db)
+	if e != nil || r.Identifier != world.Identifier || r.BlockSize != 512 || r.KeySize != 5 {
+		t.Fatalf("%s: got %+v, %v", db, r, e)
+	}
+
+	if _, e := File(filepath.Join(dir, "missing")); e == nil {
+		t.Fatal("missing file detected")
+	}
+
+	// files without a magic over MaxSize are not read, the others are
+	defer func(n int64) { MaxSize = n }(MaxSize)
+	MaxSize = 4
+
+	for _, v := range []struct {
+		name string
+		kind Kind
+	}{
+		{db, BTreeDB5},
+		{player, VJMagic},
+		{patch, Unknown},
+		{raw, Unknown},
+	} {
+		r, e := File(v.name)
+		if e != nil {
+			t.Fatalf("%s: %v", v.name, e)
+		}
+
+		if r.Kind != v.kind {
+			t.Fatalf("%s with MaxSize %d: got %s, expect %s", v.name, MaxSize, r.Kind, v.kind)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package jsonpatch
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Diff returns a patch that turns a into b. Arrays are compared by index, so
+// an insertion in the middle shows up as a run of replaces.
+func Diff(a, b interface{}) Patch {
+	return diff(Pointer{}, a, b, nil)
+}
+
+func diff(p Pointer, a, b interface{}, r Patch) Patch {
+	if x, ok := members(a); ok {
+		if y, ok := members(b); ok {
+			keys := make([]string, 0, len(x)+len(y))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			for k := range y {
+				if _, ok := x[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				v, inx := x[k]
+				w, iny := y[k]
+				switch {
+				case !iny:
+					r = append(r, Operation{Op: "remove", Path: p.Append(k).String()})
+				case !inx:
+					r = append(r, Operation{Op: "add", Path: p.Append(k).String(), Value: w})
+				default:
+					r = diff(p.Append(k), v, w, r)
+				}
+			}
+			return r
+		}
+	}
+
+	if x, ok := a.([]interface{}); ok {
+		if y, ok := b.([]interface{}); ok {
+			n := len(x)
+			if len(y) < n {
+				n = len(y)
+			}
+
+			for k := 0; k < n; k++ {
+				r = diff(p.Append(strconv.Itoa(k)), x[k], y[k], r)
+			}
+
+			for k := len(x) - 1; k >= n; k-- {
+				r = append(r, Operation{Op: "remove", Path: p.Append(strconv.Itoa(k)).String()})
+			}
+
+			for k := n; k < len(y); k++ {
+				r = append(r, Operation{Op: "add", Path: p.Append("-").String(), Value: y[k]})
+			}
+			return r
+		}
+	}
+
+	if !Equal(a, b) {
+		r = append(r, Operation{Op: "replace", Path: p.String(), Value: b})
+	}
+
+	return r
+}
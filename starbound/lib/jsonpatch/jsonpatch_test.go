@@ -0,0 +1,219 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+func parse(t *testing.T, s string) interface{} {
+	t.Helper()
+
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+
+	var r interface{}
+	if e := d.Decode(&r); e != nil {
+		t.Fatalf("%s: %v", s, e)
+	}
+	return r
+}
+
+func TestMarshal(t *testing.T) {
+	for _, v := range []struct {
+		op  Operation
+		out string
+	}{
+		{Operation{Op: "add", Path: "/a", Value: nil}, `{"op":"add","path":"/a","value":null}`},
+		{Operation{Op: "replace", Path: "/a", Value: "b"}, `{"op":"replace","path":"/a","value":"b"}`},
+		{Operation{Op: "test", Path: "/a", Value: nil}, `{"op":"test","path":"/a","value":null}`},
+		{Operation{Op: "remove", Path: "/a"}, `{"op":"remove","path":"/a"}`},
+		{Operation{Op: "move", Path: "/a", From: ""}, `{"op":"move","path":"/a","from":""}`},
+		{Operation{Op: "copy", Path: "/a", From: "/b"}, `{"op":"copy","path":"/a","from":"/b"}`},
+	} {
+		r, e := json.Marshal(v.op)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if string(r) != v.out {
+			t.Fatalf("got %s, expect %s", r, v.out)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	p, e := Decode(strings.NewReader(`[
+		{"op": "add", "path": "/a", "value": null},
+		{"op": "replace", "path": "/b", "value": 1.5},
+		{"op": "move", "path": "/c", "from": "/b"},
+		{"op": "remove", "path": "/c"}
+	]`))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(p) != 4 || p[0].Value != nil || p[1].Value != json.Number("1.5") || p[2].From != "/b" {
+		t.Fatalf("got %#v", p)
+	}
+
+	// what Marshal writes is decoded back
+	r, e := json.Marshal(p)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	q, e := Decode(strings.NewReader(string(r)))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for k := range p {
+		if p[k] != q[k] {
+			t.Fatalf("%d: got %#v, expect %#v", k, q[k], p[k])
+		}
+	}
+
+	for _, v := range []string{
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "replace", "path": "/a"}]`,
+		`[{"op": "test", "path": "/a"}]`,
+		`[{"op": "move", "path": "/a"}]`,
+		`[{"op": "copy", "path": "/a"}]`,
+	} {
+		if _, e := Decode(strings.NewReader(v)); e == nil {
+			t.Fatalf("%s: no error", v)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	for _, v := range []struct {
+		doc, patch, out string
+	}{
+		{`{"a": 1}`, `[{"op": "add", "path": "/b", "value": null}]`, `{"a": 1, "b": null}`},
+		{`{"a": 1}`, `[{"op": "replace", "path": "/a", "value": null}]`, `{"a": null}`},
+		{`{"a": null}`, `[{"op": "test", "path": "/a", "value": null}]`, `{"a": null}`},
+		{`{"a": [1, 2]}`, `[{"op": "add", "path": "/a/1", "value": 3}]`, `{"a": [1, 3, 2]}`},
+		{`{"a": [1, 2]}`, `[{"op": "add", "path": "/a/-", "value": 3}]`, `{"a": [1, 2, 3]}`},
+		{`{"a": [1, 2]}`, `[{"op": "remove", "path": "/a/0"}]`, `{"a": [2]}`},
+		{`{"a": {"b": 1}}`, `[{"op": "move", "path": "/c", "from": "/a/b"}]`, `{"a": {}, "c": 1}`},
+		{`{"a": {"b": 1}}`, `[{"op": "copy", "path": "/c", "from": "/a"}]`, `{"a": {"b": 1}, "c": {"b": 1}}`},
+		{`{"a/b": {"~": 1}}`, `[{"op": "replace", "path": "/a~1b/~0", "value": 2}]`, `{"a/b": {"~": 2}}`},
+		{`{"a": 1}`, `[{"op": "replace", "path": "", "value": [1]}]`, `[1]`},
+	} {
+		p, e := Decode(strings.NewReader(v.patch))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		r, e := p.Apply(parse(t, v.doc))
+		if e != nil {
+			t.Fatalf("%s: %v", v.patch, e)
+		}
+
+		if !Equal(r, parse(t, v.out)) {
+			t.Fatalf("%s: got %v, expect %s", v.patch, r, v.out)
+		}
+	}
+
+	for _, v := range []struct {
+		doc, patch string
+	}{
+		{`{"a": 1}`, `[{"op": "test", "path": "/a", "value": null}]`},
+		{`{"a": 1}`, `[{"op": "remove", "path": "/b"}]`},
+		{`{"a": [1]}`, `[{"op": "add", "path": "/a/2", "value": 1}]`},
+		{`{"a": [1]}`, `[{"op": "remove", "path": "/a/01"}]`},
+		{`{"a": {"b": 1}}`, `[{"op": "move", "path": "/a/b/c", "from": "/a"}]`},
+	} {
+		p, e := Decode(strings.NewReader(v.patch))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if _, e := p.Apply(parse(t, v.doc)); e == nil {
+			t.Fatalf("%s: no error", v.patch)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, v := range []struct {
+		a, b string
+	}{
+		{`{"a": 1, "b": [1, 2, 3], "c": {"d": null}}`, `{"a": null, "b": [1, 4], "c": {"d": true, "e": null}}`},
+		{`{"a": [1]}`, `{"a": [1, null, 2]}`},
+		{`[1, 2]`, `{"a": 1}`},
+		{`{"a": 1}`, `{"a": 1.0}`},
+	} {
+		a, b := parse(t, v.a), parse(t, v.b)
+
+		// the patch goes through json, as worlddiff writes it
+		data, e := json.Marshal(Diff(a, b))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		p, e := Decode(strings.NewReader(string(data)))
+		if e != nil {
+			t.Fatalf("%s: %v", data, e)
+		}
+
+		r, e := p.Apply(a)
+		if e != nil {
+			t.Fatalf("%s: %v", data, e)
+		}
+
+		if !Equal(r, b) {
+			t.Fatalf("%s: got %v, expect %s", data, r, v.b)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	for _, v := range []struct {
+		doc, patch, out string
+	}{
+		// members are set in the order of the patch, new ones appended
+		{`{"b": 1, "a": {"x": 1}}`, `{"z": {"q": 1, "p": null}, "a": {"y": 2, "x": null}, "c": 3, "b": null}`, `{"a":{"y":2},"z":{"q":1},"c":3}`},
+		{`[1]`, `{"b": {"d": 1, "c": 2}, "a": 1}`, `{"b":{"d":1,"c":2},"a":1}`},
+		{`{"a": 1}`, `[2]`, `[2]`},
+	} {
+		doc, e := sbvj01.DecodeJSON(strings.NewReader(v.doc))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		patch, e := sbvj01.DecodeJSON(strings.NewReader(v.patch))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		r, e := json.Marshal(MergePatch(doc, patch))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if string(r) != v.out {
+			t.Fatalf("%s: got %s, expect %s", v.patch, r, v.out)
+		}
+	}
+
+	// a plain map patch is applied sorted, into an *Object
+	r := MergePatch(nil, map[string]interface{}{"c": int64(1), "a": map[string]interface{}{"y": nil, "x": int64(2)}, "b": nil})
+	o, ok := r.(*sbvj01.Object)
+	if !ok {
+		t.Fatalf("got %T", r)
+	}
+
+	data, e := json.Marshal(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if string(data) != `{"a":{"x":2},"c":1}` {
+		t.Fatalf("got %s", data)
+	}
+}
@@ -0,0 +1,338 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// Operation is one RFC 6902 operation. Value is used by add, replace and
+// test, and From by move and copy.
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+func hasValue(op string) bool {
+	return op == "add" || op == "replace" || op == "test"
+}
+
+func hasFrom(op string) bool {
+	return op == "move" || op == "copy"
+}
+
+// MarshalJSON writes value and from only for the ops that use them, value
+// is written even if it is null.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	r := struct {
+		Op    string       `json:"op"`
+		Path  string       `json:"path"`
+		From  *string      `json:"from,omitempty"`
+		Value *interface{} `json:"value,omitempty"`
+	}{Op: o.Op, Path: o.Path}
+
+	if hasFrom(o.Op) {
+		r.From = &o.From
+	}
+
+	if hasValue(o.Op) {
+		r.Value = &o.Value
+	}
+
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON rejects operations without the value or from their op
+// needs, a null value is a value. Numbers are kept as json.Number.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var r struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  *string         `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+
+	if e := json.Unmarshal(data, &r); e != nil {
+		return e
+	}
+
+	*o = Operation{Op: r.Op, Path: r.Path}
+
+	if hasFrom(r.Op) {
+		if r.From == nil {
+			return errors.Errorf("%s %s has no from", r.Op, r.Path)
+		}
+		o.From = *r.From
+	}
+
+	if hasValue(r.Op) {
+		// a missing value is nil, null is "null"
+		if r.Value == nil {
+			return errors.Errorf("%s %s has no value", r.Op, r.Path)
+		}
+
+		d := json.NewDecoder(bytes.NewReader(r.Value))
+		d.UseNumber()
+		if e := d.Decode(&o.Value); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+type Patch []Operation
+
+// Decode reads a patch document, numbers are kept as json.Number.
+func Decode(rd io.Reader) (Patch, error) {
+	var r Patch
+
+	d := json.NewDecoder(rd)
+	d.UseNumber()
+
+	if e := d.Decode(&r); e != nil {
+		return nil, errors.Wrapf(e, "failed to decode patch")
+	}
+
+	return r, nil
+}
+
+func (p Patch) Apply(doc interface{}) (interface{}, error) {
+	var e error
+	for k := range p {
+		doc, e = p[k].Apply(doc)
+		if e != nil {
+			return nil, errors.Wrapf(e, "operation %d(%s %s)", k, p[k].Op, p[k].Path)
+		}
+	}
+	return doc, nil
+}
+
+func (o Operation) Apply(doc interface{}) (interface{}, error) {
+	path, e := ParsePointer(o.Path)
+	if e != nil {
+		return nil, e
+	}
+
+	switch o.Op {
+	case "add":
+		if len(path) == 0 {
+			return o.Value, nil
+		}
+		return walk(doc, path, func(node interface{}, tok string) (interface{}, error) {
+			return add(node, tok, o.Value)
+		})
+	case "remove":
+		if len(path) == 0 {
+			return nil, errors.New("can not remove the whole document")
+		}
+		return walk(doc, path, remove)
+	case "replace":
+		if len(path) == 0 {
+			return o.Value, nil
+		}
+		return walk(doc, path, func(node interface{}, tok string) (interface{}, error) {
+			return replace(node, tok, o.Value)
+		})
+	case "move", "copy":
+		from, e := ParsePointer(o.From)
+		if e != nil {
+			return nil, e
+		}
+
+		v, e := Get(doc, from)
+		if e != nil {
+			return nil, e
+		}
+
+		if o.Op == "move" {
+			if len(from) == 0 {
+				return nil, errors.New("can not move the whole document")
+			}
+
+			if len(path) > len(from) && Pointer(path[:len(from)]).String() == from.String() {
+				return nil, errors.New("can not move a value into itself")
+			}
+
+			doc, e = walk(doc, from, remove)
+			if e != nil {
+				return nil, e
+			}
+		} else {
+			v = Clone(v)
+		}
+
+		return Operation{Op: "add", Path: o.Path, Value: v}.Apply(doc)
+	case "test":
+		v, e := Get(doc, path)
+		if e != nil {
+			return nil, e
+		}
+
+		if !Equal(v, o.Value) {
+			return nil, errors.New("test failed")
+		}
+
+		return doc, nil
+	default:
+		return nil, errors.Errorf("unknown op %q", o.Op)
+	}
+}
+
+// MergePatch applies a RFC 7396 merge patch. Members are set in the order of
+// the patch, so the result is the same each time, and the objects it creates
+// are *sbvj01.Object.
+func MergePatch(doc, patch interface{}) interface{} {
+	p, ok := members(patch)
+	if !ok {
+		return patch
+	}
+
+	if _, ok := members(doc); !ok {
+		doc = sbvj01.NewObject()
+	}
+
+	for _, k := range sbvj01.Keys(patch) {
+		v := p[k]
+		switch n := doc.(type) {
+		case *sbvj01.Object:
+			if v == nil {
+				n.Delete(k)
+			} else {
+				w, _ := n.Get(k)
+				n.Set(k, MergePatch(w, v))
+			}
+		case map[string]interface{}:
+			if v == nil {
+				delete(n, k)
+			} else {
+				n[k] = MergePatch(n[k], v)
+			}
+		}
+	}
+
+	return doc
+}
+
+func members(node interface{}) (map[string]interface{}, bool) {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		return n.Map(), true
+	case map[string]interface{}:
+		return n, true
+	default:
+		return nil, false
+	}
+}
+
+func number(node interface{}) (float64, bool) {
+	switch n := node.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case data_types.Varint:
+		return float64(n), true
+	case sbvj01.NonFinite:
+		return float64(n), true
+	case json.Number:
+		f, e := n.Float64()
+		return f, e == nil
+	default:
+		return 0, false
+	}
+}
+
+func str(node interface{}) (string, bool) {
+	switch n := node.(type) {
+	case string:
+		return n, true
+	case data_types.String:
+		return string(n), true
+	default:
+		return "", false
+	}
+}
+
+// Equal compares two documents, regardless of the map and number types used
+// by encoding/json and sbvj01.
+func Equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && (x == y || (math.IsNaN(x) && math.IsNaN(y)))
+	}
+
+	if x, ok := str(a); ok {
+		y, ok := str(b)
+		return ok && x == y
+	}
+
+	if x, ok := members(a); ok {
+		y, ok := members(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !Equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k := range x {
+			if !Equal(x[k], y[k]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// Clone deep copies the containers of a document.
+func Clone(node interface{}) interface{} {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		r := sbvj01.NewObject()
+		for _, k := range n.Keys() {
+			v, _ := n.Get(k)
+			r.Set(k, Clone(v))
+		}
+		return r
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			r[k] = Clone(v)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(n))
+		for k, v := range n {
+			r[k] = Clone(v)
+		}
+		return r
+	default:
+		return node
+	}
+}
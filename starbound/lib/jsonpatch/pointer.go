@@ -0,0 +1,202 @@
+package jsonpatch
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// Pointer is a parsed RFC 6901 JSON pointer, "" is the whole document.
+type Pointer []string
+
+func ParsePointer(s string) (Pointer, error) {
+	if s == "" {
+		return Pointer{}, nil
+	}
+
+	if s[0] != '/' {
+		return nil, errors.Errorf("pointer %q does not start with /", s)
+	}
+
+	r := strings.Split(s[1:], "/")
+	for k := range r {
+		r[k] = strings.Replace(strings.Replace(r[k], "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return r, nil
+}
+
+func (p Pointer) String() string {
+	var b strings.Builder
+	for _, v := range p {
+		b.WriteByte('/')
+		b.WriteString(strings.Replace(strings.Replace(v, "~", "~0", -1), "/", "~1", -1))
+	}
+	return b.String()
+}
+
+func (p Pointer) Append(tok string) Pointer {
+	r := make(Pointer, len(p)+1)
+	copy(r, p)
+	r[len(p)] = tok
+	return r
+}
+
+func index(tok string, n int, end bool) (int, error) {
+	if end && tok == "-" {
+		return n, nil
+	}
+
+	i, e := strconv.Atoi(tok)
+	if e != nil || i < 0 || (tok != "0" && tok[0] == '0') {
+		return 0, errors.Errorf("invalid array index %q", tok)
+	}
+
+	if i > n || (!end && i == n) {
+		return 0, errors.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+func child(node interface{}, tok string) (interface{}, error) {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		v, ok := n.Get(tok)
+		if !ok {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+		return v, nil
+	case map[string]interface{}:
+		v, ok := n[tok]
+		if !ok {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+		return v, nil
+	case []interface{}:
+		i, e := index(tok, len(n), false)
+		if e != nil {
+			return nil, e
+		}
+		return n[i], nil
+	default:
+		return nil, errors.Errorf("can not index %T with %q", node, tok)
+	}
+}
+
+// walk descends to the parent of the last token, lets fn rebuild it, and
+// stores the result back along the way, since slices may be reallocated.
+func walk(node interface{}, p Pointer, fn func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(p) == 1 {
+		return fn(node, p[0])
+	}
+
+	c, e := child(node, p[0])
+	if e != nil {
+		return nil, e
+	}
+
+	c, e = walk(c, p[1:], fn)
+	if e != nil {
+		return nil, errors.Wrapf(e, "/%s", p[0])
+	}
+
+	return replace(node, p[0], c)
+}
+
+func replace(node interface{}, tok string, v interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		if _, ok := n.Get(tok); !ok {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+		n.Set(tok, v)
+	case map[string]interface{}:
+		if _, ok := n[tok]; !ok {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+		n[tok] = v
+	case []interface{}:
+		i, e := index(tok, len(n), false)
+		if e != nil {
+			return nil, e
+		}
+		n[i] = v
+	default:
+		return nil, errors.Errorf("can not index %T with %q", node, tok)
+	}
+	return node, nil
+}
+
+func add(node interface{}, tok string, v interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		n.Set(tok, v)
+	case map[string]interface{}:
+		n[tok] = v
+	case []interface{}:
+		i, e := index(tok, len(n), true)
+		if e != nil {
+			return nil, e
+		}
+		n = append(n, nil)
+		copy(n[i+1:], n[i:])
+		n[i] = v
+		return n, nil
+	default:
+		return nil, errors.Errorf("can not index %T with %q", node, tok)
+	}
+	return node, nil
+}
+
+func remove(node interface{}, tok string) (interface{}, error) {
+	switch n := node.(type) {
+	case *sbvj01.Object:
+		if !n.Delete(tok) {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+	case map[string]interface{}:
+		if _, ok := n[tok]; !ok {
+			return nil, errors.Errorf("no member %q", tok)
+		}
+		delete(n, tok)
+	case []interface{}:
+		i, e := index(tok, len(n), false)
+		if e != nil {
+			return nil, e
+		}
+		return append(n[:i], n[i+1:]...), nil
+	default:
+		return nil, errors.Errorf("can not index %T with %q", node, tok)
+	}
+	return node, nil
+}
+
+// Get returns the value p refers to.
+func Get(doc interface{}, p Pointer) (interface{}, error) {
+	var e error
+	for k := range p {
+		doc, e = child(doc, p[k])
+		if e != nil {
+			return nil, errors.Wrapf(e, "%s", p[:k+1])
+		}
+	}
+	return doc, nil
+}
+
+// Set replaces the value p refers to, or creates the last member if it is
+// missing. It returns the new document.
+func Set(doc interface{}, p Pointer, v interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return v, nil
+	}
+
+	return walk(doc, p, func(node interface{}, tok string) (interface{}, error) {
+		if _, e := child(node, tok); e == nil {
+			return replace(node, tok, v)
+		}
+		return add(node, tok, v)
+	})
+}
@@ -0,0 +1,308 @@
+// Package luatable writes json values as lua source, for scripts of the game.
+//
+// Plain lua tables lose what the game needs to convert them back to json:
+// whether a table is an array or an object, and members that are null. So
+// objects and arrays are built by jobject() and jarray() of the game, and
+// null members are assigned nil after, which the game records. Keys are
+// always strings, {"1": a} does not turn into an array.
+//
+// A container is written as a function call, like
+//
+//	(function()
+//		local function o(t, nils) ... end
+//		local function a(n, t) ... end
+//		return o({["name"] = "x", ["list"] = a(2, {1, nil})}, {"empty"})
+//	end)()
+//
+// where o and a are small helpers that build the tables.
+package luatable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// helpers copy a literal into jobject() or jarray(), then assign nil to the
+// null members, by key for objects, every index up to n for arrays.
+const helpers = `local function o(t, nils)
+	local r = jobject()
+	for k, v in pairs(t) do r[k] = v end
+	for _, k in ipairs(nils) do r[k] = nil end
+	return r
+end
+local function a(n, t)
+	local r = jarray()
+	for i = 1, n do r[i] = t[i] end
+	return r
+end
+`
+
+// Marshal returns the lua expression of v, see Write.
+func Marshal(v interface{}, indent string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e := Write(buf, v, indent); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes v as a lua expression. Containers are indented by indent, or
+// written on one line if it is empty.
+func Write(wt io.Writer, v interface{}, indent string) error {
+	w := &writer{wt: bufio.NewWriter(wt), indent: indent}
+
+	if !container(v) {
+		if e := w.value(v, 0); e != nil {
+			return e
+		}
+		return w.wt.Flush()
+	}
+
+	w.wt.WriteString("(function()")
+	w.newline(1)
+	for i, l := range strings.Split(strings.TrimSuffix(helpers, "\n"), "\n") {
+		if i > 0 {
+			w.newline(1)
+		}
+
+		if indent == "" {
+			l = strings.TrimLeft(l, "\t")
+		} else {
+			l = strings.Replace(l, "\t", indent, -1)
+		}
+		w.wt.WriteString(l)
+	}
+	w.newline(1)
+	w.wt.WriteString("return ")
+
+	if e := w.value(v, 1); e != nil {
+		return e
+	}
+
+	w.newline(0)
+	w.wt.WriteString("end)()")
+	return w.wt.Flush()
+}
+
+func container(v interface{}) bool {
+	if _, ok := v.([]interface{}); ok {
+		return true
+	}
+	_, ok := sbvj01.Members(v)
+	return ok
+}
+
+type writer struct {
+	wt     *bufio.Writer
+	indent string
+}
+
+// newline starts a line at depth, or writes a space without indent.
+func (w *writer) newline(depth int) {
+	if w.indent == "" {
+		w.wt.WriteByte(' ')
+		return
+	}
+
+	w.wt.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		w.wt.WriteString(w.indent)
+	}
+}
+
+func (w *writer) value(v interface{}, depth int) error {
+	switch n := v.(type) {
+	case nil:
+		w.wt.WriteString("nil")
+	case bool:
+		w.wt.WriteString(strconv.FormatBool(n))
+	case int64:
+		w.wt.WriteString(strconv.FormatInt(n, 10))
+	case data_types.Varint:
+		w.wt.WriteString(strconv.FormatInt(int64(n), 10))
+	case float64:
+		w.float(n)
+	case sbvj01.NonFinite:
+		w.float(float64(n))
+	case json.Number:
+		if i, e := n.Int64(); e == nil {
+			w.wt.WriteString(strconv.FormatInt(i, 10))
+			break
+		}
+
+		f, e := n.Float64()
+		if e != nil {
+			return errors.Errorf("bad number %q", n)
+		}
+		w.float(f)
+	case string:
+		// plain json has no NaN/Inf, as sbvj01.Write
+		switch n {
+		case "____NaN____":
+			w.float(math.NaN())
+		case "____+Inf____":
+			w.float(math.Inf(1))
+		case "____-Inf____":
+			w.float(math.Inf(-1))
+		default:
+			w.str(n)
+		}
+	case data_types.String:
+		w.str(string(n))
+	case []interface{}:
+		return w.array(n, depth)
+	default:
+		if _, ok := sbvj01.Members(v); ok {
+			return w.object(v, depth)
+		}
+		return errors.Errorf("unknown type %T", v)
+	}
+
+	return nil
+}
+
+// float writes a lua float, never an integer literal, so 1.0 stays a float.
+func (w *writer) float(f float64) {
+	switch {
+	case math.IsNaN(f):
+		w.wt.WriteString("(0/0)")
+	case math.IsInf(f, 1):
+		w.wt.WriteString("math.huge")
+	case math.IsInf(f, -1):
+		w.wt.WriteString("-math.huge")
+	default:
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		w.wt.WriteString(s)
+	}
+}
+
+// str writes a quoted lua string, bytes are kept as they are but control
+// characters, which are escaped.
+func (w *writer) str(s string) {
+	w.wt.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			w.wt.WriteByte('\\')
+			w.wt.WriteByte(c)
+		case '\n':
+			w.wt.WriteString(`\n`)
+		case '\r':
+			w.wt.WriteString(`\r`)
+		case '\t':
+			w.wt.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				// three digits, so a digit after it is not taken in
+				w.wt.WriteString("\\" + strconv.FormatInt(int64(c)+1000, 10)[1:])
+			} else {
+				w.wt.WriteByte(c)
+			}
+		}
+	}
+	w.wt.WriteByte('"')
+}
+
+// elems writes the elements of a table constructor, each by fn.
+func (w *writer) elems(n int, depth int, fn func(i int) error) error {
+	w.wt.WriteByte('{')
+	if n == 0 {
+		w.wt.WriteByte('}')
+		return nil
+	}
+
+	for i := 0; i < n; i++ {
+		w.newline(depth + 1)
+		if e := fn(i); e != nil {
+			return e
+		}
+		if i < n-1 || w.indent != "" {
+			w.wt.WriteByte(',')
+		}
+	}
+
+	w.newline(depth)
+	w.wt.WriteByte('}')
+	return nil
+}
+
+func (w *writer) array(l []interface{}, depth int) error {
+	w.wt.WriteString("a(" + strconv.Itoa(len(l)) + ", ")
+
+	e := w.elems(len(l), depth, func(i int) error {
+		if e := w.value(l[i], depth+1); e != nil {
+			return errors.Wrapf(e, "[%d]", i)
+		}
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+
+	w.wt.WriteByte(')')
+	return nil
+}
+
+func (w *writer) object(v interface{}, depth int) error {
+	m, _ := sbvj01.Members(v)
+
+	var keys []string
+	if o, ok := v.(*sbvj01.Object); ok {
+		keys = o.Keys()
+	} else {
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+
+	members, nils := []string{}, []string{}
+	for _, k := range keys {
+		if m[k] == nil {
+			nils = append(nils, k)
+		} else {
+			members = append(members, k)
+		}
+	}
+
+	w.wt.WriteString("o(")
+
+	e := w.elems(len(members), depth, func(i int) error {
+		k := members[i]
+
+		w.wt.WriteByte('[')
+		w.str(k)
+		w.wt.WriteString("] = ")
+
+		if e := w.value(m[k], depth+1); e != nil {
+			return errors.Wrapf(e, "%q", k)
+		}
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+
+	w.wt.WriteString(", {")
+	for i, k := range nils {
+		if i > 0 {
+			w.wt.WriteString(", ")
+		}
+		w.str(k)
+	}
+	w.wt.WriteString("})")
+	return nil
+}
@@ -0,0 +1,135 @@
+package luatable
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// the helpers on one line, as written without indent
+const compact = "(function() local function o(t, nils) local r = jobject() for k, v in pairs(t) do r[k] = v end " +
+	"for _, k in ipairs(nils) do r[k] = nil end return r end local function a(n, t) local r = jarray() " +
+	"for i = 1, n do r[i] = t[i] end return r end return "
+
+func parse(t *testing.T, s string) interface{} {
+	t.Helper()
+
+	v, e := sbvj01.DecodeJSON(strings.NewReader(s))
+	if e != nil {
+		t.Fatalf("%s: %v", s, e)
+	}
+	return v
+}
+
+func TestMarshal(t *testing.T) {
+	for _, v := range []struct {
+		in, out string
+	}{
+		// keys are strings, "1" does not make an array
+		{`{"1": "a", "2": "b"}`, `o({ ["1"] = "a", ["2"] = "b" }, {})`},
+		{`["a", "b"]`, `a(2, { "a", "b" })`},
+		// null members are assigned nil after, null elements are counted
+		{`{"a": null, "b": 1, "c": null}`, `o({ ["b"] = 1 }, {"a", "c"})`},
+		{`{"a": null}`, `o({}, {"a"})`},
+		{`[null, 1, null]`, `a(3, { nil, 1, nil })`},
+		{`[]`, `a(0, {})`},
+		{`{}`, `o({}, {})`},
+		{`{"z": [{}], "a": {"b": [null]}}`, `o({ ["z"] = a(1, { o({}, {}) }), ["a"] = o({ ["b"] = a(1, { nil }) }, {}) }, {})`},
+		// numbers
+		{`[1, -2, 2.5, 1.0, 1e300, 9223372036854775807]`, `a(6, { 1, -2, 2.5, 1.0, 1e+300, 9223372036854775807 })`},
+		// NaN and Inf as dumpsbvj01 writes them to json
+		{`["____NaN____", "____+Inf____", "____-Inf____"]`, `a(3, { (0/0), math.huge, -math.huge })`},
+		// escapes, a control character takes three digits so that the digit
+		// after it is not taken in
+		{`{"a\"b": "c\\d\n\r\t\u00019\u007fé"}`, `o({ ["a\"b"] = "c\\d\n\r\t\0019\127é" }, {})`},
+	} {
+		r, e := Marshal(parse(t, v.in), "")
+		if e != nil {
+			t.Fatalf("%s: %v", v.in, e)
+		}
+
+		if out := compact + v.out + " end)()"; string(r) != out {
+			t.Fatalf("%s:\ngot    %s\nexpect %s", v.in, r, out)
+		}
+	}
+}
+
+func TestScalar(t *testing.T) {
+	for _, v := range []struct {
+		in  interface{}
+		out string
+	}{
+		{nil, `nil`},
+		{true, `true`},
+		{int64(-3), `-3`},
+		{data_types.Varint(7), `7`},
+		{2.0, `2.0`},
+		{1.5e-7, `1.5e-07`},
+		{sbvj01.NonFinite(math.NaN()), `(0/0)`},
+		{sbvj01.NonFinite(math.Inf(-1)), `-math.huge`},
+		{math.Inf(1), `math.huge`},
+		{"____NaN____", `(0/0)`},
+		{data_types.String("a\x00b"), `"a\000b"`},
+		{"\x1f1", `"\0311"`},
+	} {
+		r, e := Marshal(v.in, "\t")
+		if e != nil {
+			t.Fatalf("%v: %v", v.in, e)
+		}
+
+		if string(r) != v.out {
+			t.Fatalf("%#v: got %s, expect %s", v.in, r, v.out)
+		}
+	}
+
+	if _, e := Marshal([]interface{}{struct{}{}}, ""); e == nil {
+		t.Fatal("unknown type written")
+	}
+}
+
+func TestIndent(t *testing.T) {
+	r, e := Marshal(parse(t, `{"name": "x", "n": null, "l": [1, null, {}], "1": []}`), "\t")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	out := `(function()
+	local function o(t, nils)
+		local r = jobject()
+		for k, v in pairs(t) do r[k] = v end
+		for _, k in ipairs(nils) do r[k] = nil end
+		return r
+	end
+	local function a(n, t)
+		local r = jarray()
+		for i = 1, n do r[i] = t[i] end
+		return r
+	end
+	return o({
+		["name"] = "x",
+		["l"] = a(3, {
+			1,
+			nil,
+			o({}, {}),
+		}),
+		["1"] = a(0, {}),
+	}, {"n"})
+end)()`
+
+	if string(r) != out {
+		t.Fatalf("got\n%s\nexpect\n%s", r, out)
+	}
+
+	// plain maps are sorted
+	r, e = Marshal(map[string]interface{}{"b": int64(1), "a": nil, "c": nil}, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if out := compact + `o({ ["b"] = 1 }, {"a", "c"}) end)()`; string(r) != out {
+		t.Fatalf("got %s, expect %s", r, out)
+	}
+}
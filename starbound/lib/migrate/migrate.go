@@ -0,0 +1,162 @@
+// Package migrate upgrades versioned json bodies the way the versioning
+// scripts of the game do, by steps registered per identifier.
+package migrate
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// Func upgrades a body, it may modify and return it.
+type Func func(body interface{}) (interface{}, error)
+
+// Step upgrades bodies of Id at a version in [From, To) to To.
+type Step struct {
+	Id   string
+	From int32
+	To   int32
+	Fn   Func
+}
+
+var steps = map[string][]*Step{}
+
+// Register adds a step, ranges of an identifier must not overlap.
+func Register(id string, from, to int32, fn Func) error {
+	if to <= from {
+		return errors.Errorf("%s: step from %d to %d is not an upgrade", id, from, to)
+	}
+
+	for _, s := range steps[id] {
+		if from < s.To && s.From < to {
+			return errors.Errorf("%s: step from %d to %d overlaps %d to %d", id, from, to, s.From, s.To)
+		}
+	}
+
+	l := append(steps[id], &Step{Id: id, From: from, To: to, Fn: fn})
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].From < l[j].From
+	})
+	steps[id] = l
+	return nil
+}
+
+// Steps returns the steps of id in order.
+func Steps(id string) []*Step {
+	return steps[id]
+}
+
+// Ids returns the identifiers with steps, sorted.
+func Ids() []string {
+	r := make([]string, 0, len(steps))
+	for k := range steps {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Latest returns the highest version steps of id upgrade to, false if there
+// is none.
+func Latest(id string) (int32, bool) {
+	l := steps[id]
+	if len(l) == 0 {
+		return 0, false
+	}
+	return l[len(l)-1].To, true
+}
+
+// Upgrade upgrades body to the latest version of its identifier, see
+// UpgradeTo. It is a no-op for unversioned or unknown identifiers.
+func Upgrade(hdr sbvj01.VerJsonHdr, body interface{}) (sbvj01.VerJsonHdr, interface{}, error) {
+	latest, ok := Latest(string(hdr.Id))
+	if !ok || !hdr.Versioned {
+		return hdr, body, nil
+	}
+
+	return UpgradeTo(hdr, body, latest)
+}
+
+// UpgradeTo applies steps in order until body is at version. It refuses to
+// downgrade, and fails if there is no step from a version in between.
+func UpgradeTo(hdr sbvj01.VerJsonHdr, body interface{}, version int32) (sbvj01.VerJsonHdr, interface{}, error) {
+	id := string(hdr.Id)
+
+	if !hdr.Versioned {
+		return hdr, body, errors.Errorf("%s is not versioned", id)
+	}
+
+	if version < hdr.Version {
+		return hdr, body, errors.Errorf("%s: can not downgrade from %d to %d", id, hdr.Version, version)
+	}
+
+	for hdr.Version < version {
+		var step *Step
+		for _, s := range steps[id] {
+			if s.From <= hdr.Version && hdr.Version < s.To {
+				step = s
+				break
+			}
+		}
+
+		if step == nil {
+			return hdr, body, errors.Errorf("%s: no step from version %d", id, hdr.Version)
+		}
+
+		if step.To > version {
+			return hdr, body, errors.Errorf("%s: step from %d goes to %d, past %d", id, hdr.Version, step.To, version)
+		}
+
+		r, e := step.Fn(body)
+		if e != nil {
+			return hdr, body, errors.Wrapf(e, "%s: from %d to %d", id, hdr.Version, step.To)
+		}
+
+		body = r
+		hdr.Version = step.To
+	}
+
+	return hdr, body, nil
+}
+
+// Status tells how a version compares to the steps of its identifier.
+type Status int
+
+const (
+	// Unknown is an identifier with no steps.
+	Unknown Status = iota
+	Current
+	// Old could be upgraded.
+	Old
+	// Newer is past the latest step, written by a newer game.
+	Newer
+	Unversioned
+)
+
+var statuses = []string{"unknown", "current", "old", "newer", "unversioned"}
+
+func (s Status) String() string {
+	if s < 0 || int(s) >= len(statuses) {
+		return "unknown"
+	}
+	return statuses[s]
+}
+
+func StatusOf(hdr sbvj01.VerJsonHdr) Status {
+	if !hdr.Versioned {
+		return Unversioned
+	}
+
+	latest, ok := Latest(string(hdr.Id))
+	switch {
+	case !ok:
+		return Unknown
+	case hdr.Version == latest:
+		return Current
+	case hdr.Version < latest:
+		return Old
+	default:
+		return Newer
+	}
+}
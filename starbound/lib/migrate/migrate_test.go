@@ -0,0 +1,163 @@
+package migrate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// mark appends the step to the body, a list of the steps applied
+func mark(from, to int32) Func {
+	return func(body interface{}) (interface{}, error) {
+		return append(body.([]string), fmt.Sprintf("%d-%d", from, to)), nil
+	}
+}
+
+func hdr(id string, version int32) sbvj01.VerJsonHdr {
+	return sbvj01.VerJsonHdr{Id: data_types.String(id), Versioned: true, Version: version}
+}
+
+func TestRegister(t *testing.T) {
+	// registered out of order
+	for _, v := range [][2]int32{{5, 7}, {1, 2}, {2, 5}} {
+		if e := Register("TestRegister", v[0], v[1], mark(v[0], v[1])); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	l := Steps("TestRegister")
+	if len(l) != 3 || l[0].From != 1 || l[1].From != 2 || l[2].From != 5 {
+		t.Fatalf("steps are not in order: %+v", l)
+	}
+
+	if latest, ok := Latest("TestRegister"); !ok || latest != 7 {
+		t.Fatalf("latest %d %v", latest, ok)
+	}
+
+	if _, ok := Latest("TestRegisterNone"); ok {
+		t.Fatal("latest of an identifier without steps")
+	}
+
+	for _, v := range [][2]int32{{1, 2}, {0, 3}, {6, 8}, {3, 4}, {4, 4}, {9, 8}} {
+		if e := Register("TestRegister", v[0], v[1], mark(v[0], v[1])); e == nil {
+			t.Fatalf("%d to %d registered", v[0], v[1])
+		}
+	}
+
+	// other identifiers are not in the way
+	if e := Register("TestRegisterOther", 1, 2, mark(1, 2)); e != nil {
+		t.Fatal(e)
+	}
+
+	found := 0
+	for _, id := range Ids() {
+		if id == "TestRegister" || id == "TestRegisterOther" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("ids %v", Ids())
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	// a gap from 3 to 4
+	for _, v := range [][2]int32{{1, 3}, {4, 6}, {6, 7}} {
+		if e := Register("TestUpgrade", v[0], v[1], mark(v[0], v[1])); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	for _, v := range []struct {
+		from, to int32
+		steps    []string
+	}{
+		{1, 3, []string{"1-3"}},
+		// a version inside a step is upgraded by it
+		{2, 3, []string{"1-3"}},
+		{4, 7, []string{"4-6", "6-7"}},
+		{5, 6, []string{"4-6"}},
+		{7, 7, []string{}},
+	} {
+		h, body, e := UpgradeTo(hdr("TestUpgrade", v.from), []string{}, v.to)
+		if e != nil {
+			t.Fatalf("%d to %d: %v", v.from, v.to, e)
+		}
+
+		if h.Version != v.to || !reflect.DeepEqual(body, v.steps) {
+			t.Fatalf("%d to %d: got version %d, steps %v", v.from, v.to, h.Version, body)
+		}
+	}
+
+	h, body, e := Upgrade(hdr("TestUpgrade", 4), []string{})
+	if e != nil || h.Version != 7 || !reflect.DeepEqual(body, []string{"4-6", "6-7"}) {
+		t.Fatalf("upgrade: got version %d, steps %v, %v", h.Version, body, e)
+	}
+
+	for _, v := range []struct {
+		name     string
+		from, to int32
+	}{
+		{"gap", 1, 7},
+		{"no step", 3, 4},
+		{"before the first step", 0, 3},
+		{"step past the version", 4, 5},
+		{"downgrade", 6, 4},
+	} {
+		h, body, e := UpgradeTo(hdr("TestUpgrade", v.from), []string{}, v.to)
+		if e == nil {
+			t.Fatalf("%s: no error", v.name)
+		}
+
+		// the body is left at the last version reached
+		if h.Version > v.from && len(body.([]string)) == 0 {
+			t.Fatalf("%s: version %d without steps", v.name, h.Version)
+		}
+	}
+
+	if _, _, e := UpgradeTo(sbvj01.VerJsonHdr{Id: "TestUpgrade"}, []string{}, 7); e == nil {
+		t.Fatal("unversioned upgraded")
+	}
+
+	// unversioned and unknown identifiers are left as they are
+	for _, h := range []sbvj01.VerJsonHdr{{Id: "TestUpgrade"}, hdr("TestUpgradeNone", 1)} {
+		r, body, e := Upgrade(h, []string{})
+		if e != nil || r != h || len(body.([]string)) != 0 {
+			t.Fatalf("%+v: got %+v, %v", h, r, e)
+		}
+	}
+
+	if e := Register("TestUpgradeFail", 1, 2, func(interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("broken")
+	}); e != nil {
+		t.Fatal(e)
+	}
+
+	if h, _, e := Upgrade(hdr("TestUpgradeFail", 1), nil); e == nil || h.Version != 1 {
+		t.Fatalf("failed step: got version %d, %v", h.Version, e)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	if e := Register("TestStatus", 1, 3, mark(1, 3)); e != nil {
+		t.Fatal(e)
+	}
+
+	for _, v := range []struct {
+		hdr    sbvj01.VerJsonHdr
+		status Status
+	}{
+		{hdr("TestStatus", 3), Current},
+		{hdr("TestStatus", 1), Old},
+		{hdr("TestStatus", 4), Newer},
+		{hdr("TestStatusNone", 1), Unknown},
+		{sbvj01.VerJsonHdr{Id: "TestStatus"}, Unversioned},
+	} {
+		if s := StatusOf(v.hdr); s != v.status {
+			t.Fatalf("%+v: got %s, expect %s", v.hdr, s, v.status)
+		}
+	}
+}
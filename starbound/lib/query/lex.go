@@ -0,0 +1,121 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type kind int
+
+const (
+	tEOF kind = iota
+	// .
+	tDot
+	// ..
+	tRecurse
+	// .name
+	tField
+	tIdent
+	tString
+	tNumber
+	// one of []()|,?;
+	tPunct
+	// one of == != < <= > >=
+	tOp
+)
+
+type token struct {
+	kind kind
+	text string
+	pos  int
+}
+
+func isIdent(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || (!first && c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lex(src string) ([]token, error) {
+	r := []token{}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '.':
+			switch {
+			case i+1 < len(src) && src[i+1] == '.':
+				r = append(r, token{tRecurse, "..", i})
+				i += 2
+			case i+1 < len(src) && isIdent(src[i+1], true):
+				j := i + 1
+				for j < len(src) && isIdent(src[j], false) {
+					j++
+				}
+				r = append(r, token{tField, src[i+1 : j], i})
+				i = j
+			default:
+				r = append(r, token{tDot, ".", i})
+				i++
+			}
+		case isIdent(c, true):
+			j := i
+			for j < len(src) && isIdent(src[j], false) {
+				j++
+			}
+			r = append(r, token{tIdent, src[i:j], i})
+			i = j
+		case isDigit(c) || c == '-' && i+1 < len(src) && isDigit(src[i+1]):
+			j := i + 1
+			for j < len(src) && (isDigit(src[j]) || strings.IndexByte(".eE+-", src[j]) != -1) {
+				if (src[j] == '+' || src[j] == '-') && src[j-1] != 'e' && src[j-1] != 'E' {
+					break
+				}
+				j++
+			}
+			r = append(r, token{tNumber, src[i:j], i})
+			i = j
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, errors.Errorf("unterminated string at %d", i)
+			}
+
+			s, e := strconv.Unquote(src[i : j+1])
+			if e != nil {
+				return nil, errors.Errorf("bad string at %d: %v", i, e)
+			}
+			r = append(r, token{tString, s, i})
+			i = j + 1
+		case strings.IndexByte("[]()|,?;", c) != -1:
+			r = append(r, token{tPunct, string(c), i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(src) && src[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, errors.Errorf("unknown operator %q at %d", op, i)
+			}
+			r = append(r, token{tOp, op, i})
+			i += len(op)
+		default:
+			return nil, errors.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+
+	return append(r, token{tEOF, "", len(src)}), nil
+}
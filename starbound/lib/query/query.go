@@ -0,0 +1,443 @@
+// Package query evaluates a jq like language on decoded json, as returned by
+// sbvj01.Read, sbvj01.DecodeJSON or encoding/json.
+//
+// It has paths (.a.b, ."a b", .[0], .[-1], .[], .[expr], ..), optional
+// access (?), pipes (|), commas (,), comparisons (== != < <= > >=), and, or,
+// literals, array construction ([...]) and these functions: keys,
+// keys_unsorted, length, type, not, empty, recurse, tostring, select(f),
+// map(f), has(f), first(f), startswith(f), endswith(f), test(f).
+package query
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// filter maps one input to any number of outputs.
+type filter func(v interface{}) ([]interface{}, error)
+
+// Query is a parsed filter.
+type Query struct {
+	src string
+	f   filter
+}
+
+func Parse(src string) (*Query, error) {
+	toks, e := lex(src)
+	if e != nil {
+		return nil, e
+	}
+
+	p := &parser{toks: toks}
+
+	f, e := p.pipe()
+	if e != nil {
+		return nil, e
+	}
+
+	if t := p.peek(); t.kind != tEOF {
+		return nil, errors.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+
+	return &Query{src: src, f: f}, nil
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// Run returns the outputs of the filter for v.
+func (q *Query) Run(v interface{}) ([]interface{}, error) {
+	return q.f(v)
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(k kind, text string) bool {
+	t := p.peek()
+	return t.kind == k && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if t.kind != tPunct || t.text != text {
+		if t.kind == tEOF {
+			return errors.Errorf("expect %q at the end", text)
+		}
+		return errors.Errorf("expect %q at %d, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) pipe() (filter, error) {
+	l, e := p.comma()
+	if e != nil {
+		return nil, e
+	}
+
+	if !p.is(tPunct, "|") {
+		return l, nil
+	}
+	p.next()
+
+	r, e := p.pipe()
+	if e != nil {
+		return nil, e
+	}
+
+	return func(v interface{}) ([]interface{}, error) {
+		a, e := l(v)
+		if e != nil {
+			return nil, e
+		}
+
+		res := []interface{}{}
+		for _, w := range a {
+			b, e := r(w)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, b...)
+		}
+		return res, nil
+	}, nil
+}
+
+func (p *parser) comma() (filter, error) {
+	fs := []filter{}
+	for {
+		f, e := p.or()
+		if e != nil {
+			return nil, e
+		}
+		fs = append(fs, f)
+
+		if !p.is(tPunct, ",") {
+			break
+		}
+		p.next()
+	}
+
+	if len(fs) == 1 {
+		return fs[0], nil
+	}
+
+	return func(v interface{}) ([]interface{}, error) {
+		res := []interface{}{}
+		for _, f := range fs {
+			a, e := f(v)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, a...)
+		}
+		return res, nil
+	}, nil
+}
+
+// logic parses and, or.
+func (p *parser) logic(op string, operand func() (filter, error)) (filter, error) {
+	l, e := operand()
+	if e != nil {
+		return nil, e
+	}
+
+	for p.is(tIdent, op) {
+		p.next()
+
+		r, e := operand()
+		if e != nil {
+			return nil, e
+		}
+
+		l = binary(l, r, func(a, b interface{}) (interface{}, error) {
+			if op == "and" {
+				return truthy(a) && truthy(b), nil
+			}
+			return truthy(a) || truthy(b), nil
+		})
+	}
+
+	return l, nil
+}
+
+func (p *parser) or() (filter, error) {
+	return p.logic("or", p.and)
+}
+
+func (p *parser) and() (filter, error) {
+	return p.logic("and", p.cmp)
+}
+
+func (p *parser) cmp() (filter, error) {
+	l, e := p.postfix()
+	if e != nil {
+		return nil, e
+	}
+
+	if p.peek().kind != tOp {
+		return l, nil
+	}
+	op := p.next().text
+
+	r, e := p.postfix()
+	if e != nil {
+		return nil, e
+	}
+
+	return binary(l, r, func(a, b interface{}) (interface{}, error) {
+		switch op {
+		case "==":
+			return equal(a, b), nil
+		case "!=":
+			return !equal(a, b), nil
+		}
+
+		c := compare(a, b)
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}), nil
+}
+
+// binary applies fn to every pair of outputs of l and r.
+func binary(l, r filter, fn func(a, b interface{}) (interface{}, error)) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		a, e := l(v)
+		if e != nil {
+			return nil, e
+		}
+
+		b, e := r(v)
+		if e != nil {
+			return nil, e
+		}
+
+		res := []interface{}{}
+		for _, x := range a {
+			for _, y := range b {
+				z, e := fn(x, y)
+				if e != nil {
+					return nil, e
+				}
+				res = append(res, z)
+			}
+		}
+		return res, nil
+	}
+}
+
+func identity(v interface{}) ([]interface{}, error) {
+	return []interface{}{v}, nil
+}
+
+func literal(c interface{}) filter {
+	return func(interface{}) ([]interface{}, error) {
+		return []interface{}{c}, nil
+	}
+}
+
+func (p *parser) term() (filter, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tField:
+		return then(identity, field(t.text)), nil
+	case tDot:
+		if p.peek().kind == tString {
+			return then(identity, field(p.next().text)), nil
+		}
+		return identity, nil
+	case tRecurse:
+		return recurse, nil
+	case tString:
+		return literal(t.text), nil
+	case tNumber:
+		if i, e := strconv.ParseInt(t.text, 10, 64); e == nil {
+			return literal(i), nil
+		}
+
+		f, e := strconv.ParseFloat(t.text, 64)
+		if e != nil {
+			return nil, errors.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return literal(f), nil
+	case tPunct:
+		switch t.text {
+		case "(":
+			f, e := p.pipe()
+			if e != nil {
+				return nil, e
+			}
+			return f, p.expect(")")
+		case "[":
+			if p.is(tPunct, "]") {
+				p.next()
+				return literal([]interface{}{}), nil
+			}
+
+			f, e := p.pipe()
+			if e != nil {
+				return nil, e
+			}
+
+			return collect(f), p.expect("]")
+		}
+	case tIdent:
+		switch t.text {
+		case "null":
+			return literal(nil), nil
+		case "true":
+			return literal(true), nil
+		case "false":
+			return literal(false), nil
+		}
+
+		args := []filter{}
+		if p.is(tPunct, "(") {
+			p.next()
+			for {
+				f, e := p.pipe()
+				if e != nil {
+					return nil, e
+				}
+				args = append(args, f)
+
+				if !p.is(tPunct, ";") {
+					break
+				}
+				p.next()
+			}
+
+			if e := p.expect(")"); e != nil {
+				return nil, e
+			}
+		}
+
+		return function(t, args)
+	case tEOF:
+		return nil, errors.New("unexpected end of the query")
+	}
+
+	return nil, errors.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) postfix() (filter, error) {
+	f, e := p.term()
+	if e != nil {
+		return nil, e
+	}
+
+	for {
+		t := p.peek()
+
+		switch {
+		case t.kind == tField:
+			p.next()
+			f = then(f, field(t.text))
+		case t.kind == tDot && p.toks[p.i+1].kind == tString:
+			p.next()
+			f = then(f, field(p.next().text))
+		case t.kind == tDot && p.toks[p.i+1].kind == tPunct && p.toks[p.i+1].text == "[":
+			// .a.[0] is .a[0]
+			p.next()
+		case t.kind == tPunct && t.text == "[":
+			p.next()
+			if p.is(tPunct, "]") {
+				p.next()
+				f = then(f, iterate)
+				continue
+			}
+
+			idx, e := p.pipe()
+			if e != nil {
+				return nil, e
+			}
+
+			if e := p.expect("]"); e != nil {
+				return nil, e
+			}
+
+			f = index(f, idx)
+		case t.kind == tPunct && t.text == "?":
+			p.next()
+			f = try(f)
+		default:
+			return f, nil
+		}
+	}
+}
+
+// then feeds the outputs of f to g.
+func then(f, g filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		a, e := f(v)
+		if e != nil {
+			return nil, e
+		}
+
+		res := []interface{}{}
+		for _, w := range a {
+			b, e := g(w)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, b...)
+		}
+		return res, nil
+	}
+}
+
+func collect(f filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		a, e := f(v)
+		if e != nil {
+			return nil, e
+		}
+		return []interface{}{a}, nil
+	}
+}
+
+func try(f filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		a, e := f(v)
+		if e != nil {
+			return []interface{}{}, nil
+		}
+		return a, nil
+	}
+}
+
+// tostring is the string itself, or the compact json of other values.
+func tostring(v interface{}) (string, error) {
+	if s, ok := str(v); ok {
+		return s, nil
+	}
+
+	r, e := json.Marshal(v)
+	return string(r), e
+}
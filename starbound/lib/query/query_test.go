@@ -0,0 +1,155 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+const doc = `{
+	"z": 1,
+	"a": {"b": [1, 2, 3], "c": "x"},
+	"n": null,
+	"k b": true,
+	"list": [{"n": "a", "v": 1}, {"n": "b", "v": 2}]
+}`
+
+// run runs q on doc, the outputs are compared as compact json
+func run(t *testing.T, q string) (string, error) {
+	t.Helper()
+
+	v, e := sbvj01.DecodeJSON(strings.NewReader(doc))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	p, e := Parse(q)
+	if e != nil {
+		t.Fatalf("%s: %v", q, e)
+	}
+
+	r, e := p.Run(v)
+	if e != nil {
+		return "", e
+	}
+
+	data, e := json.Marshal(r)
+	if e != nil {
+		t.Fatalf("%s: %v", q, e)
+	}
+	return string(data), nil
+}
+
+func TestRun(t *testing.T) {
+	for _, v := range []struct {
+		q, out string
+	}{
+		// paths
+		{`.`, `[{"z":1,"a":{"b":[1,2,3],"c":"x"},"n":null,"k b":true,"list":[{"n":"a","v":1},{"n":"b","v":2}]}]`},
+		{`.a.b`, `[[1,2,3]]`},
+		{`.a.b[0], .a.b[-1], .a.b[5], .a.b.[1]`, `[1,3,null,2]`},
+		{`.a.b[]`, `[1,2,3]`},
+		{`.a[]`, `[[1,2,3],"x"]`},
+		{`."k b", .["k b"], .a["c"]`, `[true,true,"x"]`},
+		{`.missing.x, .n[0]`, `[null,null]`},
+		{`.a.b[.z]`, `[2]`},
+		{`[..] | length`, `[17]`},
+		{`[.a | ..]`, `[[{"b":[1,2,3],"c":"x"},[1,2,3],1,2,3,"x"]]`},
+
+		// ?
+		{`.a.c.d?`, `[]`},
+		{`.a.c[0]?, 1`, `[1]`},
+		{`[.list[].n?]`, `[["a","b"]]`},
+
+		// pipes, commas and collection
+		{`.list[] | .n`, `["a","b"]`},
+		{`.list | map(.v)`, `[[1,2]]`},
+		{`.list[] | select(.v > 1) | .n`, `["b"]`},
+		{`[.z, .a.c]`, `[[1,"x"]]`},
+		{`[]`, `[[]]`},
+		{`(1, 2) | (., 10)`, `[1,10,2,10]`},
+
+		// literals
+		{`"a\"b", 1.5, 1e2, -3, true, false, null`, `["a\"b",1.5,100,-3,true,false,null]`},
+
+		// comparisons, jq orders null, false, true, numbers, strings, arrays,
+		// objects
+		{`1 < 2, 2 <= 2, 3 > 4, 3 >= 3, 1 == 1.0, "a" != "b"`, `[true,true,false,true,true,true]`},
+		{`null < false, false < true, true < 0, 0 < "a", "a" < [], [] < .a`, `[true,true,true,true,true,true]`},
+		{`[1, 2] < [1, 3], [1] < [1, 0], .list[0] < .list[1], .a == .a`, `[true,true,true,true]`},
+		{`.z == (1, 2)`, `[true,false]`},
+		{`true and false, true or false, null or 1, 1 and "a"`, `[false,true,true,true]`},
+
+		// functions
+		{`keys, keys_unsorted`, `[["a","k b","list","n","z"],["z","a","n","k b","list"]]`},
+		{`.list | keys`, `[[0,1]]`},
+		{`.a.b, "héllo", -3, null, .a | length`, `[3,5,3,0,2]`},
+		{`.a, .a.b, .a.c, .n, true, 1 | type`, `["object","array","string","null","boolean","number"]`},
+		{`true, null, 0 | not`, `[false,true,false]`},
+		{`[1, empty, 2]`, `[[1,2]]`},
+		{`[.a.b | recurse]`, `[[[1,2,3],1,2,3]]`},
+		{`.a.b, .a.c, 1, .n | tostring`, `["[1,2,3]","x","1","null"]`},
+		{`.a | has("b"), has("q")`, `[true,false]`},
+		{`.a.b | has(0), has(3)`, `[true,false]`},
+		{`first(.a.b[]), [first(empty)]`, `[1,[]]`},
+		{`.a.c | startswith("x"), endswith("y"), test("^[a-z]$")`, `[true,false,true]`},
+		{`[.list[] | select(.n | test("b")) | .v]`, `[[2]]`},
+	} {
+		r, e := run(t, v.q)
+		if e != nil {
+			t.Fatalf("%s: %v", v.q, e)
+		}
+
+		if r != v.out {
+			t.Fatalf("%s: got %s, expect %s", v.q, r, v.out)
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	for _, q := range []string{
+		`.a.c.d`,
+		`.a.c[0]`,
+		`.a.b["x"]`,
+		`.z[]`,
+		`.a.b[true]`,
+		`1 | keys`,
+		`.a | length, true | length`,
+		`.a.c | startswith(1)`,
+		`1 | endswith("a")`,
+		`"a" | test("(")`,
+		`.a | has(0)`,
+		`.list[.a]`,
+	} {
+		if r, e := run(t, q); e == nil {
+			t.Fatalf("%s: got %s, no error", q, r)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, q := range []string{
+		``,
+		`.a.`,
+		`(1`,
+		`[1`,
+		`1 2`,
+		`"abc`,
+		`"\q"`,
+		`.a = 1`,
+		`!1`,
+		`#`,
+		`foo`,
+		`keys(1)`,
+		`select`,
+		`select(1; 2)`,
+		`1 |`,
+		`)`,
+	} {
+		if _, e := Parse(q); e == nil {
+			t.Fatalf("%s: no error", q)
+		}
+	}
+}
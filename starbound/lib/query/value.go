@@ -0,0 +1,486 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+func typeOf(v interface{}) string {
+	if _, ok := number(v); ok {
+		return "number"
+	}
+
+	if _, ok := str(v); ok {
+		return "string"
+	}
+
+	if _, ok := sbvj01.Members(v); ok {
+		return "object"
+	}
+
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case data_types.Varint:
+		return float64(n), true
+	case sbvj01.NonFinite:
+		return float64(n), true
+	case json.Number:
+		f, e := n.Float64()
+		return f, e == nil
+	}
+	return 0, false
+}
+
+func str(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case string:
+		return n, true
+	case data_types.String:
+		return string(n), true
+	}
+	return "", false
+}
+
+// keys returns the keys of an object in order, sorted for plain maps.
+func keys(v interface{}) ([]string, bool) {
+	switch n := v.(type) {
+	case *sbvj01.Object:
+		return n.Keys(), true
+	case map[string]interface{}:
+		r := make([]string, 0, len(n))
+		for k := range n {
+			r = append(r, k)
+		}
+		sort.Strings(r)
+		return r, true
+	}
+	return nil, false
+}
+
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func equal(a, b interface{}) bool {
+	return jsonpatch.Equal(a, b)
+}
+
+// compare orders values as jq does: null, false, true, numbers, strings,
+// arrays, objects.
+func compare(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch typeOf(v) {
+		case "null":
+			return 0
+		case "boolean":
+			if v == true {
+				return 2
+			}
+			return 1
+		case "number":
+			return 3
+		case "string":
+			return 4
+		case "array":
+			return 5
+		default:
+			return 6
+		}
+	}
+
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch ra {
+	case 3:
+		x, _ := number(a)
+		y, _ := number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case 4:
+		x, _ := str(a)
+		y, _ := str(b)
+		return strings.Compare(x, y)
+	case 5:
+		x, y := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	case 6:
+		ka, _ := keys(a)
+		kb, _ := keys(b)
+		ka = append([]string{}, ka...)
+		kb = append([]string{}, kb...)
+		sort.Strings(ka)
+		sort.Strings(kb)
+
+		for i := 0; i < len(ka) && i < len(kb); i++ {
+			if c := strings.Compare(ka[i], kb[i]); c != 0 {
+				return c
+			}
+		}
+		if len(ka) != len(kb) {
+			return len(ka) - len(kb)
+		}
+
+		ma, _ := sbvj01.Members(a)
+		mb, _ := sbvj01.Members(b)
+		for _, k := range ka {
+			if c := compare(ma[k], mb[k]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+func field(name string) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		if v == nil {
+			return []interface{}{nil}, nil
+		}
+
+		m, ok := sbvj01.Members(v)
+		if !ok {
+			return nil, errors.Errorf("can not index %s with %q", typeOf(v), name)
+		}
+
+		return []interface{}{m[name]}, nil
+	}
+}
+
+func iterate(v interface{}) ([]interface{}, error) {
+	if a, ok := v.([]interface{}); ok {
+		return append([]interface{}{}, a...), nil
+	}
+
+	ks, ok := keys(v)
+	if !ok {
+		return nil, errors.Errorf("can not iterate over %s", typeOf(v))
+	}
+
+	m, _ := sbvj01.Members(v)
+	r := make([]interface{}, len(ks))
+	for i, k := range ks {
+		r[i] = m[k]
+	}
+	return r, nil
+}
+
+// index is f[idx], idx runs on the same input as f.
+func index(f, idx filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		bases, e := f(v)
+		if e != nil {
+			return nil, e
+		}
+
+		is, e := idx(v)
+		if e != nil {
+			return nil, e
+		}
+
+		res := []interface{}{}
+		for _, b := range bases {
+			for _, i := range is {
+				if s, ok := str(i); ok {
+					r, e := field(s)(b)
+					if e != nil {
+						return nil, e
+					}
+					res = append(res, r...)
+					continue
+				}
+
+				n, ok := number(i)
+				if !ok {
+					return nil, errors.Errorf("can not index %s with %s", typeOf(b), typeOf(i))
+				}
+
+				if b == nil {
+					res = append(res, nil)
+					continue
+				}
+
+				a, ok := b.([]interface{})
+				if !ok {
+					return nil, errors.Errorf("can not index %s with number", typeOf(b))
+				}
+
+				k := int(math.Floor(n))
+				if k < 0 {
+					k += len(a)
+				}
+
+				if k < 0 || k >= len(a) {
+					res = append(res, nil)
+				} else {
+					res = append(res, a[k])
+				}
+			}
+		}
+		return res, nil
+	}
+}
+
+func recurse(v interface{}) ([]interface{}, error) {
+	res := []interface{}{v}
+
+	if _, ok := v.([]interface{}); !ok {
+		if _, ok := keys(v); !ok {
+			return res, nil
+		}
+	}
+
+	children, _ := iterate(v)
+	for _, c := range children {
+		r, _ := recurse(c)
+		res = append(res, r...)
+	}
+	return res, nil
+}
+
+func length(v interface{}) (interface{}, error) {
+	if n, ok := number(v); ok {
+		return math.Abs(n), nil
+	}
+
+	if s, ok := str(v); ok {
+		return int64(utf8.RuneCountInString(s)), nil
+	}
+
+	if m, ok := sbvj01.Members(v); ok {
+		return int64(len(m)), nil
+	}
+
+	switch n := v.(type) {
+	case nil:
+		return int64(0), nil
+	case []interface{}:
+		return int64(len(n)), nil
+	}
+
+	return nil, errors.Errorf("%s has no length", typeOf(v))
+}
+
+// each makes a function of one value.
+func each(fn func(v interface{}) (interface{}, error)) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		r, e := fn(v)
+		if e != nil {
+			return nil, e
+		}
+		return []interface{}{r}, nil
+	}
+}
+
+// withArg makes a function of the input and every output of its argument.
+func withArg(arg filter, fn func(v, a interface{}) (interface{}, error)) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		as, e := arg(v)
+		if e != nil {
+			return nil, e
+		}
+
+		res := []interface{}{}
+		for _, a := range as {
+			r, e := fn(v, a)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, r)
+		}
+		return res, nil
+	}
+}
+
+// strings2 makes a function of the input and its argument, both strings.
+func strings2(name string, arg filter, fn func(s, a string) (interface{}, error)) filter {
+	return withArg(arg, func(v, a interface{}) (interface{}, error) {
+		s, ok := str(v)
+		if !ok {
+			return nil, errors.Errorf("%s: input is %s, not string", name, typeOf(v))
+		}
+
+		t, ok := str(a)
+		if !ok {
+			return nil, errors.Errorf("%s: argument is %s, not string", name, typeOf(a))
+		}
+
+		return fn(s, t)
+	})
+}
+
+var arity = map[string]int{
+	"keys":          0,
+	"keys_unsorted": 0,
+	"length":        0,
+	"type":          0,
+	"not":           0,
+	"empty":         0,
+	"recurse":       0,
+	"tostring":      0,
+	"select":        1,
+	"map":           1,
+	"has":           1,
+	"first":         1,
+	"startswith":    1,
+	"endswith":      1,
+	"test":          1,
+}
+
+func function(t token, args []filter) (filter, error) {
+	n, ok := arity[t.text]
+	if !ok {
+		return nil, errors.Errorf("unknown function %s at %d", t.text, t.pos)
+	}
+
+	if len(args) != n {
+		return nil, errors.Errorf("%s at %d takes %d arguments, got %d", t.text, t.pos, n, len(args))
+	}
+
+	switch t.text {
+	case "keys", "keys_unsorted":
+		sorted := t.text == "keys"
+		return each(func(v interface{}) (interface{}, error) {
+			if a, ok := v.([]interface{}); ok {
+				r := make([]interface{}, len(a))
+				for i := range a {
+					r[i] = int64(i)
+				}
+				return r, nil
+			}
+
+			ks, ok := keys(v)
+			if !ok {
+				return nil, errors.Errorf("%s has no keys", typeOf(v))
+			}
+
+			if sorted {
+				ks = append([]string{}, ks...)
+				sort.Strings(ks)
+			}
+
+			r := make([]interface{}, len(ks))
+			for i := range ks {
+				r[i] = ks[i]
+			}
+			return r, nil
+		}), nil
+	case "length":
+		return each(length), nil
+	case "type":
+		return each(func(v interface{}) (interface{}, error) {
+			return typeOf(v), nil
+		}), nil
+	case "not":
+		return each(func(v interface{}) (interface{}, error) {
+			return !truthy(v), nil
+		}), nil
+	case "empty":
+		return func(interface{}) ([]interface{}, error) {
+			return []interface{}{}, nil
+		}, nil
+	case "recurse":
+		return recurse, nil
+	case "tostring":
+		return each(func(v interface{}) (interface{}, error) {
+			return tostring(v)
+		}), nil
+	case "select":
+		return func(v interface{}) ([]interface{}, error) {
+			cs, e := args[0](v)
+			if e != nil {
+				return nil, e
+			}
+
+			res := []interface{}{}
+			for _, c := range cs {
+				if truthy(c) {
+					res = append(res, v)
+				}
+			}
+			return res, nil
+		}, nil
+	case "map":
+		return collect(then(iterate, args[0])), nil
+	case "has":
+		return withArg(args[0], func(v, k interface{}) (interface{}, error) {
+			if s, ok := str(k); ok {
+				m, ok := sbvj01.Members(v)
+				if !ok {
+					return nil, errors.Errorf("can not check whether %s has a key", typeOf(v))
+				}
+				_, ok = m[s]
+				return ok, nil
+			}
+
+			i, ok := number(k)
+			a, isArray := v.([]interface{})
+			if !ok || !isArray {
+				return nil, errors.Errorf("can not check whether %s has a %s key", typeOf(v), typeOf(k))
+			}
+			return i >= 0 && int(i) < len(a), nil
+		}), nil
+	case "first":
+		return func(v interface{}) ([]interface{}, error) {
+			r, e := args[0](v)
+			if e != nil || len(r) == 0 {
+				return r, e
+			}
+			return r[:1], nil
+		}, nil
+	case "startswith":
+		return strings2(t.text, args[0], func(s, a string) (interface{}, error) {
+			return strings.HasPrefix(s, a), nil
+		}), nil
+	case "endswith":
+		return strings2(t.text, args[0], func(s, a string) (interface{}, error) {
+			return strings.HasSuffix(s, a), nil
+		}), nil
+	default:
+		return strings2(t.text, args[0], func(s, a string) (interface{}, error) {
+			re, e := regexp.Compile(a)
+			if e != nil {
+				return nil, e
+			}
+			return re.MatchString(s), nil
+		}), nil
+	}
+}
@@ -0,0 +1,172 @@
+package sbvj01
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// NonFinite is a NaN or infinite double. Plain json has no literal for them,
+// so they are marshaled as the strings "____NaN____", "____+Inf____" and
+// "____-Inf____", which Write turns back into doubles when given as string.
+type NonFinite float64
+
+func (n NonFinite) String() string {
+	switch {
+	case math.IsInf(float64(n), 1):
+		return "____+Inf____"
+	case math.IsInf(float64(n), -1):
+		return "____-Inf____"
+	default:
+		return "____NaN____"
+	}
+}
+
+func (n NonFinite) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.String())
+}
+
+// Annotate converts a value returned by Read to the annotated json form,
+// which keeps every sbvj01 type across json:
+//
+//   - varints are json integers, doubles always have a '.' or an exponent,
+//     so 1.0 stays a double. Numbers are json.Number.
+//   - NaN and infinities are {"$double": "NaN"}, "Infinity" or "-Infinity".
+//   - an object with only one member whose key starts with '$' is wrapped as
+//     {"$object": {...}}, so it is not taken as an annotation.
+//   - strings are only strings.
+func Annotate(v interface{}) interface{} {
+	switch n := v.(type) {
+	case float64:
+		s := strconv.FormatFloat(n, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return json.Number(s)
+	case NonFinite:
+		r := NewObject()
+		switch {
+		case math.IsInf(float64(n), 1):
+			r.Set("$double", "Infinity")
+		case math.IsInf(float64(n), -1):
+			r.Set("$double", "-Infinity")
+		default:
+			r.Set("$double", "NaN")
+		}
+		return r
+	case int64:
+		return json.Number(strconv.FormatInt(n, 10))
+	case Varint:
+		return json.Number(strconv.FormatInt(int64(n), 10))
+	case String:
+		return string(n)
+	case []interface{}:
+		r := make([]interface{}, len(n))
+		for k := range n {
+			r[k] = Annotate(n[k])
+		}
+		return r
+	case *Object:
+		r := NewObject()
+		for _, k := range n.Keys() {
+			w, _ := n.Get(k)
+			r.Set(k, Annotate(w))
+		}
+
+		if r.Len() == 1 && strings.HasPrefix(r.Keys()[0], "$") {
+			w := NewObject()
+			w.Set("$object", r)
+			return w
+		}
+		return r
+	default:
+		return v
+	}
+}
+
+// Unannotate is the reverse of Annotate, doc is usually decoded by
+// DecodeJSON. The result is what Read would return.
+func Unannotate(doc interface{}) (interface{}, error) {
+	switch n := doc.(type) {
+	case json.Number:
+		s := string(n)
+		if strings.ContainsAny(s, ".eE") {
+			f, e := strconv.ParseFloat(s, 64)
+			if e != nil {
+				return nil, e
+			}
+			return f, nil
+		}
+
+		i, e := strconv.ParseInt(s, 10, 64)
+		if e != nil {
+			return nil, e
+		}
+		return i, nil
+	case float64:
+		return nil, errors.New("numbers must be decoded as json.Number")
+	case string:
+		return String(n), nil
+	case []interface{}:
+		r := make([]interface{}, len(n))
+		for k := range n {
+			v, e := Unannotate(n[k])
+			if e != nil {
+				return nil, errors.Wrapf(e, "/%d", k)
+			}
+			r[k] = v
+		}
+		return r, nil
+	case *Object:
+		if n.Len() == 1 && strings.HasPrefix(n.Keys()[0], "$") {
+			v, _ := n.Get(n.Keys()[0])
+
+			switch n.Keys()[0] {
+			case "$double":
+				switch v {
+				case "NaN":
+					return NonFinite(math.NaN()), nil
+				case "Infinity":
+					return NonFinite(math.Inf(1)), nil
+				case "-Infinity":
+					return NonFinite(math.Inf(-1)), nil
+				default:
+					return nil, errors.Errorf("$double %v is not NaN, Infinity or -Infinity", v)
+				}
+			case "$object":
+				o, ok := v.(*Object)
+				if !ok {
+					return nil, errors.New("$object is not an object")
+				}
+				return unannotateObject(o)
+			default:
+				return nil, errors.Errorf("unknown annotation %q", n.Keys()[0])
+			}
+		}
+
+		return unannotateObject(n)
+	case map[string]interface{}:
+		return nil, errors.New("objects must be decoded by DecodeJSON")
+	default:
+		return doc, nil
+	}
+}
+
+func unannotateObject(o *Object) (*Object, error) {
+	r := NewObject()
+	for _, k := range o.Keys() {
+		v, _ := o.Get(k)
+
+		w, e := Unannotate(v)
+		if e != nil {
+			return nil, errors.Wrapf(e, "/%s", k)
+		}
+
+		r.Set(k, w)
+	}
+	return r, nil
+}
@@ -0,0 +1,569 @@
+package sbvj01
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// Marshaler is implemented by types that convert themselves to a value
+// Write accepts.
+type Marshaler interface {
+	MarshalSBVJ() (interface{}, error)
+}
+
+// Unmarshaler is implemented by types that set themselves from a value, as
+// Read or DecodeJSON returns.
+type Unmarshaler interface {
+	UnmarshalSBVJ(interface{}) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	uuidType        = reflect.TypeOf(UUID{})
+	objectType      = reflect.TypeOf(&Object{})
+	numberType      = reflect.TypeOf(json.Number(""))
+	nonFiniteType   = reflect.TypeOf(NonFinite(0))
+)
+
+// Marshal encodes v as a sbvj01 body, see ToValue.
+func Marshal(v interface{}) ([]byte, error) {
+	r, e := ToValue(v)
+	if e != nil {
+		return nil, e
+	}
+
+	buf := &bytes.Buffer{}
+	if e := Write(buf, r); e != nil {
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a sbvj01 body into v, see FromValue. Trailing bytes are
+// an error.
+func Unmarshal(data []byte, v interface{}) error {
+	rd := bytes.NewReader(data)
+
+	r, e := Read(rd)
+	if e != nil {
+		return e
+	}
+
+	if rd.Len() != 0 {
+		return errors.Errorf("%d trailing bytes", rd.Len())
+	}
+
+	return FromValue(r, v)
+}
+
+// field is a struct field bound to an object member.
+type field struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+// fields lists the members of a struct type in field order. Fields are named
+// by the `sbvj:"name,omitempty"` tag, or by the field name. "-" skips the
+// field, untagged anonymous structs are inlined.
+func fields(t reflect.Type) []field {
+	r := []field{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("sbvj")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i != -1 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				for _, v := range fields(ft) {
+					v.index = append([]int{i}, v.index...)
+					r = append(r, v)
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		r = append(r, field{
+			name:      name,
+			index:     []int{i},
+			omitempty: opts == "omitempty",
+		})
+	}
+
+	return r
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// ToValue converts v to a value Write accepts:
+//
+//   - bools, strings and String are themselves, numbers are int64 for integer
+//     types and float64 or NonFinite for floats.
+//   - slices and arrays are arrays, nil slices are null. UUID is a hex string.
+//   - structs are objects in field order, see the tags above. Maps with
+//     string keys are objects sorted by key.
+//   - nil pointers and interfaces are null, others are what they point to.
+//   - Marshaler is called, values returned by Read are kept as they are.
+func ToValue(v interface{}) (interface{}, error) {
+	return toValue(reflect.ValueOf(v), "$")
+}
+
+func toValue(v reflect.Value, path string) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	t := v.Type()
+	if t.Implements(marshalerType) {
+		if t.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, nil
+		}
+
+		r, e := v.Interface().(Marshaler).MarshalSBVJ()
+		if e != nil {
+			return nil, errors.Wrapf(e, "%s", path)
+		}
+		return r, nil
+	}
+
+	if v.CanAddr() && reflect.PtrTo(t).Implements(marshalerType) {
+		r, e := v.Addr().Interface().(Marshaler).MarshalSBVJ()
+		if e != nil {
+			return nil, errors.Wrapf(e, "%s", path)
+		}
+		return r, nil
+	}
+
+	switch t {
+	case objectType, numberType, nonFiniteType:
+		return v.Interface(), nil
+	case uuidType:
+		u := v.Interface().(UUID)
+		return hex.EncodeToString(u[:]), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, errors.Errorf("%s: %d overflows a varint", path, v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return NonFinite(f), nil
+		}
+		return f, nil
+	case reflect.String:
+		return String(v.String()), nil
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toValue(v.Elem(), path)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		fallthrough
+	case reflect.Array:
+		r := make([]interface{}, v.Len())
+		for i := range r {
+			e := error(nil)
+			r[i], e = toValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if e != nil {
+				return nil, e
+			}
+		}
+		return r, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("%s: map key %s is not a string", path, t.Key())
+		}
+
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		r := NewObject()
+		for _, k := range keys {
+			w, e := toValue(v.MapIndex(k), path+pathKey(k.String()))
+			if e != nil {
+				return nil, e
+			}
+			r.Set(k.String(), w)
+		}
+		return r, nil
+	case reflect.Struct:
+		r := NewObject()
+		for _, f := range fields(t) {
+			w, ok := fieldByIndex(v, f.index)
+			if !ok || f.omitempty && isEmpty(w) {
+				continue
+			}
+
+			n, e := toValue(w, path+pathKey(f.name))
+			if e != nil {
+				return nil, e
+			}
+			r.Set(f.name, n)
+		}
+		return r, nil
+	default:
+		return nil, errors.Errorf("%s: can not marshal %s", path, t)
+	}
+}
+
+// fieldByIndex is v.FieldByIndex, but false for fields of nil embedded
+// pointers.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// FromValue sets the value ptr points to from doc, which is returned by Read
+// or DecodeJSON, or decoded by encoding/json. It is the reverse of ToValue,
+// numbers convert between integers and floats as long as they fit, and the
+// strings for NaN and infinities are accepted for floats. Members of doc
+// that no field is bound to are ignored, fields with no member are left as
+// they are. Interface fields are set to the value of doc as is.
+func FromValue(doc interface{}, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.Errorf("can not unmarshal into %T", ptr)
+	}
+
+	return fromValue(doc, v.Elem(), "$")
+}
+
+func typeName(doc interface{}) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64, int64, Varint, NonFinite, json.Number:
+		return "number"
+	case string, String:
+		return "string"
+	case []interface{}:
+		return "array"
+	case *Object, map[string]interface{}, map[String]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", doc)
+	}
+}
+
+// toFloat and toInt convert the number types Read, DecodeJSON and
+// encoding/json return.
+func toFloat(doc interface{}) (float64, bool) {
+	switch n := doc.(type) {
+	case float64:
+		return n, true
+	case NonFinite:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case Varint:
+		return float64(n), true
+	case json.Number:
+		f, e := n.Float64()
+		return f, e == nil
+	case string, String:
+		switch fmt.Sprint(n) {
+		case "____NaN____":
+			return math.NaN(), true
+		case "____+Inf____":
+			return math.Inf(1), true
+		case "____-Inf____":
+			return math.Inf(-1), true
+		}
+	}
+	return 0, false
+}
+
+func toInt(doc interface{}) (int64, bool) {
+	switch n := doc.(type) {
+	case int64:
+		return n, true
+	case Varint:
+		return int64(n), true
+	case json.Number:
+		if i, e := strconv.ParseInt(string(n), 10, 64); e == nil {
+			return i, true
+		}
+	}
+
+	f, ok := toFloat(doc)
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func fromValue(doc interface{}, v reflect.Value, path string) error {
+	t := v.Type()
+
+	if v.CanAddr() && reflect.PtrTo(t).Implements(unmarshalerType) {
+		if e := v.Addr().Interface().(Unmarshaler).UnmarshalSBVJ(doc); e != nil {
+			return errors.Wrapf(e, "%s", path)
+		}
+		return nil
+	}
+
+	mismatch := func() error {
+		return errors.Errorf("%s: can not unmarshal %s into %s", path, typeName(doc), t)
+	}
+
+	if doc == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return mismatch()
+	}
+
+	switch t {
+	case objectType:
+		o, ok := doc.(*Object)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.ValueOf(o))
+		return nil
+	case uuidType:
+		s, ok := doc.(string)
+		if n, k := doc.(String); k {
+			s, ok = string(n), true
+		}
+		if !ok {
+			return mismatch()
+		}
+
+		b, e := hex.DecodeString(s)
+		if e != nil || len(b) != len(UUID{}) {
+			return errors.Errorf("%s: %q is not a uuid", path, s)
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		w := reflect.ValueOf(doc)
+		if !w.Type().AssignableTo(t) {
+			return mismatch()
+		}
+		v.Set(w)
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return fromValue(doc, v.Elem(), path)
+	case reflect.Bool:
+		b, ok := doc.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt(doc)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(i) {
+			return errors.Errorf("%s: %d overflows %s", path, i, t)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := toInt(doc)
+		if !ok {
+			return mismatch()
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return errors.Errorf("%s: %d overflows %s", path, i, t)
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(doc)
+		if !ok {
+			return mismatch()
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		switch n := doc.(type) {
+		case string:
+			v.SetString(n)
+		case String:
+			v.SetString(string(n))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice, reflect.Array:
+		a, ok := doc.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+
+		if t.Kind() == reflect.Array {
+			if len(a) != t.Len() {
+				return errors.Errorf("%s: %d elements, expect %d", path, len(a), t.Len())
+			}
+		} else {
+			v.Set(reflect.MakeSlice(t, len(a), len(a)))
+		}
+
+		for i := range a {
+			if e := fromValue(a[i], v.Index(i), fmt.Sprintf("%s[%d]", path, i)); e != nil {
+				return e
+			}
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return errors.Errorf("%s: map key %s is not a string", path, t.Key())
+		}
+
+		keys, members, ok := objectOf(doc)
+		if !ok {
+			return mismatch()
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(keys)))
+		}
+
+		for _, k := range keys {
+			w := reflect.New(t.Elem()).Elem()
+			if e := fromValue(members[k], w, path+pathKey(k)); e != nil {
+				return e
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), w)
+		}
+	case reflect.Struct:
+		_, members, ok := objectOf(doc)
+		if !ok {
+			return mismatch()
+		}
+
+		for _, f := range fields(t) {
+			m, ok := members[f.name]
+			if !ok {
+				continue
+			}
+
+			w := v
+			for i, x := range f.index {
+				if i > 0 && w.Kind() == reflect.Ptr {
+					if w.IsNil() {
+						w.Set(reflect.New(w.Type().Elem()))
+					}
+					w = w.Elem()
+				}
+				w = w.Field(x)
+			}
+
+			if e := fromValue(m, w, path+pathKey(f.name)); e != nil {
+				return e
+			}
+		}
+	default:
+		return errors.Errorf("%s: can not unmarshal into %s", path, t)
+	}
+
+	return nil
+}
+
+// objectOf returns the keys and members of any object form.
+func objectOf(doc interface{}) ([]string, map[string]interface{}, bool) {
+	switch n := doc.(type) {
+	case *Object:
+		return n.Keys(), n.values, true
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, n, true
+	case map[String]interface{}:
+		m := make(map[string]interface{}, len(n))
+		keys := make([]string, 0, len(n))
+		for k, v := range n {
+			m[string(k)] = v
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		return keys, m, true
+	default:
+		return nil, nil, false
+	}
+}
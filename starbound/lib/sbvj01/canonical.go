@@ -0,0 +1,165 @@
+package sbvj01
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// Canonical returns a copy of v in canonical form, which Write encodes the
+// same way for every value that means the same:
+//
+// objects have sorted keys, numbers that are integers in the int64 range are
+// int64 (VarintT), other numbers are float64 or NonFinite (NumberT) with a
+// single NaN, strings are String. The magic strings of NonFinite are numbers,
+// as Write does.
+func Canonical(v interface{}) (interface{}, error) {
+	return canonical(v, "$")
+}
+
+func canonical(v interface{}, path string) (interface{}, error) {
+	switch n := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return n, nil
+	case int64:
+		return n, nil
+	case Varint:
+		return int64(n), nil
+	case float64:
+		return canonicalFloat(n), nil
+	case NonFinite:
+		return canonicalFloat(float64(n)), nil
+	case json.Number:
+		if i, e := n.Int64(); e == nil {
+			return i, nil
+		}
+
+		f, e := n.Float64()
+		if e != nil {
+			return nil, errors.Errorf("%s: bad number %q", path, n)
+		}
+		return canonicalFloat(f), nil
+	case String:
+		return n, nil
+	case string:
+		switch n {
+		case "____NaN____":
+			return canonicalFloat(math.NaN()), nil
+		case "____+Inf____":
+			return canonicalFloat(math.Inf(1)), nil
+		case "____-Inf____":
+			return canonicalFloat(math.Inf(-1)), nil
+		}
+		return String(n), nil
+	case []interface{}:
+		r := make([]interface{}, len(n))
+		for i := range n {
+			var e error
+			r[i], e = canonical(n[i], fmt.Sprintf("%s[%d]", path, i))
+			if e != nil {
+				return nil, e
+			}
+		}
+		return r, nil
+	case map[String]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			m[string(k)] = v
+		}
+		return canonicalObject(m, path)
+	}
+
+	m, ok := Members(v)
+	if !ok {
+		return nil, errors.Errorf("%s: unknown type %T", path, v)
+	}
+	return canonicalObject(m, path)
+}
+
+func canonicalObject(m map[string]interface{}, path string) (*Object, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	r := NewObject()
+	for _, k := range keys {
+		v, e := canonical(m[k], path+pathKey(k))
+		if e != nil {
+			return nil, e
+		}
+		r.Set(k, v)
+	}
+	return r, nil
+}
+
+// canonicalFloat is an int64 if f is an integer in range, -0 included.
+func canonicalFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return NonFinite(math.NaN())
+	case math.IsInf(f, 0):
+		return NonFinite(f)
+	case f == math.Trunc(f) && f >= -(1<<63) && f < 1<<63:
+		return int64(f)
+	default:
+		return f
+	}
+}
+
+// WriteCanonical writes v in canonical form, see Canonical. Varints are
+// always written in their shortest encoding.
+func WriteCanonical(wt io.Writer, v interface{}) error {
+	c, e := Canonical(v)
+	if e != nil {
+		return e
+	}
+
+	return Write(wt, c)
+}
+
+// MarshalCanonical returns the canonical encoding of v.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e := WriteCanonical(buf, v); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash returns the sha256 of the canonical encoding of v, so values that are
+// Equal have the same hash.
+func Hash(v interface{}) ([sha256.Size]byte, error) {
+	r, e := MarshalCanonical(v)
+	if e != nil {
+		return [sha256.Size]byte{}, e
+	}
+	return sha256.Sum256(r), nil
+}
+
+// Equal tells whether a and b have the same canonical encoding: key order is
+// ignored, so is the type of numbers that are mathematically equal, and NaN
+// is equal to NaN. Values of unknown types are never equal.
+func Equal(a, b interface{}) bool {
+	x, e := MarshalCanonical(a)
+	if e != nil {
+		return false
+	}
+
+	y, e := MarshalCanonical(b)
+	if e != nil {
+		return false
+	}
+
+	return bytes.Equal(x, y)
+}
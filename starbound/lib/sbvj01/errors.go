@@ -0,0 +1,123 @@
+package sbvj01
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeError is an error at Offset bytes from where decoding started, in
+// the value at Path, like $.inventory.bags[3].parameters. Path is empty for
+// errors in the magic or the header.
+type DecodeError struct {
+	Offset int64
+	Path   string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError makes a DecodeError at off, with the path of the value being
+// read yet to be filled by within. io.EOF is unexpected in a value.
+func decodeError(off int64, e error) error {
+	if _, ok := e.(*DecodeError); ok {
+		return e
+	}
+
+	return &DecodeError{Offset: off, Err: unexpected(e)}
+}
+
+func unexpected(e error) error {
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+// within prefixes the path of a DecodeError with the element it is in.
+func within(e error, elem string) error {
+	if r, ok := e.(*DecodeError); ok {
+		r.Path = elem + r.Path
+	}
+	return e
+}
+
+// rebase shifts the offset of a DecodeError by base bytes and prefixes its
+// path, for values read by Read in the middle of a larger input.
+func rebase(e error, base int64, path string) error {
+	if r, ok := e.(*DecodeError); ok {
+		r.Offset += base
+		r.Path = path + strings.TrimPrefix(r.Path, "$")
+	}
+	return e
+}
+
+// Snippet dumps the 16 bytes rows of data around off, the byte at off is
+// marked by brackets.
+func Snippet(data []byte, off int64) string {
+	if off < 0 || off > int64(len(data)) {
+		return ""
+	}
+
+	start := off&^15 - 32
+	if start < 0 {
+		start = 0
+	}
+
+	end := off&^15 + 48
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+
+	b := &strings.Builder{}
+	for row := start; row < end; row += 16 {
+		fmt.Fprintf(b, "%08x ", row)
+		for i := row; i < row+16 && i < end; i++ {
+			switch {
+			case i == off:
+				fmt.Fprintf(b, "[%02x]", data[i])
+			case i == off+1:
+				fmt.Fprintf(b, "%02x", data[i])
+			default:
+				fmt.Fprintf(b, " %02x", data[i])
+			}
+		}
+		if off == end && row+16 >= end {
+			b.WriteString(" [EOF]")
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// ErrorContext formats e for the command line tools. If e is or wraps a
+// DecodeError, its offset is shown moved by base, the position in data where
+// decoding started, and a Snippet of data around it is appended. e is not
+// changed, so it could be formatted again.
+func ErrorContext(e error, data []byte, base int64) string {
+	var de *DecodeError
+	if !errors.As(e, &de) {
+		return fmt.Sprintf("%+v", e)
+	}
+
+	moved := *de
+	moved.Offset += base
+
+	// messages wrapping de end with its own
+	msg := e.Error()
+	msg = strings.TrimSuffix(msg, de.Error()) + moved.Error()
+
+	return fmt.Sprintf("%s\n%s", msg, Snippet(data, moved.Offset))
+}
@@ -0,0 +1,208 @@
+package sbvj01
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// Form is how a versioned json is stored.
+type Form int
+
+const (
+	// FormMagic is Magic, then the header and the body, like .player files.
+	FormMagic Form = iota
+	// FormHdr is the header and the body, like entities in world files.
+	FormHdr
+	// FormRaw is the body only.
+	FormRaw
+)
+
+var forms = []string{"vjmagic", "vj", "raw"}
+
+func (f Form) String() string {
+	if f < 0 || int(f) >= len(forms) {
+		return "unknown"
+	}
+	return forms[f]
+}
+
+// ParseForm accepts the names used by the -m flag of dumpsbvj01 and
+// makesbvj01.
+func ParseForm(s string) (Form, error) {
+	for k, v := range forms {
+		if v == s {
+			return Form(k), nil
+		}
+	}
+	return 0, errors.Errorf("unknown form %q", s)
+}
+
+// VersionedJson is a body with its header, the header is zero for FormRaw.
+type VersionedJson struct {
+	VerJsonHdr
+	Content interface{}
+}
+
+func ReadMagic(rd io.Reader) error {
+	buf := make([]byte, len(Magic))
+	if _, e := io.ReadFull(rd, buf); e != nil {
+		return e
+	}
+
+	if !bytes.Equal(buf, Magic) {
+		return errors.Errorf("bad magic %q", buf)
+	}
+
+	return nil
+}
+
+func WriteMagic(wt io.Writer) error {
+	_, e := wt.Write(Magic)
+	return e
+}
+
+// ReadVersioned reads a versioned json of the form within DefaultLimits.
+// Errors are DecodeError, with offsets from the start of rd.
+func ReadVersioned(rd io.Reader, form Form) (*VersionedJson, error) {
+	return DefaultLimits.ReadVersioned(rd, form)
+}
+
+func WriteVersioned(wt io.Writer, vj *VersionedJson, form Form) error {
+	return DefaultLimits.WriteVersioned(wt, vj, form)
+}
+
+// WriteVersioned is WriteVersioned with the Strings policy of these limits.
+func (l Limits) WriteVersioned(wt io.Writer, vj *VersionedJson, form Form) error {
+	switch form {
+	case FormMagic:
+		if e := WriteMagic(wt); e != nil {
+			return e
+		}
+		fallthrough
+	case FormHdr:
+		if e := writeHdr(wt, vj.VerJsonHdr, l.Strings); e != nil {
+			return e
+		}
+	case FormRaw:
+	default:
+		return errors.Errorf("unknown form %d", form)
+	}
+
+	return l.Write(wt, vj.Content)
+}
+
+// ReadFile reads a whole file, trailing bytes are an error.
+func ReadFile(name string, form Form) (*VersionedJson, error) {
+	data, e := ioutil.ReadFile(name)
+	if e != nil {
+		return nil, e
+	}
+
+	rd := bytes.NewReader(data)
+
+	r, e := ReadVersioned(rd, form)
+	if e != nil {
+		return nil, errors.Wrapf(e, "%s", name)
+	}
+
+	if rd.Len() != 0 {
+		return nil, errors.Errorf("%s: %d trailing bytes", name, rd.Len())
+	}
+
+	return r, nil
+}
+
+func WriteFile(name string, vj *VersionedJson, form Form) error {
+	f, e := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if e != nil {
+		return e
+	}
+
+	wt := bufio.NewWriter(f)
+
+	if e := WriteVersioned(wt, vj, form); e != nil {
+		f.Close()
+		return e
+	}
+
+	if e := wt.Flush(); e != nil {
+		f.Close()
+		return e
+	}
+
+	return f.Close()
+}
+
+// Document returns the json dumpsbvj01 outputs for the header forms.
+func (vj *VersionedJson) Document() *Object {
+	r := NewObject()
+	r.Set("id", string(vj.Id))
+	r.Set("versioned", vj.Versioned)
+	r.Set("version", int64(vj.Version))
+	r.Set("content", vj.Content)
+	return r
+}
+
+// SetDocument is the reverse of Document, versioned defaults to true.
+func (vj *VersionedJson) SetDocument(doc interface{}) error {
+	v, ok := Members(doc)
+	if !ok {
+		return errors.New("versioned json is not an object")
+	}
+
+	var id string
+	switch n := v["id"].(type) {
+	case string:
+		id = n
+	case String:
+		id = string(n)
+	default:
+		return errors.New("id is not a string")
+	}
+
+	versioned := true
+	if n, ok := v["versioned"]; ok {
+		versioned, ok = n.(bool)
+		if !ok {
+			return errors.New("versioned is not a bool")
+		}
+	}
+
+	var version int64
+	switch n := v["version"].(type) {
+	case json.Number:
+		var e error
+		version, e = n.Int64()
+		if e != nil {
+			return errors.Wrapf(e, "version")
+		}
+	case float64:
+		version = int64(n)
+	case int64:
+		version = n
+	case nil:
+		if versioned {
+			return errors.New("no version")
+		}
+	default:
+		return errors.New("version is not a number")
+	}
+
+	content, ok := v["content"]
+	if !ok {
+		return errors.New("no content")
+	}
+
+	vj.Id = String(id)
+	vj.Versioned = versioned
+	vj.Version = int32(version)
+	vj.Content = content
+	return nil
+}
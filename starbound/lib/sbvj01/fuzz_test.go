@@ -0,0 +1,139 @@
+//go:build go1.18
+// +build go1.18
+
+package sbvj01
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// seeds are valid encodings to start fuzzing from.
+func seeds(f *testing.F) {
+	data := fixture()
+	f.Add(data)
+
+	rd := bytes.NewReader(data[len(Magic):])
+	if _, e := ReadHdr(rd); e != nil {
+		f.Fatal(e)
+	}
+	f.Add(data[len(data)-rd.Len():])
+
+	for _, v := range []interface{}{
+		nil,
+		int64(-1 << 62),
+		"____-Inf____",
+		[]interface{}{[]interface{}{NewObject()}, true, 0.5},
+		map[string]interface{}{"a": map[string]interface{}{"": "b"}},
+	} {
+		buf := &bytes.Buffer{}
+		if e := Write(buf, v); e != nil {
+			f.Fatal(e)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+var fuzzLimits = Limits{
+	MaxSize:     1 << 20,
+	MaxDepth:    64,
+	MaxString:   1 << 16,
+	MaxElements: 1 << 16,
+}
+
+func decodeFailure(t *testing.T, e error) {
+	var de *DecodeError
+	if e != io.EOF && !errors.As(e, &de) {
+		t.Fatalf("%T is not a DecodeError: %v", e, e)
+	}
+}
+
+// FuzzRead checks that decoding never panics, and that anything decoded is
+// encoded to bytes that decode to the same encoding.
+func FuzzRead(f *testing.F) {
+	seeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, e := fuzzLimits.Read(bytes.NewReader(data))
+		if e != nil {
+			decodeFailure(t, e)
+			return
+		}
+
+		a := &bytes.Buffer{}
+		if e := Write(a, v); e != nil {
+			t.Fatal(e)
+		}
+
+		w, e := Read(bytes.NewReader(a.Bytes()))
+		if e != nil {
+			t.Fatalf("re-encoding does not decode: %v", e)
+		}
+
+		b := &bytes.Buffer{}
+		if e := Write(b, w); e != nil {
+			t.Fatal(e)
+		}
+
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			t.Fatalf("unstable encoding\n%x\n%x", a.Bytes(), b.Bytes())
+		}
+	})
+}
+
+// FuzzDecoder checks that the streaming decoder agrees with Read.
+func FuzzDecoder(f *testing.F) {
+	seeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, re := fuzzLimits.Read(bytes.NewReader(data))
+
+		d := fuzzLimits.NewDecoder(bytes.NewReader(data))
+		enc := NewEncoder(&bytes.Buffer{})
+		var e error
+		for {
+			var tok Token
+			tok, e = d.Token()
+			if e != nil {
+				break
+			}
+
+			if e = enc.Token(tok, d.Len()); e != nil {
+				t.Fatalf("decoded token %v does not encode: %v", tok, e)
+			}
+
+			if len(d.stack) == 0 {
+				if _, ok := tok.(Delim); !ok || tok == Delim(']') || tok == Delim('}') {
+					break
+				}
+			}
+		}
+
+		if re == nil && e != nil {
+			t.Fatalf("Read succeeds, Token fails: %v", e)
+		}
+
+		if re != nil && e == nil {
+			t.Fatalf("Token succeeds, Read fails: %v", re)
+		}
+
+		if e != nil {
+			decodeFailure(t, e)
+		}
+	})
+}
+
+func FuzzReadVersioned(f *testing.F) {
+	seeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, form := range []Form{FormMagic, FormHdr, FormRaw} {
+			_, e := fuzzLimits.ReadVersioned(bytes.NewReader(data), form)
+			if e != nil {
+				decodeFailure(t, e)
+			}
+		}
+	})
+}
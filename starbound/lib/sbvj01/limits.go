@@ -0,0 +1,180 @@
+package sbvj01
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// Limits bound what is decoded from untrusted input, zero is no limit, but
+// for MaxString.
+type Limits struct {
+	// MaxSize is the number of bytes read.
+	MaxSize int64
+	// MaxDepth is the nesting of arrays and objects.
+	MaxDepth int
+	// MaxString is the length of a string or key in bytes, zero is
+	// data_types.MaxByteArray.
+	MaxString uint64
+	// MaxElements is the length of an array or object.
+	MaxElements uint64
+	// Strings is applied to the strings, keys and header ids read, and
+	// written by Write and WriteVersioned of the Limits. UTF8Accept, the
+	// default, keeps broken files readable.
+	Strings UTF8Policy
+}
+
+// DefaultLimits are used by Read, ReadVersioned, ReadFile and NewDecoder.
+// They are far above what the game writes, but keep a crafted input from
+// exhausting the stack.
+var DefaultLimits = Limits{
+	MaxDepth:    512,
+	MaxString:   16 << 20,
+	MaxElements: 1 << 24,
+}
+
+// ErrLimit is wrapped by errors about input over the Limits.
+var ErrLimit = errors.New("over the limit")
+
+// Read is Read with these limits.
+func (l Limits) Read(rd io.Reader) (interface{}, error) {
+	c := &counter{rd: rd, lim: l}
+
+	r, e := read(c)
+	if e != nil && c.off == 0 && errors.Is(e, io.ErrUnexpectedEOF) {
+		return nil, io.EOF
+	}
+
+	return r, e
+}
+
+// ReadVersioned is ReadVersioned with these limits.
+func (l Limits) ReadVersioned(rd io.Reader, form Form) (*VersionedJson, error) {
+	return readVersioned(&counter{rd: rd, lim: l}, form)
+}
+
+// ReadList is ReadList with these limits, the count is bounded by
+// MaxElements.
+func (l Limits) ReadList(rd io.Reader) (List, error) {
+	c := &counter{rd: rd, lim: l}
+
+	n, e := byteorder.UVarint(c, byteorder.BigEndian)
+	if e != nil {
+		return nil, decodeError(c.off, errors.Wrapf(unexpected(e), "failed to read count"))
+	}
+
+	if max := l.MaxElements; max > 0 && n > max {
+		return nil, decodeError(0, errors.Wrapf(ErrLimit, "%d versioned jsons, max %d", n, max))
+	}
+
+	r := List{}
+	for i := uint64(0); i < n; i++ {
+		vj, e := readVersioned(c, FormHdr)
+		if e != nil {
+			return nil, within(e, fmt.Sprintf("[%d]", i))
+		}
+		r = append(r, vj)
+	}
+
+	return r, nil
+}
+
+func readVersioned(c *counter, form Form) (*VersionedJson, error) {
+	r := &VersionedJson{}
+
+	switch form {
+	case FormMagic:
+		if e := ReadMagic(c); e != nil {
+			return nil, decodeError(0, e)
+		}
+		fallthrough
+	case FormHdr:
+		hdr, e := readHdr(c)
+		if e != nil {
+			return nil, decodeError(c.off, errors.Wrapf(unexpected(e), "failed to read header"))
+		}
+		r.VerJsonHdr = hdr
+	case FormRaw:
+	default:
+		return nil, errors.Errorf("unknown form %d", form)
+	}
+
+	var e error
+	r.Content, e = read(c)
+	if e != nil {
+		return nil, e
+	}
+
+	return r, nil
+}
+
+// counter counts the bytes read, for offsets in errors, and enforces the
+// limits.
+type counter struct {
+	rd    io.Reader
+	off   int64
+	lim   Limits
+	depth int
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	if max := c.lim.MaxSize; max > 0 {
+		if c.off >= max {
+			return 0, errors.Wrapf(ErrLimit, "input is over %d bytes", max)
+		}
+
+		if int64(len(p)) > max-c.off {
+			p = p[:max-c.off]
+		}
+	}
+
+	n, e := c.rd.Read(p)
+	c.off += int64(n)
+	return n, e
+}
+
+// push enters an array or object of n elements, pop leaves it.
+func (c *counter) push(n uint64) error {
+	if max := c.lim.MaxElements; max > 0 && n > max {
+		return errors.Wrapf(ErrLimit, "%d elements, max %d", n, max)
+	}
+
+	if max := c.lim.MaxDepth; max > 0 && c.depth >= max {
+		return errors.Wrapf(ErrLimit, "nested over %d levels", max)
+	}
+
+	c.depth++
+	return nil
+}
+
+func (c *counter) pop() {
+	c.depth--
+}
+
+func (l Limits) maxString() uint64 {
+	if l.MaxString == 0 {
+		return MaxByteArray
+	}
+	return l.MaxString
+}
+
+func (c *counter) readString() (String, error) {
+	n, e := byteorder.UVarint(c, byteorder.BigEndian)
+	if e != nil {
+		return "", e
+	}
+
+	if max := c.lim.maxString(); n > max {
+		return "", errors.Wrapf(ErrLimit, "string of %d bytes, max %d", n, max)
+	}
+
+	r, e := ReadN(c, n)
+	if e != nil {
+		return "", e
+	}
+
+	return c.lim.Strings.Apply(String(r))
+}
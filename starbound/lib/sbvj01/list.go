@@ -0,0 +1,72 @@
+package sbvj01
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+)
+
+// List is a count, then versioned jsons of FormHdr, as the game writes a list
+// of versioned jsons. The count is a UVarint as in the entity records of
+// worlds, which is a single byte below 128.
+type List []*VersionedJson
+
+// ReadList reads a list within DefaultLimits. Errors are DecodeError, with
+// offsets from the start of rd, and paths prefixed by the index, like [2]$.a.
+func ReadList(rd io.Reader) (List, error) {
+	return DefaultLimits.ReadList(rd)
+}
+
+func (l *List) Read(rd io.Reader) error {
+	r, e := ReadList(rd)
+	if e != nil {
+		return e
+	}
+
+	*l = r
+	return nil
+}
+
+func (l List) Write(wt io.Writer) error {
+	if e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(l))); e != nil {
+		return e
+	}
+
+	for _, v := range l {
+		if e := WriteVersioned(wt, v, FormHdr); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Document returns an array of the documents of the versioned jsons, as
+// dumpsbvj01 outputs.
+func (l List) Document() []interface{} {
+	r := make([]interface{}, len(l))
+	for k, v := range l {
+		r[k] = v.Document()
+	}
+	return r
+}
+
+// SetDocument is the reverse of Document.
+func (l *List) SetDocument(doc interface{}) error {
+	d, ok := doc.([]interface{})
+	if !ok {
+		return errors.New("versioned json list is not an array")
+	}
+
+	r := make(List, len(d))
+	for k := range d {
+		r[k] = &VersionedJson{}
+		if e := r[k].SetDocument(d[k]); e != nil {
+			return errors.Wrapf(e, "versioned json %d", k)
+		}
+	}
+
+	*l = r
+	return nil
+}
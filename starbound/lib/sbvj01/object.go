@@ -0,0 +1,207 @@
+package sbvj01
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Object is a json object that keeps its members in the order they were
+// read or added, so re-encoding a file does not shuffle it.
+type Object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func NewObject() *Object {
+	return &Object{values: map[string]interface{}{}}
+}
+
+func (o *Object) Len() int {
+	return len(o.keys)
+}
+
+// Keys returns the keys in order, the slice must not be modified.
+func (o *Object) Keys() []string {
+	return o.keys
+}
+
+func (o *Object) Get(k string) (interface{}, bool) {
+	v, ok := o.values[k]
+	return v, ok
+}
+
+// Set replaces the value of k in place, or appends k if it is missing.
+func (o *Object) Set(k string, v interface{}) {
+	if o.values == nil {
+		o.values = map[string]interface{}{}
+	}
+	if _, ok := o.values[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+func (o *Object) Delete(k string) bool {
+	if _, ok := o.values[k]; !ok {
+		return false
+	}
+
+	delete(o.values, k)
+	for i := range o.keys {
+		if o.keys[i] == k {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Map returns the members as a plain map.
+func (o *Object) Map() map[string]interface{} {
+	r := make(map[string]interface{}, len(o.values))
+	for k, v := range o.values {
+		r[k] = v
+	}
+	return r
+}
+
+func (o *Object) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+
+	for i, k := range o.keys {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		key, e := json.Marshal(k)
+		if e != nil {
+			return nil, e
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		v, e := json.Marshal(o.values[k])
+		if e != nil {
+			return nil, errors.Wrapf(e, "%s", k)
+		}
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o *Object) UnmarshalJSON(data []byte) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	v, e := decodeJSON(d)
+	if e != nil {
+		return e
+	}
+
+	r, ok := v.(*Object)
+	if !ok {
+		return errors.New("not an object")
+	}
+
+	*o = *r
+	return nil
+}
+
+// DecodeJSON decodes one json value like encoding/json with UseNumber, but
+// objects are decoded to *Object in document order.
+func DecodeJSON(rd io.Reader) (interface{}, error) {
+	d := json.NewDecoder(rd)
+	d.UseNumber()
+	return decodeJSON(d)
+}
+
+func decodeJSON(d *json.Decoder) (interface{}, error) {
+	tok, e := d.Token()
+	if e != nil {
+		return nil, e
+	}
+
+	switch n := tok.(type) {
+	case json.Delim:
+		switch n {
+		case '{':
+			r := NewObject()
+			for d.More() {
+				k, e := d.Token()
+				if e != nil {
+					return nil, e
+				}
+
+				v, e := decodeJSON(d)
+				if e != nil {
+					return nil, e
+				}
+
+				r.Set(k.(string), v)
+			}
+
+			if _, e := d.Token(); e != nil {
+				return nil, e
+			}
+			return r, nil
+		case '[':
+			r := []interface{}{}
+			for d.More() {
+				v, e := decodeJSON(d)
+				if e != nil {
+					return nil, e
+				}
+
+				r = append(r, v)
+			}
+
+			if _, e := d.Token(); e != nil {
+				return nil, e
+			}
+			return r, nil
+		default:
+			return nil, errors.Errorf("unexpected %v", n)
+		}
+	default:
+		return tok, nil
+	}
+}
+
+// Keys returns the keys of an object decoded by encoding/json or by this
+// package, in their order for *Object and sorted for plain maps, and nil for
+// anything else.
+func Keys(doc interface{}) []string {
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		r := make([]string, 0, len(n))
+		for k := range n {
+			r = append(r, k)
+		}
+		sort.Strings(r)
+		return r
+	case *Object:
+		return n.Keys()
+	default:
+		return nil
+	}
+}
+
+// Members returns the members of an object decoded by encoding/json or by
+// this package, and false for anything else.
+func Members(doc interface{}) (map[string]interface{}, bool) {
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		return n, true
+	case *Object:
+		return n.values, true
+	default:
+		return nil, false
+	}
+}
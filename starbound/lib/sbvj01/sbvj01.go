@@ -2,8 +2,10 @@ package sbvj01
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"math"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/xhebox/bstruct/byteorder"
@@ -25,24 +27,28 @@ const (
 )
 
 type VerJsonHdr struct {
-	Id        String `json:"id"`
-	Versioned bool   `json:"versioned"`
-	Version   int32  `json:"version"`
+	Id        String `json:"id" sbvj:"id"`
+	Versioned bool   `json:"versioned" sbvj:"versioned"`
+	Version   int32  `json:"version" sbvj:"version"`
 }
 
-func ReadHdr(rd io.Reader) (r VerJsonHdr, e error) {
-	r.Id, e = ReadString(rd, byteorder.BigEndian)
+func ReadHdr(rd io.Reader) (VerJsonHdr, error) {
+	return readHdr(&counter{rd: rd, lim: DefaultLimits})
+}
+
+func readHdr(c *counter) (r VerJsonHdr, e error) {
+	r.Id, e = c.readString()
 	if e != nil {
 		return
 	}
 
-	r.Versioned, e = byteorder.Bool(rd)
+	r.Versioned, e = byteorder.Bool(c)
 	if e != nil {
 		return
 	}
 
 	if r.Versioned {
-		r.Version, e = byteorder.Int32(rd, byteorder.BigEndian)
+		r.Version, e = byteorder.Int32(c, byteorder.BigEndian)
 		if e != nil {
 			return
 		}
@@ -52,9 +58,11 @@ func ReadHdr(rd io.Reader) (r VerJsonHdr, e error) {
 }
 
 func WriteHdr(wt io.Writer, r VerJsonHdr) error {
-	strlen := len(r.Id)
+	return writeHdr(wt, r, DefaultLimits.Strings)
+}
 
-	if e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(strlen)); e != nil {
+func writeHdr(wt io.Writer, r VerJsonHdr, p UTF8Policy) error {
+	if e := p.WriteString(wt, byteorder.BigEndian, r.Id); e != nil {
 		return e
 	}
 
@@ -71,52 +79,78 @@ func WriteHdr(wt io.Writer, r VerJsonHdr) error {
 	return nil
 }
 
+// Read reads a value within DefaultLimits, io.EOF if rd is empty. Other
+// errors are DecodeError, with offsets from the start of rd.
 func Read(rd io.Reader) (interface{}, error) {
-	typ, e := byteorder.Uint8(rd)
+	return DefaultLimits.Read(rd)
+}
+
+// read reads a value from c, offsets of errors are those of c.
+func read(c *counter) (interface{}, error) {
+	typ, e := byteorder.Uint8(c)
 	if e != nil {
-		return nil, e
+		return nil, within(decodeError(c.off, e), "$")
 	}
 
+	r, e := readValue(c, typ)
+	if e != nil {
+		return nil, within(e, "$")
+	}
+
+	return r, nil
+}
+
+func readValue(c *counter, typ byte) (interface{}, error) {
 	switch typ {
-	case NullT:
-		return nil, nil
-	case NumberT:
-		r, e := byteorder.Float64(rd, byteorder.BigEndian)
-		if math.IsNaN(r) {
-			return "____NaN____", e
-		} else if math.IsInf(r, 1) {
-			return "____+Inf____", e
-		} else if math.IsInf(r, -1) {
-			return "____-Inf____", e
-		}
-		return r, e
-	case BoolT:
-		return byteorder.Bool(rd)
-	case VarintT:
-		return byteorder.Varint(rd, byteorder.BigEndian)
-	case StringT:
-		return ReadString(rd, byteorder.BigEndian)
 	case ArrayT:
-		return ReadArray(rd)
+		return readArray(c)
 	case ObjectT:
-		return ReadObject(rd)
-	default:
-		return nil, errors.Errorf("unknown type %d", typ)
+		return readObject(c)
+	}
+
+	if typ < NullT || typ > ObjectT {
+		return nil, decodeError(c.off-1, errors.Errorf("unknown type %d", typ))
 	}
+
+	r, e := c.readScalar(typ)
+	if e != nil {
+		return nil, decodeError(c.off, e)
+	}
+
+	return r, nil
 }
 
+// ReadArray reads an array after its type byte.
 func ReadArray(rd io.Reader) ([]interface{}, error) {
-	cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
+	r, e := readArray(&counter{rd: rd, lim: DefaultLimits})
 	if e != nil {
-		return nil, e
+		return nil, within(e, "$")
 	}
+	return r, nil
+}
+
+func readArray(c *counter) ([]interface{}, error) {
+	cnt, e := byteorder.UVarint(c, byteorder.BigEndian)
+	if e != nil {
+		return nil, decodeError(c.off, e)
+	}
+
+	if e := c.push(cnt); e != nil {
+		return nil, decodeError(c.off, e)
+	}
+	defer c.pop()
 
 	r := []interface{}{}
 
-	for i, c := 0, int(cnt); i < c; i++ {
-		value, e := Read(rd)
+	for i, n := 0, int(cnt); i < n; i++ {
+		typ, e := byteorder.Uint8(c)
 		if e != nil {
-			return nil, e
+			return nil, within(decodeError(c.off, e), fmt.Sprintf("[%d]", i))
+		}
+
+		value, e := readValue(c, typ)
+		if e != nil {
+			return nil, within(e, fmt.Sprintf("[%d]", i))
 		}
 
 		r = append(r, value)
@@ -125,32 +159,61 @@ func ReadArray(rd io.Reader) ([]interface{}, error) {
 	return r, nil
 }
 
-func ReadObject(rd io.Reader) (map[String]interface{}, error) {
-	cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
+// ReadObject reads an object after its type byte.
+func ReadObject(rd io.Reader) (*Object, error) {
+	r, e := readObject(&counter{rd: rd, lim: DefaultLimits})
 	if e != nil {
-		return nil, e
+		return nil, within(e, "$")
 	}
+	return r, nil
+}
 
-	r := map[String]interface{}{}
+func readObject(c *counter) (*Object, error) {
+	cnt, e := byteorder.UVarint(c, byteorder.BigEndian)
+	if e != nil {
+		return nil, decodeError(c.off, e)
+	}
+
+	if e := c.push(cnt); e != nil {
+		return nil, decodeError(c.off, e)
+	}
+	defer c.pop()
+
+	r := NewObject()
+
+	for i, n := 0, int(cnt); i < n; i++ {
+		key, e := c.readString()
+		if e != nil {
+			return nil, decodeError(c.off, errors.Wrapf(unexpected(e), "key of member %d", i))
+		}
 
-	for i, c := 0, int(cnt); i < c; i++ {
-		key, e := ReadString(rd, byteorder.BigEndian)
+		typ, e := byteorder.Uint8(c)
 		if e != nil {
-			return nil, e
+			return nil, within(decodeError(c.off, e), pathKey(string(key)))
 		}
 
-		value, e := Read(rd)
+		value, e := readValue(c, typ)
 		if e != nil {
-			return nil, e
+			return nil, within(e, pathKey(string(key)))
 		}
 
-		r[key] = value
+		r.Set(string(key), value)
 	}
 
 	return r, nil
 }
 
+// Write writes a value, DefaultLimits.Strings is applied to its strings.
 func Write(wt io.Writer, anything interface{}) error {
+	return write(wt, anything, DefaultLimits.Strings)
+}
+
+// Write is Write with the Strings policy of these limits.
+func (l Limits) Write(wt io.Writer, anything interface{}) error {
+	return write(wt, anything, l.Strings)
+}
+
+func write(wt io.Writer, anything interface{}, p UTF8Policy) error {
 	switch n := anything.(type) {
 	case nil:
 		if e := byteorder.PutUint8(wt, NullT); e != nil {
@@ -200,34 +263,20 @@ func Write(wt io.Writer, anything interface{}) error {
 		}
 
 		return byteorder.PutBool(wt, n)
-	case String:
-		switch n {
-		case "____NaN____":
-			if e := byteorder.PutUint8(wt, NumberT); e != nil {
-				return e
-			}
-
-			return byteorder.PutFloat64(wt, byteorder.BigEndian, math.NaN())
-		case "____+Inf____":
-			if e := byteorder.PutUint8(wt, NumberT); e != nil {
-				return e
-			}
-
-			return byteorder.PutFloat64(wt, byteorder.BigEndian, math.Inf(1))
-		case "____-Inf____":
-			if e := byteorder.PutUint8(wt, NumberT); e != nil {
-				return e
-			}
-
-			return byteorder.PutFloat64(wt, byteorder.BigEndian, math.Inf(-1))
-		default:
-			if e := byteorder.PutUint8(wt, StringT); e != nil {
-				return e
-			}
+	case NonFinite:
+		if e := byteorder.PutUint8(wt, NumberT); e != nil {
+			return e
+		}
 
-			return n.Write(wt, byteorder.BigEndian)
+		return byteorder.PutFloat64(wt, byteorder.BigEndian, float64(n))
+	case String:
+		if e := byteorder.PutUint8(wt, StringT); e != nil {
+			return e
 		}
+
+		return p.WriteString(wt, byteorder.BigEndian, n)
 	case string:
+		// plain json has no NaN/Inf, see NonFinite
 		switch n {
 		case "____NaN____":
 			if e := byteorder.PutUint8(wt, NumberT); e != nil {
@@ -252,27 +301,37 @@ func Write(wt io.Writer, anything interface{}) error {
 				return e
 			}
 
-			r := String(n)
-			return r.Write(wt, byteorder.BigEndian)
+			return p.WriteString(wt, byteorder.BigEndian, String(n))
 		}
 	case []interface{}:
 		if e := byteorder.PutUint8(wt, ArrayT); e != nil {
 			return e
 		}
 
-		return WriteArray(wt, n)
+		return writeArray(wt, n, p)
+	case *Object:
+		if e := byteorder.PutUint8(wt, ObjectT); e != nil {
+			return e
+		}
+
+		return writeObject(wt, n, p)
 	case map[String]interface{}:
 		if e := byteorder.PutUint8(wt, ObjectT); e != nil {
 			return e
 		}
 
-		return WriteObject(wt, n)
+		m := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			m[string(k)] = v
+		}
+
+		return writeobj(wt, m, p)
 	case map[string]interface{}:
 		if e := byteorder.PutUint8(wt, ObjectT); e != nil {
 			return e
 		}
 
-		return writeobj(wt, n)
+		return writeobj(wt, n, p)
 	default:
 		return errors.Errorf("unknown type %+v", anything)
 	}
@@ -281,6 +340,10 @@ func Write(wt io.Writer, anything interface{}) error {
 }
 
 func WriteArray(wt io.Writer, array []interface{}) error {
+	return writeArray(wt, array, DefaultLimits.Strings)
+}
+
+func writeArray(wt io.Writer, array []interface{}, p UTF8Policy) error {
 	arrlen := len(array)
 
 	e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(arrlen))
@@ -289,7 +352,7 @@ func WriteArray(wt io.Writer, array []interface{}) error {
 	}
 
 	for i := 0; i < arrlen; i++ {
-		if e := Write(wt, array[i]); e != nil {
+		if e := write(wt, array[i], p); e != nil {
 			return e
 		}
 	}
@@ -297,18 +360,23 @@ func WriteArray(wt io.Writer, array []interface{}) error {
 	return nil
 }
 
-func WriteObject(wt io.Writer, object map[String]interface{}) error {
-	e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(object)))
+// WriteObject writes members in order.
+func WriteObject(wt io.Writer, object *Object) error {
+	return writeObject(wt, object, DefaultLimits.Strings)
+}
+
+func writeObject(wt io.Writer, object *Object, p UTF8Policy) error {
+	e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(object.Len()))
 	if e != nil {
 		return e
 	}
 
-	for k, v := range object {
-		if e := k.Write(wt, byteorder.BigEndian); e != nil {
+	for _, k := range object.keys {
+		if e := p.WriteString(wt, byteorder.BigEndian, String(k)); e != nil {
 			return e
 		}
 
-		if e := Write(wt, v); e != nil {
+		if e := write(wt, object.values[k], p); e != nil {
 			return e
 		}
 	}
@@ -316,20 +384,26 @@ func WriteObject(wt io.Writer, object map[String]interface{}) error {
 	return nil
 }
 
-func writeobj(wt io.Writer, object map[string]interface{}) error {
+// writeobj writes plain maps sorted by key, so the output is deterministic.
+// Decoded objects are *Object and keep the order of the file.
+func writeobj(wt io.Writer, object map[string]interface{}, p UTF8Policy) error {
 	e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(object)))
 	if e != nil {
 		return e
 	}
 
-	for k, v := range object {
-		r := String(k)
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-		if e := r.Write(wt, byteorder.BigEndian); e != nil {
+	for _, k := range keys {
+		if e := p.WriteString(wt, byteorder.BigEndian, String(k)); e != nil {
 			return e
 		}
 
-		if e := Write(wt, v); e != nil {
+		if e := write(wt, object[k], p); e != nil {
 			return e
 		}
 	}
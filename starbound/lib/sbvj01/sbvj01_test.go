@@ -0,0 +1,1000 @@
+package sbvj01
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xhebox/bstruct/byteorder"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// fixture builds a .player like file byte by byte, without using the writer.
+// Object members are not sorted, as in files written by the game.
+func fixture() []byte {
+	buf := &bytes.Buffer{}
+	str := func(s string) {
+		byteorder.PutUVarint(buf, byteorder.BigEndian, uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	buf.Write(Magic)
+	str("PlayerEntity")
+	buf.WriteByte(1)
+	buf.Write([]byte{0, 0, 0, 30})
+
+	buf.WriteByte(ObjectT)
+	buf.WriteByte(10)
+
+	str("zeta")
+	buf.WriteByte(NullT)
+
+	str("description")
+	buf.WriteByte(StringT)
+	str("a été player")
+
+	str("identity")
+	buf.WriteByte(ObjectT)
+	buf.WriteByte(2)
+	str("species")
+	buf.WriteByte(StringT)
+	str("human")
+	str("name")
+	buf.WriteByte(StringT)
+	str("Nova")
+
+	str("inventory")
+	buf.WriteByte(ArrayT)
+	buf.WriteByte(3)
+	buf.WriteByte(VarintT)
+	byteorder.PutVarint(buf, byteorder.BigEndian, -300)
+	buf.WriteByte(ArrayT)
+	buf.WriteByte(0)
+	buf.WriteByte(ObjectT)
+	buf.WriteByte(0)
+
+	str("modeType")
+	buf.WriteByte(StringT)
+	str("casual")
+
+	str("nan")
+	buf.WriteByte(NumberT)
+	byteorder.PutFloat64(buf, byteorder.BigEndian, math.NaN())
+
+	str("position")
+	buf.WriteByte(ArrayT)
+	buf.WriteByte(2)
+	buf.WriteByte(NumberT)
+	byteorder.PutFloat64(buf, byteorder.BigEndian, 1024.5)
+	buf.WriteByte(NumberT)
+	byteorder.PutFloat64(buf, byteorder.BigEndian, 1)
+
+	str("uuid")
+	buf.WriteByte(BoolT)
+	buf.WriteByte(1)
+
+	str("magic")
+	buf.WriteByte(StringT)
+	str("____NaN____")
+
+	str("escaped")
+	buf.WriteByte(ObjectT)
+	buf.WriteByte(1)
+	str("$double")
+	buf.WriteByte(NumberT)
+	byteorder.PutFloat64(buf, byteorder.BigEndian, math.Inf(-1))
+
+	return buf.Bytes()
+}
+
+func roundtrip(t *testing.T, data []byte, form Form) {
+	rd := bytes.NewReader(data)
+
+	vj, e := ReadVersioned(rd, form)
+	if e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	if rd.Len() != 0 {
+		t.Fatalf("%d trailing bytes", rd.Len())
+	}
+
+	buf := &bytes.Buffer{}
+	if e := WriteVersioned(buf, vj, form); e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("re-encoded %d bytes differ from the %d input bytes\n%x\n%x", buf.Len(), len(data), buf.Bytes(), data)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	data := fixture()
+
+	roundtrip(t, data, FormMagic)
+	roundtrip(t, data[len(Magic):], FormHdr)
+
+	rd := bytes.NewReader(data[len(Magic):])
+	if _, e := ReadHdr(rd); e != nil {
+		t.Fatal(e)
+	}
+	roundtrip(t, data[len(data)-rd.Len():], FormRaw)
+}
+
+func TestWriteOrder(t *testing.T) {
+	// plain maps are sorted, so they are written the same each time
+	m := map[string]interface{}{}
+	for _, k := range []string{"z", "b", "y", "a", "x", "c"} {
+		m[k] = map[string]interface{}{k + "2": int64(1), k + "1": int64(2)}
+	}
+
+	a, e := Marshal(m)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for i := 0; i < 10; i++ {
+		b, e := Marshal(m)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatalf("plain map written differently:\n%x\n%x", a, b)
+		}
+	}
+
+	r, e := Read(bytes.NewReader(a))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if keys := r.(*Object).Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c", "x", "y", "z"}) {
+		t.Fatalf("plain map keys %v", keys)
+	}
+
+	// an *Object keeps its order
+	o := NewObject()
+	o.Set("z", int64(1))
+	o.Set("a", int64(2))
+
+	b, e := Marshal(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	r, e = Read(bytes.NewReader(b))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if keys := r.(*Object).Keys(); !reflect.DeepEqual(keys, []string{"z", "a"}) {
+		t.Fatalf("object keys %v", keys)
+	}
+}
+
+func TestHdr(t *testing.T) {
+	for _, hdr := range []VerJsonHdr{
+		{Id: "PlayerEntity", Versioned: true, Version: 30},
+		{Id: "ItemDropEntity", Versioned: false},
+		{Id: "", Versioned: true, Version: -1},
+	} {
+		buf := &bytes.Buffer{}
+		if e := WriteHdr(buf, hdr); e != nil {
+			t.Fatal(e)
+		}
+
+		size := 1 + len(hdr.Id) + 1
+		if hdr.Versioned {
+			size += 4
+		}
+
+		if buf.Len() != size {
+			t.Fatalf("%+v: wrote %d bytes, expected %d", hdr, buf.Len(), size)
+		}
+
+		r, e := ReadHdr(buf)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if r != hdr {
+			t.Fatalf("read %+v, wrote %+v", r, hdr)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, e := ioutil.TempDir("", "sbvj01")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	data := fixture()
+	in := filepath.Join(dir, "in.player")
+	out := filepath.Join(dir, "out.player")
+
+	if e := ioutil.WriteFile(in, data, 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	vj, e := ReadFile(in, FormMagic)
+	if e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	if vj.Id != "PlayerEntity" || !vj.Versioned || vj.Version != 30 {
+		t.Fatalf("bad header %+v", vj.VerJsonHdr)
+	}
+
+	if e := WriteFile(out, vj, FormMagic); e != nil {
+		t.Fatal(e)
+	}
+
+	r, e := ioutil.ReadFile(out)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if !bytes.Equal(r, data) {
+		t.Fatal("written file differs")
+	}
+
+	if e := ioutil.WriteFile(in, append(data, 0), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e := ReadFile(in, FormMagic); e == nil {
+		t.Fatal("trailing bytes are not an error")
+	}
+}
+
+// TestPlayers roundtrips the .player files in testdata, real ones could be
+// put there too. unsorted.player is what fixture builds, members are not
+// sorted, so it is written back byte for byte only if the order is kept.
+func TestPlayers(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.player"))
+	if len(files) == 0 {
+		t.Skip("no testdata/*.player")
+	}
+
+	for _, v := range files {
+		data, e := ioutil.ReadFile(v)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		t.Run(filepath.Base(v), func(t *testing.T) {
+			roundtrip(t, data, FormMagic)
+		})
+	}
+}
+
+func TestObjectJSON(t *testing.T) {
+	in := `{"z":1,"a":{"y":[true,null],"b":"x"},"m":2.5}`
+
+	v, e := DecodeJSON(bytes.NewReader([]byte(in)))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	o, ok := v.(*Object)
+	if !ok {
+		t.Fatalf("decoded %T", v)
+	}
+
+	o.Set("z", int64(3))
+	o.Set("n", "new")
+	o.Delete("m")
+
+	r, e := json.Marshal(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if out := `{"z":3,"a":{"y":[true,null],"b":"x"},"n":"new"}`; string(r) != out {
+		t.Fatalf("got %s, expected %s", r, out)
+	}
+
+	buf := &bytes.Buffer{}
+	if e := Write(buf, v); e != nil {
+		t.Fatal(e)
+	}
+
+	w, e := Read(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	r2, e := json.Marshal(w)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if string(r2) != string(r) {
+		t.Fatalf("got %s after re-encoding, expected %s", r2, r)
+	}
+}
+
+func TestAnnotated(t *testing.T) {
+	data := fixture()
+
+	vj, e := ReadVersioned(bytes.NewReader(data), FormMagic)
+	if e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	r, e := json.Marshal(Annotate(vj.Content))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	doc, e := DecodeJSON(bytes.NewReader(r))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	vj.Content, e = Unannotate(doc)
+	if e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	buf := &bytes.Buffer{}
+	if e := WriteVersioned(buf, vj, FormMagic); e != nil {
+		t.Fatal(e)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("annotated json %s does not re-encode to the input", r)
+	}
+}
+
+func TestStream(t *testing.T) {
+	data := fixture()
+	body := data[len(Magic):]
+	rd := bytes.NewReader(body)
+	if _, e := ReadHdr(rd); e != nil {
+		t.Fatal(e)
+	}
+	body = body[len(body)-rd.Len():]
+
+	// copy token by token
+	d := NewDecoder(bytes.NewReader(body))
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	paths := []string{}
+	for {
+		tok, e := d.Token()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if e := enc.Token(tok, d.Len()); e != nil {
+			t.Fatal(e)
+		}
+
+		if _, ok := tok.(Key); ok {
+			paths = append(paths, d.Path())
+		}
+	}
+
+	if !bytes.Equal(buf.Bytes(), body) {
+		t.Fatal("token copy differs")
+	}
+
+	if paths[2] != "$.identity" || paths[3] != "$.identity.species" || paths[len(paths)-1] != `$.escaped["$double"]` {
+		t.Fatalf("bad paths %v", paths)
+	}
+
+	for path, expect := range map[string]interface{}{
+		"$.identity.name":  "Nova",
+		"$.inventory[0]":   int64(-300),
+		"$.position[1]":    float64(1),
+		"$.magic":          "____NaN____",
+		"$.uuid":           true,
+		"$.inventory[2]":   NewObject(),
+		"$.inventory[1]":   []interface{}{},
+		"$.position[0]":    1024.5,
+		"$.description":    "a été player",
+		"$.missing":        nil,
+		"$.inventory[3]":   nil,
+		"$.identity.name2": nil,
+	} {
+		d := NewDecoder(bytes.NewReader(body))
+		e := d.Seek(path)
+		if expect == nil {
+			if e == nil {
+				t.Fatalf("%s: found", path)
+			}
+			continue
+		}
+		if e != nil {
+			t.Fatalf("%s: %v", path, e)
+		}
+
+		v, e := d.Decode()
+		if e != nil {
+			t.Fatalf("%s: %v", path, e)
+		}
+
+		a, _ := json.Marshal(v)
+		b, _ := json.Marshal(expect)
+		if string(a) != string(b) {
+			t.Fatalf("%s: got %s, expected %s", path, a, b)
+		}
+	}
+
+	// rewrite one member, copy the rest
+	d = NewDecoder(bytes.NewReader(body))
+	buf.Reset()
+	enc = NewEncoder(buf)
+	if tok, e := d.Token(); e != nil || tok != Delim('{') {
+		t.Fatal(tok, e)
+	}
+	if e := enc.Begin('{', d.Len()); e != nil {
+		t.Fatal(e)
+	}
+	for d.More() {
+		k, e := d.Token()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := enc.Key(string(k.(Key))); e != nil {
+			t.Fatal(e)
+		}
+
+		if k == Key("modeType") {
+			if e := d.Skip(); e != nil {
+				t.Fatal(e)
+			}
+			e = enc.Encode("hardcore")
+		} else {
+			e = d.Copy(enc)
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := enc.End(); e != nil {
+		t.Fatal(e)
+	}
+
+	v, e := Read(bytes.NewReader(body))
+	if e != nil {
+		t.Fatal(e)
+	}
+	v.(*Object).Set("modeType", "hardcore")
+
+	expect := &bytes.Buffer{}
+	if e := Write(expect, v); e != nil {
+		t.Fatal(e)
+	}
+
+	if !bytes.Equal(buf.Bytes(), expect.Bytes()) {
+		t.Fatal("rewritten stream differs")
+	}
+}
+
+type bindColor [3]uint8
+
+func (c bindColor) MarshalSBVJ() (interface{}, error) {
+	return String(fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])), nil
+}
+
+func (c *bindColor) UnmarshalSBVJ(v interface{}) error {
+	var s string
+	switch n := v.(type) {
+	case String:
+		s = string(n)
+	case string:
+		s = n
+	default:
+		return fmt.Errorf("color is not a string")
+	}
+	_, e := fmt.Sscanf(s, "#%02x%02x%02x", &c[0], &c[1], &c[2])
+	return e
+}
+
+type bindIdentity struct {
+	Name    string    `sbvj:"name"`
+	Species String    `sbvj:"species"`
+	Color   bindColor `sbvj:"color"`
+}
+
+type bindBase struct {
+	Uuid UUID `sbvj:"uuid"`
+}
+
+type bindPlayer struct {
+	bindBase
+	Identity  *bindIdentity            `sbvj:"identity"`
+	Position  [2]float64               `sbvj:"position"`
+	Bags      [][]Varint               `sbvj:"bags"`
+	Stats     map[string]int32         `sbvj:"stats"`
+	Note      string                   `sbvj:"note,omitempty"`
+	Extra     interface{}              `sbvj:"extra"`
+	Skipped   int                      `sbvj:"-"`
+	Flags     map[string]*bindIdentity `sbvj:"flags,omitempty"`
+	Invisible bool
+}
+
+func TestBind(t *testing.T) {
+	p := bindPlayer{
+		bindBase: bindBase{Uuid: UUID{0xde, 0xad, 15: 1}},
+		Identity: &bindIdentity{Name: "Nova", Species: "human", Color: bindColor{1, 2, 255}},
+		Position: [2]float64{1024.5, math.Inf(1)},
+		Bags:     [][]Varint{{1, -2}, nil},
+		Stats:    map[string]int32{"b": 2, "a": -1},
+		Extra:    NewObject(),
+		Skipped:  7,
+	}
+
+	data, e := Marshal(p)
+	if e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	v, e := Read(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	r, e := json.Marshal(v)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	out := `{"uuid":"dead0000000000000000000000000001","identity":{"name":"Nova","species":"human","color":"#0102ff"},"position":[1024.5,"____+Inf____"],"bags":[[1,-2],null],"stats":{"a":-1,"b":2},"extra":{},"Invisible":false}`
+	if string(r) != out {
+		t.Fatalf("got %s, expected %s", r, out)
+	}
+
+	q := bindPlayer{Skipped: 7}
+	if e := Unmarshal(data, &q); e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	if !reflect.DeepEqual(p, q) {
+		t.Fatalf("got %+v, expected %+v", q, p)
+	}
+
+	// the json dumpsbvj01 writes binds too
+	doc, e := DecodeJSON(bytes.NewReader(r))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	q = bindPlayer{Skipped: 7}
+	if e := FromValue(doc, &q); e != nil {
+		t.Fatalf("%+v", e)
+	}
+
+	if !reflect.DeepEqual(p, q) {
+		t.Fatalf("got %+v from json, expected %+v", q, p)
+	}
+
+	for in, expect := range map[string]string{
+		`{"identity":{"name":3}}`:          "$.identity.name: can not unmarshal number into string",
+		`{"bags":[[1],[2.5]]}`:             "$.bags[1][0]: can not unmarshal number into data_types.Varint",
+		`{"stats":{"a b":4294967296}}`:     `$.stats["a b"]: 4294967296 overflows int32`,
+		`{"identity":{"color":"red"}}`:     "$.identity.color: input does not match format",
+		`{"uuid":"dead"}`:                  `$.uuid: "dead" is not a uuid`,
+		`{"position":[1]}`:                 "$.position: 1 elements, expect 2",
+		`{"flags":{"x":{"species":null}}}`: `$.flags.x.species: can not unmarshal null into data_types.String`,
+	} {
+		doc, e := DecodeJSON(bytes.NewReader([]byte(in)))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		e = FromValue(doc, &bindPlayer{})
+		if e == nil || e.Error() != expect {
+			t.Fatalf("%s: got error %v, expected %s", in, e, expect)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	data := fixture()
+
+	elem := bytes.Index(data, []byte("inventory\x06\x03")) + len("inventory\x06\x03")
+	bad := append([]byte{}, data...)
+	bad[elem] = 9
+
+	name := bytes.Index(data, []byte("Nova")) + 2
+
+	for _, c := range []struct {
+		data   []byte
+		form   Form
+		offset int64
+		path   string
+		err    string
+	}{
+		{bad, FormMagic, int64(elem), "$.inventory[0]", "unknown type 9"},
+		{data[:name], FormMagic, int64(name), "$.identity.name", io.ErrUnexpectedEOF.Error()},
+		{data[:len(Magic)+3], FormMagic, int64(len(Magic) + 3), "", "failed to read header: " + io.ErrUnexpectedEOF.Error()},
+		{[]byte("SBVJ00"), FormMagic, 0, "", `bad magic "SBVJ00"`},
+	} {
+		_, e := ReadVersioned(bytes.NewReader(c.data), c.form)
+
+		de, ok := e.(*DecodeError)
+		if !ok {
+			t.Fatalf("%s: got %T %v", c.path, e, e)
+		}
+
+		if de.Offset != c.offset || de.Path != c.path || de.Err.Error() != c.err {
+			t.Fatalf("got %+v, expected %d %s %s", de, c.offset, c.path, c.err)
+		}
+	}
+
+	// the streaming decoder reports the same
+	body := bytes.NewReader(bad[len(Magic):])
+	if _, e := ReadHdr(body); e != nil {
+		t.Fatal(e)
+	}
+	base := int64(len(bad) - body.Len())
+
+	d := NewDecoder(body)
+	if e := d.Seek("$.inventory"); e != nil {
+		t.Fatal(e)
+	}
+
+	_, e := d.Decode()
+	de, ok := e.(*DecodeError)
+	if !ok || de.Path != "$.inventory[0]" || base+de.Offset != int64(elem) {
+		t.Fatalf("got %v, expected $.inventory[0] at offset %d", e, int64(elem)-base)
+	}
+
+	if _, e := Read(bytes.NewReader(nil)); e != io.EOF {
+		t.Fatalf("got %v on empty input", e)
+	}
+
+	s := ErrorContext(fmt.Errorf("player: %w", e), bad, base)
+	if !bytes.Contains([]byte(s), []byte("[09]")) {
+		t.Fatalf("failing byte is not marked in\n%s", s)
+	}
+
+	// the offset is shown from the start of data, e is left as it was
+	if at := fmt.Sprintf("player: $.inventory[0] at offset %d: ", elem); !strings.HasPrefix(s, at) {
+		t.Fatalf("got %s, expected it to start with %s", s, at)
+	}
+
+	if base+de.Offset != int64(elem) || ErrorContext(e, bad, base) != strings.TrimPrefix(s, "player: ") {
+		t.Fatalf("error changed to %v", e)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	// a type byte, a length and a null
+	value := func(typ byte, n uint64) []byte {
+		buf := &bytes.Buffer{}
+		buf.WriteByte(typ)
+		byteorder.PutUVarint(buf, byteorder.BigEndian, n)
+		buf.WriteByte(NullT)
+		return buf.Bytes()
+	}
+
+	deep := bytes.Repeat([]byte{ArrayT, 1}, 100000)
+	long := value(StringT, 1<<40)
+	wide := value(ArrayT, 1<<30)
+
+	for name, data := range map[string][]byte{"deep": deep, "long": long, "wide": wide} {
+		_, e := Read(bytes.NewReader(data))
+		if !errors.Is(e, ErrLimit) {
+			t.Fatalf("%s: got %v", name, e)
+		}
+
+		d := NewDecoder(bytes.NewReader(data))
+		if e := d.Skip(); !errors.Is(e, ErrLimit) {
+			t.Fatalf("%s: skip got %v", name, e)
+		}
+	}
+
+	// a length with no data behind it is not allocated
+	short := value(StringT, 1<<22)
+	if _, e := Read(bytes.NewReader(short)); !errors.Is(e, io.ErrUnexpectedEOF) {
+		t.Fatalf("short: got %v", e)
+	}
+
+	if _, e := (Limits{MaxSize: 10}).ReadVersioned(bytes.NewReader(fixture()), FormMagic); !errors.Is(e, ErrLimit) {
+		t.Fatalf("size: got %v", e)
+	}
+
+	if _, e := (Limits{MaxString: 3}).ReadVersioned(bytes.NewReader(fixture()), FormMagic); !errors.Is(e, ErrLimit) {
+		t.Fatalf("string: got %v", e)
+	}
+
+	if _, e := (Limits{}).ReadVersioned(bytes.NewReader(fixture()), FormMagic); e != nil {
+		t.Fatalf("no limits: got %v", e)
+	}
+
+	b := ByteArray{}
+	if e := b.Read(bytes.NewReader(long[1:]), byteorder.BigEndian); !errors.Is(e, ErrTooLong) {
+		t.Fatalf("byte array: got %v", e)
+	}
+
+	if _, e := b.ReadBuf([]byte{5, 'a'}, byteorder.BigEndian); e == nil {
+		t.Fatal("byte array: short buffer is not an error")
+	}
+
+	// MaxString is the limit in use, MaxByteArray only its default
+	defer func(max uint64) { MaxByteArray = max }(MaxByteArray)
+	MaxByteArray = 4
+
+	str := []byte{StringT, 6, 'a', 'b', 'c', 'd', 'e', 'f'}
+	if _, e := (Limits{MaxString: 8}).Read(bytes.NewReader(str)); e != nil {
+		t.Fatalf("string within MaxString: got %v", e)
+	}
+
+	if _, e := (Limits{}).Read(bytes.NewReader(str)); !errors.Is(e, ErrLimit) {
+		t.Fatalf("string over MaxByteArray: got %v", e)
+	}
+
+	if _, e := ReadByteArray(bytes.NewReader(str[1:]), byteorder.BigEndian, 8); e != nil {
+		t.Fatalf("byte array within max: got %v", e)
+	}
+
+	if _, e := ReadByteArray(bytes.NewReader(str[1:]), byteorder.BigEndian, 0); !errors.Is(e, ErrTooLong) {
+		t.Fatalf("byte array over MaxByteArray: got %v", e)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	vj, e := ReadVersioned(bytes.NewReader(fixture()), FormMagic)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := MarshalCanonical(vj.Content)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// the same document from json, with keys in another order and numbers
+	// as float64
+	data, e := json.Marshal(vj.Content)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	doc := map[string]interface{}{}
+	if e := json.Unmarshal(data, &doc); e != nil {
+		t.Fatal(e)
+	}
+
+	// plain json can not tell this string from a NaN
+	doc["magic"] = String("____NaN____")
+
+	b, e := MarshalCanonical(doc)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if !bytes.Equal(a, b) {
+		t.Fatalf("canonical forms differ:\n%x\n%x", a, b)
+	}
+
+	// canonical form is a fixed point
+	c, e := Read(bytes.NewReader(a))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	d, e := MarshalCanonical(c)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if !bytes.Equal(a, d) {
+		t.Fatalf("canonical form is not stable:\n%x\n%x", a, d)
+	}
+
+	// keys are sorted
+	keys := c.(*Object).Keys()
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] > keys[i] {
+			t.Fatalf("keys are not sorted: %v", keys)
+		}
+	}
+
+	x := NewObject()
+	x.Set("b", []interface{}{int64(1), 2.5, math.NaN()})
+	x.Set("a", Varint(-3))
+
+	y := map[string]interface{}{
+		"a": -3.0,
+		"b": []interface{}{json.Number("1.0"), NonFinite(2.5), "____NaN____"},
+	}
+
+	if !Equal(x, y) {
+		t.Fatal("x and y are not equal")
+	}
+
+	hx, e := Hash(x)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	hy, e := Hash(y)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if hx != hy {
+		t.Fatal("equal values have different hashes")
+	}
+
+	for i, v := range []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{int64(1), 1.0, true},
+		{0.0, math.Copysign(0, -1), true},
+		{1.5, int64(1), false},
+		{int64(1), true, false},
+		{"1", int64(1), false},
+		{String("a"), "a", true},
+		{float64(1 << 62), int64(1 << 62), true},
+		{math.Inf(1), "____+Inf____", true},
+		{[]interface{}{int64(1)}, []interface{}{int64(1), nil}, false},
+		{map[string]interface{}{"a": nil}, map[string]interface{}{}, false},
+		{struct{}{}, struct{}{}, false},
+	} {
+		if Equal(v.a, v.b) != v.equal {
+			t.Fatalf("%d: Equal(%v, %v) is not %v", i, v.a, v.b, v.equal)
+		}
+	}
+
+	// integers are written as the shortest varint, others as doubles
+	for _, v := range []struct {
+		v    interface{}
+		data []byte
+	}{
+		{2.0, []byte{VarintT, 4}},
+		{-1.0, []byte{VarintT, 1}},
+		{0.5, []byte{NumberT, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0}},
+		{1e300, []byte{NumberT, 0x7e, 0x37, 0xe4, 0x3c, 0x88, 0, 0x75, 0x9c}},
+	} {
+		r, e := MarshalCanonical(v.v)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if !bytes.Equal(r, v.data) {
+			t.Fatalf("%v: got %x, expect %x", v.v, r, v.data)
+		}
+	}
+}
+
+func TestUTF8Policy(t *testing.T) {
+	o := NewObject()
+	o.Set("k\xff", "a\xc0b")
+	o.Set("ok", "é")
+
+	data, e := Marshal(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, v := range []struct {
+		policy UTF8Policy
+		keys   []string
+		value  String
+	}{
+		{UTF8Accept, []string{"k\xff", "ok"}, "a\xc0b"},
+		{UTF8Replace, []string{"k�", "ok"}, "a�b"},
+	} {
+		r, e := Limits{Strings: v.policy}.Read(bytes.NewReader(data))
+		if e != nil {
+			t.Fatalf("%v: %v", v.policy, e)
+		}
+
+		keys := r.(*Object).Keys()
+		if !reflect.DeepEqual(keys, v.keys) {
+			t.Fatalf("%v: keys %q, expect %q", v.policy, keys, v.keys)
+		}
+
+		if w, _ := r.(*Object).Get(keys[0]); w != v.value {
+			t.Fatalf("%v: value %q, expect %q", v.policy, w, v.value)
+		}
+	}
+
+	reject := Limits{Strings: UTF8Reject}
+
+	_, e = reject.Read(bytes.NewReader(data))
+	if !errors.Is(e, ErrInvalidUTF8) {
+		t.Fatalf("reject read: got %v", e)
+	}
+
+	var de *DecodeError
+	if !errors.As(e, &de) || de.Path != "$" || de.Offset != 5 {
+		t.Fatalf("reject read: got %#v", e)
+	}
+
+	if e := reject.Write(&bytes.Buffer{}, o); !errors.Is(e, ErrInvalidUTF8) {
+		t.Fatalf("reject write: got %v", e)
+	}
+
+	if e := reject.Write(&bytes.Buffer{}, map[string]interface{}{"ok": "é"}); e != nil {
+		t.Fatalf("reject valid: got %v", e)
+	}
+
+	// a key written by the encoder
+	enc := reject.NewEncoder(&bytes.Buffer{})
+	if e := enc.Begin('{', 1); e != nil {
+		t.Fatal(e)
+	}
+	if e := enc.Key("k\xff"); !errors.Is(e, ErrInvalidUTF8) {
+		t.Fatalf("reject key: got %v", e)
+	}
+
+	// the default limits are not changed by the others
+	if _, e := Read(bytes.NewReader(data)); e != nil {
+		t.Fatalf("default read: got %v", e)
+	}
+}
+
+func TestList(t *testing.T) {
+	l := List{}
+	for i := 0; i < 130; i++ {
+		vj := &VersionedJson{
+			VerJsonHdr: VerJsonHdr{Id: String(fmt.Sprintf("x%d", i))},
+			Content:    []interface{}{int64(i), 1.5},
+		}
+		// the version is only written if versioned
+		if i%2 == 0 {
+			vj.Versioned, vj.Version = true, int32(i)
+		}
+		l = append(l, vj)
+	}
+
+	buf := &bytes.Buffer{}
+	if e := l.Write(buf); e != nil {
+		t.Fatal(e)
+	}
+
+	// 130 is two bytes as UVarint
+	if data := buf.Bytes(); data[0] != 0x81 || data[1] != 0x02 {
+		t.Fatalf("count % x", data[:2])
+	}
+
+	r, e := ReadList(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if !reflect.DeepEqual(r, l) {
+		t.Fatal("read list differs")
+	}
+
+	var d List
+	if e := d.SetDocument(l.Document()); e != nil {
+		t.Fatal(e)
+	}
+
+	if !reflect.DeepEqual(d, l) {
+		t.Fatal("document differs")
+	}
+
+	// a bad type in the body of the second
+	data := []byte{2, 1, 'a', 0, 1, 1, 'b', 0, 9}
+	_, e = ReadList(bytes.NewReader(data))
+
+	var de *DecodeError
+	if !errors.As(e, &de) || de.Path != "[1]$" || de.Offset != 8 {
+		t.Fatalf("got %v", e)
+	}
+}
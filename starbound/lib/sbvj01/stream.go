@@ -0,0 +1,547 @@
+package sbvj01
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	. "github.com/xhebox/sbutils/lib/data_types"
+)
+
+// Delim is one of '[', ']', '{', '}'.
+type Delim rune
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+// Key is an object member key, to tell it from a String value.
+type Key string
+
+// Token is Delim, Key, nil, bool, float64, NonFinite, int64 or String.
+type Token interface{}
+
+type frame struct {
+	delim Delim
+	len   uint64
+	done  uint64
+	// for objects, true between a key and its value
+	value bool
+	key   string
+}
+
+// Decoder reads a stream of values token by token, without building them.
+// Values may follow each other at the top level, other data between them
+// could be read from the underlying reader, as Decoder does not buffer. Wrap
+// rd with bufio if it is slow.
+type Decoder struct {
+	rd    *counter
+	stack []frame
+	// path of the last token
+	path string
+}
+
+// NewDecoder makes a Decoder within DefaultLimits.
+func NewDecoder(rd io.Reader) *Decoder {
+	return DefaultLimits.NewDecoder(rd)
+}
+
+// NewDecoder is NewDecoder with these limits.
+func (l Limits) NewDecoder(rd io.Reader) *Decoder {
+	return &Decoder{rd: &counter{rd: rd, lim: l}}
+}
+
+// Offset is the number of bytes read so far.
+func (d *Decoder) Offset() int64 {
+	return d.rd.off
+}
+
+func pathKey(k string) string {
+	for i, c := range k {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || (i > 0 && c >= '0' && c <= '9')) {
+			return "[" + strconv.Quote(k) + "]"
+		}
+	}
+	if k == "" {
+		return `[""]`
+	}
+	return "." + k
+}
+
+func pathOf(stack []frame) string {
+	r := "$"
+	for _, f := range stack {
+		switch f.delim {
+		case '[':
+			if f.done > 0 {
+				r += fmt.Sprintf("[%d]", f.done-1)
+			}
+		case '{':
+			if f.value || f.done > 0 {
+				r += pathKey(f.key)
+			}
+		}
+	}
+	return r
+}
+
+// current returns the path of the element being read.
+func (d *Decoder) current() string {
+	return pathOf(d.stack)
+}
+
+// Path returns the path of the last token, like $.inventory.bags[3].
+func (d *Decoder) Path() string {
+	return d.path
+}
+
+// More reports whether the current array or object has more elements.
+func (d *Decoder) More() bool {
+	if len(d.stack) == 0 {
+		return true
+	}
+
+	f := &d.stack[len(d.stack)-1]
+	return f.value || f.done < f.len
+}
+
+// Len returns the number of elements of the current array or object.
+func (d *Decoder) Len() int {
+	if len(d.stack) == 0 {
+		return 0
+	}
+	return int(d.stack[len(d.stack)-1].len)
+}
+
+// next is called before reading a value, it returns a Key or closing Delim
+// if those come first.
+func (d *Decoder) next() (Token, bool, error) {
+	if len(d.stack) == 0 {
+		d.path = "$"
+		return nil, false, nil
+	}
+
+	f := &d.stack[len(d.stack)-1]
+	if f.value {
+		f.value = false
+		return nil, false, nil
+	}
+
+	if f.done == f.len {
+		d.stack = d.stack[:len(d.stack)-1]
+		d.rd.pop()
+		d.path = d.current()
+		if f.delim == '[' {
+			return Delim(']'), true, nil
+		}
+		return Delim('}'), true, nil
+	}
+
+	f.done++
+
+	if f.delim == '{' {
+		k, e := d.rd.readString()
+		if e != nil {
+			return nil, true, d.fail(errors.Wrapf(unexpected(e), "key of member %d", f.done-1))
+		}
+
+		f.key = string(k)
+		f.value = true
+		d.path = d.current()
+		return Key(k), true, nil
+	}
+
+	d.path = d.current()
+	return nil, false, nil
+}
+
+// fail makes a DecodeError at the current offset and path.
+func (d *Decoder) fail(e error) error {
+	return &DecodeError{Offset: d.rd.off, Path: d.current(), Err: unexpected(e)}
+}
+
+// Token returns the next token, io.EOF at the end of the stream. Other
+// errors are DecodeError.
+func (d *Decoder) Token() (Token, error) {
+	t, ok, e := d.next()
+	if ok || e != nil {
+		return t, e
+	}
+
+	typ, e := byteorder.Uint8(d.rd)
+	if e == io.EOF && len(d.stack) == 0 {
+		return nil, e
+	}
+	if e != nil {
+		return nil, d.fail(e)
+	}
+
+	switch typ {
+	case ArrayT, ObjectT:
+		n, e := byteorder.UVarint(d.rd, byteorder.BigEndian)
+		if e != nil {
+			return nil, d.fail(e)
+		}
+
+		if e := d.rd.push(n); e != nil {
+			return nil, d.fail(e)
+		}
+
+		if typ == ArrayT {
+			d.stack = append(d.stack, frame{delim: '[', len: n})
+			return Delim('['), nil
+		}
+
+		d.stack = append(d.stack, frame{delim: '{', len: n})
+		return Delim('{'), nil
+	default:
+		if typ < NullT || typ > ObjectT {
+			e := d.fail(errors.Errorf("unknown type %d", typ))
+			e.(*DecodeError).Offset--
+			return nil, e
+		}
+
+		r, e := d.rd.readScalar(typ)
+		if e != nil {
+			return nil, d.fail(e)
+		}
+		return r, nil
+	}
+}
+
+func (c *counter) readScalar(typ byte) (interface{}, error) {
+	switch typ {
+	case NullT:
+		return nil, nil
+	case NumberT:
+		r, e := byteorder.Float64(c, byteorder.BigEndian)
+		if math.IsNaN(r) || math.IsInf(r, 0) {
+			return NonFinite(r), e
+		}
+		return r, e
+	case BoolT:
+		return byteorder.Bool(c)
+	case VarintT:
+		return byteorder.Varint(c, byteorder.BigEndian)
+	case StringT:
+		return c.readString()
+	default:
+		return nil, errors.Errorf("unknown type %d", typ)
+	}
+}
+
+// Seek advances to the value at path, like $.identity.name or $.bags[3],
+// so that the next Decode, Skip or Copy reads it. Everything before it is
+// skipped, it could only move forward.
+func (d *Decoder) Seek(path string) error {
+	under := func(p string) bool {
+		return len(path) > len(p) && path[:len(p)] == p && (path[len(p)] == '.' || path[len(p)] == '[')
+	}
+
+	for {
+		var next string
+		if len(d.stack) == 0 {
+			next = "$"
+		} else {
+			f := &d.stack[len(d.stack)-1]
+			switch {
+			case f.value:
+				next = d.path
+			case f.done == f.len:
+				return errors.Errorf("%s is not found", path)
+			case f.delim == '{':
+				if _, e := d.Token(); e != nil {
+					return e
+				}
+				continue
+			default:
+				next = pathOf(d.stack[:len(d.stack)-1]) + fmt.Sprintf("[%d]", f.done)
+			}
+		}
+
+		switch {
+		case next == path:
+			return nil
+		case under(next):
+			t, e := d.Token()
+			if e != nil {
+				return e
+			}
+
+			if t != Delim('[') && t != Delim('{') {
+				return errors.Errorf("%s is not found, %s is not an array or object", path, next)
+			}
+		default:
+			if len(d.stack) == 0 {
+				return errors.Errorf("%s is not found", path)
+			}
+
+			if e := d.Skip(); e != nil {
+				return e
+			}
+		}
+	}
+}
+
+// Decode reads the next value as Read does. After a Key, it is the member
+// value.
+func (d *Decoder) Decode() (interface{}, error) {
+	t, ok, e := d.next()
+	if e != nil {
+		return nil, e
+	}
+
+	if ok {
+		return nil, errors.Errorf("expect a value, got %v", t)
+	}
+
+	start := d.rd.off
+	r, e := read(d.rd)
+	if e != nil {
+		if len(d.stack) == 0 && d.rd.off == start && errors.Is(e, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, rebase(e, 0, d.current())
+	}
+
+	return r, nil
+}
+
+// Skip discards the next value without decoding it. After a Key, it is the
+// member value.
+func (d *Decoder) Skip() error {
+	t, ok, e := d.next()
+	if e != nil {
+		return e
+	}
+
+	if ok {
+		return errors.Errorf("expect a value, got %v", t)
+	}
+
+	if e := d.rd.skip(d.rd); e != nil {
+		return d.fail(e)
+	}
+	return nil
+}
+
+// Copy copies the next value to e as is.
+func (d *Decoder) Copy(enc *Encoder) error {
+	t, ok, e := d.next()
+	if e != nil {
+		return e
+	}
+
+	if ok {
+		return errors.Errorf("expect a value, got %v", t)
+	}
+
+	if e := enc.value(); e != nil {
+		return e
+	}
+
+	if e := d.rd.skip(io.TeeReader(d.rd, enc.wt)); e != nil {
+		return d.fail(e)
+	}
+	return nil
+}
+
+// skip reads a value from rd, which is c or reads from c, within the limits
+// of c.
+func (c *counter) skip(rd io.Reader) error {
+	typ, e := byteorder.Uint8(rd)
+	if e != nil {
+		return e
+	}
+
+	str := func() error {
+		n, e := byteorder.UVarint(rd, byteorder.BigEndian)
+		if e != nil {
+			return e
+		}
+
+		if max := c.lim.maxString(); n > max {
+			return errors.Wrapf(ErrLimit, "string of %d bytes, max %d", n, max)
+		}
+
+		if _, e := io.CopyN(ioutil.Discard, rd, int64(n)); e != nil {
+			return e
+		}
+		return nil
+	}
+
+	switch typ {
+	case NullT:
+		return nil
+	case NumberT:
+		_, e = io.CopyN(ioutil.Discard, rd, 8)
+	case BoolT:
+		_, e = io.CopyN(ioutil.Discard, rd, 1)
+	case VarintT:
+		_, e = byteorder.UVarint(rd, byteorder.BigEndian)
+	case StringT:
+		e = str()
+	case ArrayT, ObjectT:
+		var n uint64
+		n, e = byteorder.UVarint(rd, byteorder.BigEndian)
+		if e == nil {
+			e = c.push(n)
+		}
+		if e != nil {
+			break
+		}
+
+		for i := uint64(0); e == nil && i < n; i++ {
+			if typ == ObjectT {
+				if e = str(); e != nil {
+					break
+				}
+			}
+			e = c.skip(rd)
+		}
+		c.pop()
+	default:
+		return errors.Errorf("unknown type %d", typ)
+	}
+
+	if e == io.EOF {
+		e = io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+// Encoder writes values piece by piece. Since sbvj01 stores the length of
+// arrays and objects first, it must be given to Begin and is checked by End.
+type Encoder struct {
+	wt    io.Writer
+	lim   Limits
+	stack []frame
+}
+
+func NewEncoder(wt io.Writer) *Encoder {
+	return DefaultLimits.NewEncoder(wt)
+}
+
+// NewEncoder is NewEncoder with the Strings policy of these limits.
+func (l Limits) NewEncoder(wt io.Writer) *Encoder {
+	return &Encoder{wt: wt, lim: l}
+}
+
+// value is called before writing a value.
+func (e *Encoder) value() error {
+	if len(e.stack) == 0 {
+		return nil
+	}
+
+	f := &e.stack[len(e.stack)-1]
+	switch {
+	case f.delim == '{' && !f.value:
+		return errors.New("expect a key")
+	case f.delim == '{':
+		f.value = false
+	case f.done == f.len:
+		return errors.Errorf("array has only %d elements", f.len)
+	default:
+		f.done++
+	}
+
+	return nil
+}
+
+// Begin starts an array or object of n elements, d is '[' or '{'.
+func (e *Encoder) Begin(d Delim, n int) error {
+	if d != '[' && d != '{' {
+		return errors.Errorf("can not begin %v", d)
+	}
+
+	if err := e.value(); err != nil {
+		return err
+	}
+
+	typ := ArrayT
+	if d == '{' {
+		typ = ObjectT
+	}
+
+	if err := byteorder.PutUint8(e.wt, typ); err != nil {
+		return err
+	}
+
+	if err := byteorder.PutUVarint(e.wt, byteorder.BigEndian, uint64(n)); err != nil {
+		return err
+	}
+
+	e.stack = append(e.stack, frame{delim: d, len: uint64(n)})
+	return nil
+}
+
+func (e *Encoder) Key(k string) error {
+	if len(e.stack) == 0 || e.stack[len(e.stack)-1].delim != '{' {
+		return errors.New("key outside of an object")
+	}
+
+	f := &e.stack[len(e.stack)-1]
+	if f.value {
+		return errors.Errorf("key %q has no value", f.key)
+	}
+
+	if f.done == f.len {
+		return errors.Errorf("object has only %d members", f.len)
+	}
+
+	f.done++
+	f.value = true
+	f.key = k
+
+	return e.lim.Strings.WriteString(e.wt, byteorder.BigEndian, String(k))
+}
+
+// End finishes the current array or object.
+func (e *Encoder) End() error {
+	if len(e.stack) == 0 {
+		return errors.New("nothing to end")
+	}
+
+	f := e.stack[len(e.stack)-1]
+	if f.value {
+		return errors.Errorf("key %q has no value", f.key)
+	}
+
+	if f.done != f.len {
+		return errors.Errorf("%d elements written, expect %d", f.done, f.len)
+	}
+
+	e.stack = e.stack[:len(e.stack)-1]
+	return nil
+}
+
+// Encode writes a whole value as Write does.
+func (e *Encoder) Encode(v interface{}) error {
+	if err := e.value(); err != nil {
+		return err
+	}
+
+	return e.lim.Write(e.wt, v)
+}
+
+// Token writes a token returned by Decoder.Token, n is the length for '['
+// and '{', as Decoder.Len returns.
+func (e *Encoder) Token(t Token, n int) error {
+	switch v := t.(type) {
+	case Delim:
+		switch v {
+		case '[', '{':
+			return e.Begin(v, n)
+		default:
+			return e.End()
+		}
+	case Key:
+		return e.Key(string(v))
+	default:
+		return e.Encode(v)
+	}
+}
@@ -0,0 +1,120 @@
+// Package starjson does what the game does to asset json: jsonMerge, the
+// conversion of lua tables done by sb.jsonMerge, and .patch files.
+//
+// Documents are those of sbvj01 and assetjson, objects are *sbvj01.Object or
+// map[string]interface{}.
+package starjson
+
+import (
+	"strconv"
+
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// Merge is jsonMerge of the game: members of merger are merged into a copy
+// of base, recursively if both are objects. A null merger keeps base, any
+// other value replaces it, arrays included.
+//
+// Unlike a RFC 7396 merge patch, null does not remove a member.
+func Merge(base, merger interface{}) interface{} {
+	if merger == nil {
+		return jsonpatch.Clone(base)
+	}
+
+	m, ok := sbvj01.Members(merger)
+	if !ok {
+		return jsonpatch.Clone(merger)
+	}
+
+	if _, ok := sbvj01.Members(base); !ok {
+		return jsonpatch.Clone(merger)
+	}
+
+	r := jsonpatch.Clone(base)
+	for _, k := range sbvj01.Keys(merger) {
+		switch n := r.(type) {
+		case *sbvj01.Object:
+			v, _ := n.Get(k)
+			n.Set(k, Merge(v, m[k]))
+		case map[string]interface{}:
+			n[k] = Merge(n[k], m[k])
+		}
+	}
+
+	return r
+}
+
+// MaxLuaIndex bounds the keys of objects LuaTable turns into arrays.
+var MaxLuaIndex int64 = 1 << 20
+
+// luaIndex is the index of a key written as lua writes integers, so "01" and
+// "+1" are not 1.
+func luaIndex(k string) (int64, bool) {
+	i, e := strconv.ParseInt(k, 10, 64)
+	if e != nil || i <= 0 || i > MaxLuaIndex || strconv.FormatInt(i, 10) != k {
+		return 0, false
+	}
+	return i, true
+}
+
+// LuaTable converts doc as the game converts a lua table without a type
+// hint: a non empty object whose keys are all positive integers, like
+// {"1": a, "2": b}, is an array. It is as long as the largest key, missing
+// indexes are null. Other objects stay objects, and so do objects with a
+// key over MaxLuaIndex, to not allocate huge arrays, or with a key like "01"
+// that is not how lua writes an integer.
+//
+// This is the quirk of sb.jsonMerge that sbmeta works around by renaming the
+// key "1" before the merge.
+func LuaTable(doc interface{}) interface{} {
+	switch n := doc.(type) {
+	case []interface{}:
+		r := make([]interface{}, len(n))
+		for k := range n {
+			r[k] = LuaTable(n[k])
+		}
+		return r
+	}
+
+	m, ok := sbvj01.Members(doc)
+	if !ok {
+		return doc
+	}
+
+	ks := sbvj01.Keys(doc)
+
+	max := int64(0)
+	for _, k := range ks {
+		i, ok := luaIndex(k)
+		if !ok {
+			max = 0
+			break
+		}
+
+		if i > max {
+			max = i
+		}
+	}
+
+	if max > 0 {
+		r := make([]interface{}, max)
+		for _, k := range ks {
+			i, _ := luaIndex(k)
+			r[i-1] = LuaTable(m[k])
+		}
+		return r
+	}
+
+	r := sbvj01.NewObject()
+	for _, k := range ks {
+		r.Set(k, LuaTable(m[k]))
+	}
+	return r
+}
+
+// LuaMerge is sb.jsonMerge called on lua tables without type hints, that is
+// Merge of their LuaTable conversions.
+func LuaMerge(base, merger interface{}) interface{} {
+	return Merge(LuaTable(base), LuaTable(merger))
+}
@@ -0,0 +1,205 @@
+package starjson
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// ErrTestFailed is wrapped by failed test operations. A failed test skips
+// the rest of its patch set, but is not an error of the patch.
+var ErrTestFailed = errors.New("test failed")
+
+// Op is an operation of a .patch file. Tests may have no value, to test
+// whether the path exists, and may be inverted.
+type Op struct {
+	jsonpatch.Operation
+	HasValue bool
+	Inverse  bool
+}
+
+// Patch is a .patch file: either a json object merged by Merge, or sets of
+// operations applied in order. A file of operations is one set, a file of
+// arrays of operations has one set per array.
+type Patch struct {
+	Merge interface{}
+	Sets  [][]Op
+}
+
+// ParsePatch reads a patch from a document, as assetjson.Parse returns.
+func ParsePatch(doc interface{}) (*Patch, error) {
+	if _, ok := sbvj01.Members(doc); ok {
+		return &Patch{Merge: doc}, nil
+	}
+
+	l, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("patch is a %T, not an object or an array", doc)
+	}
+
+	r := &Patch{}
+	if len(l) == 0 {
+		return r, nil
+	}
+
+	if _, ok := l[0].([]interface{}); !ok {
+		l = []interface{}{l}
+	}
+
+	for i := range l {
+		ops, ok := l[i].([]interface{})
+		if !ok {
+			return nil, errors.Errorf("set %d is a %T, not an array", i, l[i])
+		}
+
+		set := make([]Op, len(ops))
+		for j := range ops {
+			op, e := parseOp(ops[j])
+			if e != nil {
+				return nil, errors.Wrapf(e, "set %d operation %d", i, j)
+			}
+			set[j] = op
+		}
+
+		r.Sets = append(r.Sets, set)
+	}
+
+	return r, nil
+}
+
+func parseOp(doc interface{}) (Op, error) {
+	m, ok := sbvj01.Members(doc)
+	if !ok {
+		return Op{}, errors.Errorf("operation is a %T, not an object", doc)
+	}
+
+	str := func(k string, required bool) (string, error) {
+		v, ok := m[k]
+		if !ok {
+			if required {
+				return "", errors.Errorf("no %q", k)
+			}
+			return "", nil
+		}
+
+		switch n := v.(type) {
+		case string:
+			return n, nil
+		case data_types.String:
+			return string(n), nil
+		default:
+			return "", errors.Errorf("%q is a %T, not a string", k, v)
+		}
+	}
+
+	var r Op
+	var e error
+
+	if r.Op, e = str("op", true); e != nil {
+		return r, e
+	}
+
+	if r.Path, e = str("path", true); e != nil {
+		return r, e
+	}
+
+	switch r.Op {
+	case "add", "replace":
+		if _, ok := m["value"]; !ok {
+			return r, errors.Errorf("%s has no value", r.Op)
+		}
+	case "move", "copy":
+		if r.From, e = str("from", true); e != nil {
+			return r, e
+		}
+	case "remove", "test":
+	default:
+		return r, errors.Errorf("unknown op %q", r.Op)
+	}
+
+	r.Value, r.HasValue = m["value"]
+
+	if v, ok := m["inverse"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return r, errors.Errorf(`"inverse" is a %T, not a bool`, v)
+		}
+		r.Inverse = b
+	}
+
+	return r, nil
+}
+
+// Apply applies the operation to doc, which may be modified.
+func (o Op) Apply(doc interface{}) (interface{}, error) {
+	if o.Op != "test" {
+		o.Operation.Value = jsonpatch.Clone(o.Operation.Value)
+		return o.Operation.Apply(doc)
+	}
+
+	path, e := jsonpatch.ParsePointer(o.Path)
+	if e != nil {
+		return nil, e
+	}
+
+	v, e := jsonpatch.Get(doc, path)
+	if e != nil {
+		if o.Inverse {
+			return doc, nil
+		}
+		return nil, errors.Wrapf(ErrTestFailed, "%v", e)
+	}
+
+	if o.HasValue && !jsonpatch.Equal(v, o.Value) {
+		if o.Inverse {
+			return doc, nil
+		}
+		return nil, errors.Wrapf(ErrTestFailed, "%s is not the value", o.Path)
+	}
+
+	if o.Inverse {
+		if o.HasValue {
+			return nil, errors.Wrapf(ErrTestFailed, "%s is the value", o.Path)
+		}
+		return nil, errors.Wrapf(ErrTestFailed, "%s exists", o.Path)
+	}
+
+	return doc, nil
+}
+
+// Apply returns a patched copy of doc. Sets are applied in order, a set that
+// fails a test is skipped as a whole, the failures are returned by set index.
+// Any other error fails the patch.
+func (p *Patch) Apply(doc interface{}) (interface{}, map[int]error, error) {
+	if p.Merge != nil {
+		return Merge(doc, p.Merge), nil, nil
+	}
+
+	doc = jsonpatch.Clone(doc)
+	skipped := map[int]error{}
+
+	for i, set := range p.Sets {
+		r := jsonpatch.Clone(doc)
+
+		var e error
+		for j := range set {
+			r, e = set[j].Apply(r)
+			if e != nil {
+				e = errors.Wrapf(e, "set %d operation %d(%s %s)", i, j, set[j].Op, set[j].Path)
+				break
+			}
+		}
+
+		switch {
+		case e == nil:
+			doc = r
+		case errors.Is(e, ErrTestFailed):
+			skipped[i] = e
+		default:
+			return nil, nil, e
+		}
+	}
+
+	return doc, skipped, nil
+}
@@ -0,0 +1,172 @@
+package starjson
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+)
+
+func parse(t *testing.T, s string) interface{} {
+	t.Helper()
+
+	r, e := assetjson.Parse([]byte(s))
+	if e != nil {
+		t.Fatalf("%s: %v", s, e)
+	}
+	return r
+}
+
+func expect(t *testing.T, name string, got interface{}, want string) {
+	t.Helper()
+
+	if w := parse(t, want); !jsonpatch.Equal(got, w) {
+		t.Fatalf("%s: got %v, expect %s", name, got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := parse(t, `{"a": 1, "b": {"c": [1, 2], "d": "x"}, "e": true}`)
+
+	r := Merge(base, parse(t, `{"b": {"c": [3], "f": null}, "e": null, "g": {"h": 1}}`))
+	expect(t, "merge", r, `{"a": 1, "b": {"c": [3], "d": "x", "f": null}, "e": true, "g": {"h": 1}}`)
+	expect(t, "base", base, `{"a": 1, "b": {"c": [1, 2], "d": "x"}, "e": true}`)
+
+	expect(t, "null", Merge(base, nil), `{"a": 1, "b": {"c": [1, 2], "d": "x"}, "e": true}`)
+	expect(t, "scalar", Merge(base, parse(t, `3`)), `3`)
+	expect(t, "into scalar", Merge(parse(t, `[1]`), parse(t, `{"a": 1}`)), `{"a": 1}`)
+
+	// plain maps merge like objects
+	m := map[string]interface{}{"a": map[string]interface{}{"b": 1.0}}
+	expect(t, "map", Merge(m, parse(t, `{"a": {"c": 2}}`)), `{"a": {"b": 1, "c": 2}}`)
+}
+
+func TestLuaTable(t *testing.T) {
+	for _, v := range []struct {
+		in, out string
+	}{
+		// the quirk sbmeta works around
+		{`{"1": "a", "2": "b"}`, `["a", "b"]`},
+		{`{"2": "b", "1": "a"}`, `["a", "b"]`},
+		{`{"1": "a", "3": "c"}`, `["a", null, "c"]`},
+		{`{"x": {"1": {"2": true}}}`, `{"x": [[null, true]]}`},
+		{`[{"1": 0}]`, `[[0]]`},
+		// what sbmeta renames "1" to keeps the table an object
+		{`{"__sbmeta1": "a", "2": "b"}`, `{"__sbmeta1": "a", "2": "b"}`},
+		{`{"0": "a", "1": "b"}`, `{"0": "a", "1": "b"}`},
+		{`{"-1": "a"}`, `{"-1": "a"}`},
+		{`{"1.5": "a"}`, `{"1.5": "a"}`},
+		// only keys lua writes are indexes, "01" does not replace "1"
+		{`{"1": "a", "01": "b"}`, `{"1": "a", "01": "b"}`},
+		{`{"+1": "a"}`, `{"+1": "a"}`},
+		{`{"2": "a", "1 ": "b"}`, `{"2": "a", "1 ": "b"}`},
+		{`{"99999999999": "a"}`, `{"99999999999": "a"}`},
+		{`{}`, `{}`},
+	} {
+		expect(t, v.in, LuaTable(parse(t, v.in)), v.out)
+	}
+
+	base := parse(t, `{"parameters": {"levels": {"1": 10, "2": 20}}}`)
+	merger := parse(t, `{"parameters": {"levels": {"3": 30}}}`)
+
+	// the game merges the objects, lua tables lose their keys
+	expect(t, "merge", Merge(base, merger), `{"parameters": {"levels": {"1": 10, "2": 20, "3": 30}}}`)
+	expect(t, "lua merge", LuaMerge(base, merger), `{"parameters": {"levels": [null, null, 30]}}`)
+}
+
+func apply(t *testing.T, doc, patch string) (interface{}, map[int]error, error) {
+	t.Helper()
+
+	p, e := ParsePatch(parse(t, patch))
+	if e != nil {
+		t.Fatalf("%s: %v", patch, e)
+	}
+
+	return p.Apply(parse(t, doc))
+}
+
+func TestPatch(t *testing.T) {
+	doc := `{"a": 1, "list": ["x"], "o": {"k": null}}`
+
+	for i, v := range []struct {
+		patch, out string
+		skipped    []int
+	}{
+		{`[]`, doc, nil},
+		{`[{"op": "add", "path": "/list/-", "value": "y"}, {"op": "replace", "path": "/a", "value": 2}]`,
+			`{"a": 2, "list": ["x", "y"], "o": {"k": null}}`, nil},
+		{`[{"op": "remove", "path": "/o/k"}, {"op": "move", "from": "/a", "path": "/b"}, {"op": "copy", "from": "/list", "path": "/o/l"}]`,
+			`{"list": ["x"], "o": {"l": ["x"]}, "b": 1}`, nil},
+		// a failed test skips the rest of the set, and undoes what the set did
+		{`[{"op": "replace", "path": "/a", "value": 2}, {"op": "test", "path": "/a", "value": 3}, {"op": "add", "path": "/c", "value": 1}]`,
+			doc, []int{0}},
+		// other sets are applied
+		{`[[{"op": "test", "path": "/missing"}, {"op": "add", "path": "/c", "value": 1}],
+		   [{"op": "test", "path": "/missing", "inverse": true}, {"op": "add", "path": "/d", "value": 1}],
+		   [{"op": "test", "path": "/a", "value": 1.0}, {"op": "add", "path": "/e", "value": 1}],
+		   [{"op": "test", "path": "/a", "value": 1, "inverse": true}, {"op": "add", "path": "/f", "value": 1}],
+		   [{"op": "test", "path": "/o/k", "value": null}, {"op": "add", "path": "/g", "value": 1}],
+		   [{"op": "test", "path": "/o/k", "inverse": true}, {"op": "add", "path": "/h", "value": 1}],
+		   [{"op": "test", "path": "/list/0"}, {"op": "add", "path": "/list/0", "value": "w"}],]`,
+			`{"a": 1, "list": ["w", "x"], "o": {"k": null}, "d": 1, "e": 1, "g": 1}`, []int{0, 3, 5}},
+		// an object is merged
+		{`{"o": {"k": 1}, "a": null}`, `{"a": 1, "list": ["x"], "o": {"k": 1}}`, nil},
+	} {
+		r, skipped, e := apply(t, doc, v.patch)
+		if e != nil {
+			t.Fatalf("%d: %v", i, e)
+		}
+
+		expect(t, v.patch, r, v.out)
+
+		if len(skipped) != len(v.skipped) {
+			t.Fatalf("%d: skipped %v, expect sets %v", i, skipped, v.skipped)
+		}
+
+		for _, k := range v.skipped {
+			if !errors.Is(skipped[k], ErrTestFailed) {
+				t.Fatalf("%d: set %d is not skipped: %v", i, k, skipped)
+			}
+		}
+	}
+
+	// the patch values are not shared with the result
+	p, _ := ParsePatch(parse(t, `[{"op": "add", "path": "/v", "value": {"a": []}}]`))
+	base := parse(t, `{}`)
+
+	r, _, e := p.Apply(base)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e := (Op{Operation: jsonpatch.Operation{Op: "add", Path: "/v/a/-", Value: 1.0}}).Apply(r); e != nil {
+		t.Fatal(e)
+	}
+	expect(t, "value", p.Sets[0][0].Value, `{"a": []}`)
+	expect(t, "base", base, `{}`)
+
+	for _, v := range []string{
+		`[{"op": "replace", "path": "/missing", "value": 1}]`,
+		`[{"op": "remove", "path": "/list/5"}]`,
+		`[[{"op": "add", "path": "/c", "value": 1}], [{"op": "move", "from": "/nope", "path": "/a"}]]`,
+	} {
+		if _, _, e := apply(t, doc, v); e == nil || errors.Is(e, ErrTestFailed) {
+			t.Fatalf("%s: got %v", v, e)
+		}
+	}
+
+	for _, v := range []string{
+		`"x"`,
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "nope", "path": "/a"}]`,
+		`[{"path": "/a"}]`,
+		`[{"op": "test", "path": "/a", "inverse": "yes"}]`,
+		`[[{"op": "remove", "path": "/a"}], {"op": "remove", "path": "/a"}]`,
+		`[1]`,
+	} {
+		if _, e := ParsePatch(parse(t, v)); e == nil {
+			t.Fatalf("%s: no error", v)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package world
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/codec"
+)
+
+func init() {
+	codec.Register(&codec.Format{
+		Identifier: Identifier,
+		BlockSize:  BlockSize,
+		KeySize:    KeySize,
+		Codec:      worldCodec{},
+	})
+}
+
+// worldCodec decodes metadata and entities to the documents dumpbtreedb
+// writes, other records are left raw.
+type worldCodec struct{}
+
+func (worldCodec) Decode(key btreedb5.Key, data []byte) (interface{}, error) {
+	raw, e := Decompress(data)
+	if e != nil {
+		return nil, e
+	}
+
+	switch key[0] {
+	case MetadataType:
+		m := &Metadata{}
+		if e := m.Read(bytes.NewReader(raw)); e != nil {
+			return nil, e
+		}
+		return m.Document(), nil
+	case EntitySectorType:
+		l, e := ReadEntities(raw)
+		if e != nil {
+			return nil, e
+		}
+		return l.Document(), nil
+	default:
+		return raw, nil
+	}
+}
+
+func (worldCodec) Encode(key btreedb5.Key, doc interface{}) ([]byte, error) {
+	if raw, ok := doc.([]byte); ok {
+		return Compress(raw)
+	}
+
+	buf := &bytes.Buffer{}
+
+	switch key[0] {
+	case MetadataType:
+		m := &Metadata{}
+		if e := m.SetDocument(doc); e != nil {
+			return nil, e
+		}
+		if e := m.Write(buf); e != nil {
+			return nil, e
+		}
+	case EntitySectorType:
+		var l Entities
+		if e := l.SetDocument(doc); e != nil {
+			return nil, e
+		}
+		if e := l.Write(buf); e != nil {
+			return nil, e
+		}
+	default:
+		return nil, errors.Errorf("record type %d is not json", key[0])
+	}
+
+	return Compress(buf.Bytes())
+}
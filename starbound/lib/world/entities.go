@@ -0,0 +1,168 @@
+package world
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+type Entity struct {
+	Hdr  sbvj01.VerJsonHdr
+	Body interface{}
+}
+
+// Entities is the record under an EntitySectorType key.
+type Entities []Entity
+
+func (l *Entities) Read(rd io.Reader) error {
+	cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
+	if e != nil {
+		return e
+	}
+
+	r := Entities{}
+
+	for i, j := 0, int(cnt); i < j; i++ {
+		hdr, e := sbvj01.ReadHdr(rd)
+		if e != nil {
+			return e
+		}
+
+		body, e := sbvj01.Read(rd)
+		if e != nil {
+			return e
+		}
+
+		r = append(r, Entity{Hdr: hdr, Body: body})
+	}
+
+	*l = r
+	return nil
+}
+
+func (l *Entities) Write(wt io.Writer) error {
+	if e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(*l))); e != nil {
+		return e
+	}
+
+	for _, v := range *l {
+		if e := sbvj01.WriteHdr(wt, v.Hdr); e != nil {
+			return e
+		}
+
+		if e := sbvj01.Write(wt, v.Body); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func ReadEntities(data []byte) (Entities, error) {
+	var l Entities
+	if e := l.Read(bytes.NewReader(data)); e != nil {
+		return nil, e
+	}
+	return l, nil
+}
+
+func (l Entities) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e := l.Write(buf); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// Document returns the same layout dumpbtreedb writes to type2_ files.
+func (l Entities) Document() []interface{} {
+	r := make([]interface{}, len(l))
+	for k, v := range l {
+		r[k] = map[string]interface{}{
+			"hdr":  hdrDocument(v.Hdr),
+			"body": v.Body,
+		}
+	}
+	return r
+}
+
+// SetDocument is the reverse of Document.
+func (l *Entities) SetDocument(doc interface{}) error {
+	d, ok := doc.([]interface{})
+	if !ok {
+		return errors.New("entities is not an array")
+	}
+
+	r := make(Entities, len(d))
+	for k := range d {
+		v, ok := sbvj01.Members(d[k])
+		if !ok {
+			return errors.Errorf("entity %d is not an object", k)
+		}
+
+		hdr, e := setHdrDocument(v["hdr"])
+		if e != nil {
+			return errors.Wrapf(e, "entity %d", k)
+		}
+
+		body, ok := v["body"]
+		if !ok {
+			return errors.Errorf("entity %d has no body", k)
+		}
+
+		r[k] = Entity{Hdr: hdr, Body: body}
+	}
+
+	*l = r
+	return nil
+}
+
+func hdrDocument(hdr sbvj01.VerJsonHdr) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        string(hdr.Id),
+		"versioned": hdr.Versioned,
+		"version":   int64(hdr.Version),
+	}
+}
+
+func setHdrDocument(doc interface{}) (r sbvj01.VerJsonHdr, e error) {
+	hdr, ok := sbvj01.Members(doc)
+	if !ok {
+		return r, errors.New("hdr is not an object")
+	}
+
+	switch n := hdr["id"].(type) {
+	case string:
+		r.Id = data_types.String(n)
+	case data_types.String:
+		r.Id = n
+	default:
+		return r, errors.New("hdr.id is not a string")
+	}
+
+	r.Versioned, ok = hdr["versioned"].(bool)
+	if !ok {
+		return r, errors.New("hdr.versioned is not a bool")
+	}
+
+	version, e := integer(hdr["version"])
+	if e != nil {
+		return r, errors.Wrapf(e, "hdr.version")
+	}
+
+	r.Version = int32(version)
+	return r, nil
+}
+
+func LoadEntities(h *btreedb5.BTreeDB5, x, y uint16) (Entities, error) {
+	data, e := Get(h, SectorKey(EntitySectorType, x, y))
+	if e != nil {
+		return nil, e
+	}
+	return ReadEntities(data)
+}
@@ -0,0 +1,145 @@
+package world
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// Metadata is the record under MetadataKey: world size, then a versioned
+// json.
+type Metadata struct {
+	Width  uint32
+	Height uint32
+	Hdr    sbvj01.VerJsonHdr
+	Body   interface{}
+}
+
+func (m *Metadata) Read(rd io.Reader) (e error) {
+	m.Width, e = byteorder.Uint32(rd, byteorder.BigEndian)
+	if e != nil {
+		return
+	}
+
+	m.Height, e = byteorder.Uint32(rd, byteorder.BigEndian)
+	if e != nil {
+		return
+	}
+
+	m.Hdr, e = sbvj01.ReadHdr(rd)
+	if e != nil {
+		return
+	}
+
+	m.Body, e = sbvj01.Read(rd)
+	return
+}
+
+func (m *Metadata) Write(wt io.Writer) error {
+	if e := byteorder.PutUint32(wt, byteorder.BigEndian, m.Width); e != nil {
+		return e
+	}
+
+	if e := byteorder.PutUint32(wt, byteorder.BigEndian, m.Height); e != nil {
+		return e
+	}
+
+	if e := sbvj01.WriteHdr(wt, m.Hdr); e != nil {
+		return e
+	}
+
+	return sbvj01.Write(wt, m.Body)
+}
+
+func LoadMetadata(h *btreedb5.BTreeDB5) (*Metadata, error) {
+	data, e := Get(h, MetadataKey())
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to get metadata")
+	}
+
+	m := &Metadata{}
+	if e := m.Read(bytes.NewReader(data)); e != nil {
+		return nil, errors.Wrapf(e, "failed to decode metadata")
+	}
+
+	return m, nil
+}
+
+func (m *Metadata) Store(h *btreedb5.BTreeDB5) error {
+	buf := &bytes.Buffer{}
+
+	if e := m.Write(buf); e != nil {
+		return errors.Wrapf(e, "failed to encode metadata")
+	}
+
+	return Put(h, MetadataKey(), buf.Bytes())
+}
+
+// Document returns the same layout dumpbtreedb writes to the metadata file.
+func (m *Metadata) Document() map[string]interface{} {
+	return map[string]interface{}{
+		"size": []interface{}{int64(m.Width), int64(m.Height)},
+		"hdr":  hdrDocument(m.Hdr),
+		"body": m.Body,
+	}
+}
+
+// SetDocument is the reverse of Document.
+func (m *Metadata) SetDocument(doc interface{}) error {
+	d, ok := sbvj01.Members(doc)
+	if !ok {
+		return errors.New("metadata is not an object")
+	}
+
+	size, ok := d["size"].([]interface{})
+	if !ok || len(size) != 2 {
+		return errors.New("size is not an array of two numbers")
+	}
+
+	w, e := integer(size[0])
+	if e != nil {
+		return errors.Wrapf(e, "size[0]")
+	}
+
+	h, e := integer(size[1])
+	if e != nil {
+		return errors.Wrapf(e, "size[1]")
+	}
+
+	hdr, e := setHdrDocument(d["hdr"])
+	if e != nil {
+		return e
+	}
+
+	body, ok := d["body"]
+	if !ok {
+		return errors.New("no body")
+	}
+
+	m.Width = uint32(w)
+	m.Height = uint32(h)
+	m.Hdr = hdr
+	m.Body = body
+	return nil
+}
+
+func integer(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case data_types.Varint:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	default:
+		return 0, errors.Errorf("%v is not a number", v)
+	}
+}
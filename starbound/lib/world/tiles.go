@@ -0,0 +1,332 @@
+package world
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/data_types"
+)
+
+const SectorSize = 32
+
+// serialization versions of tiles, TileSector.Version. Every tile is 29
+// bytes, then a biome transition byte since 417, then a root source flag
+// since 418, followed by the root source as two int32 if the flag is set.
+const (
+	TileVersionMin             = 416
+	TileVersionBiomeTransition = 417
+	TileVersionRootSource      = 418
+	// TileVersion is the version the game writes.
+	TileVersion = TileVersionRootSource
+)
+
+// special material/mod ids
+const (
+	EmptyMaterial     uint16 = 65535
+	NullMaterial      uint16 = 65534
+	StructureMaterial uint16 = 65533
+	NoMod             uint16 = 65535
+	EmptyLiquid       uint8  = 0
+)
+
+// dungeon ids with a special meaning
+const (
+	NoDungeon           uint16 = 65535
+	SpawnDungeon        uint16 = 65534
+	BiomeMicroDungeon   uint16 = 65533
+	ConstructionDungeon uint16 = 65532
+	DestroyedDungeon    uint16 = 65531
+)
+
+// collision kinds, the collision of a tile is the one of its foreground
+// material
+const (
+	CollisionNull uint8 = iota
+	CollisionNone
+	CollisionPlatform
+	CollisionDynamic
+	CollisionSlippery
+	CollisionBlock
+)
+
+// CollisionKinds are the collisionKind names of material assets.
+var CollisionKinds = map[string]uint8{
+	"null":     CollisionNull,
+	"none":     CollisionNone,
+	"platform": CollisionPlatform,
+	"dynamic":  CollisionDynamic,
+	"slippery": CollisionSlippery,
+	"block":    CollisionBlock,
+}
+
+type Tile struct {
+	Foreground       uint16  `json:"foreground"`
+	ForegroundHue    uint8   `json:"foregroundHue"`
+	ForegroundColor  uint8   `json:"foregroundColor"`
+	ForegroundMod    uint16  `json:"foregroundMod"`
+	ForegroundModHue uint8   `json:"foregroundModHue"`
+	Background       uint16  `json:"background"`
+	BackgroundHue    uint8   `json:"backgroundHue"`
+	BackgroundColor  uint8   `json:"backgroundColor"`
+	BackgroundMod    uint16  `json:"backgroundMod"`
+	BackgroundModHue uint8   `json:"backgroundModHue"`
+	Liquid           uint8   `json:"liquid"`
+	LiquidLevel      float32 `json:"liquidLevel"`
+	LiquidPressure   float32 `json:"liquidPressure"`
+	LiquidInfinite   bool    `json:"liquidInfinite"`
+	Collision        uint8   `json:"collision"`
+	DungeonId        uint16  `json:"dungeonId"`
+	Biome            uint8   `json:"biome"`
+	Biome2           uint8   `json:"biome2"`
+	BiomeTransition  bool    `json:"biomeTransition"`
+	// RootSource is the tile a block is rooted to, only if HasRootSource.
+	HasRootSource bool     `json:"hasRootSource"`
+	RootSource    [2]int32 `json:"rootSource"`
+}
+
+func checkTileVersion(version uint64) error {
+	if version < TileVersionMin || version > TileVersion {
+		return errors.Errorf("unsupported tile version %d", version)
+	}
+	return nil
+}
+
+// Size is the number of bytes of the tile in the version.
+func (t *Tile) Size(version uint64) int {
+	n := 29
+	if version >= TileVersionBiomeTransition {
+		n++
+	}
+	if version >= TileVersionRootSource {
+		n++
+		if t.HasRootSource {
+			n += 8
+		}
+	}
+	return n
+}
+
+// ReadBuf reads a tile of the version, and returns its size.
+func (t *Tile) ReadBuf(buf []byte, version uint64) (int, error) {
+	if len(buf) < 29 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	e := byteorder.BigEndian
+	t.Foreground = e.Uint16(buf[0:])
+	t.ForegroundHue = buf[2]
+	t.ForegroundColor = buf[3]
+	t.ForegroundMod = e.Uint16(buf[4:])
+	t.ForegroundModHue = buf[6]
+	t.Background = e.Uint16(buf[7:])
+	t.BackgroundHue = buf[9]
+	t.BackgroundColor = buf[10]
+	t.BackgroundMod = e.Uint16(buf[11:])
+	t.BackgroundModHue = buf[13]
+	t.Liquid = buf[14]
+	t.LiquidLevel = e.Float32(buf[15:])
+	t.LiquidPressure = e.Float32(buf[19:])
+	t.LiquidInfinite = byteorder.Byte2Bool(buf[23])
+	t.Collision = buf[24]
+	t.DungeonId = e.Uint16(buf[25:])
+	t.Biome = buf[27]
+	t.Biome2 = buf[28]
+	n := 29
+
+	t.BiomeTransition = false
+	if version >= TileVersionBiomeTransition {
+		if len(buf) < n+1 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		t.BiomeTransition = byteorder.Byte2Bool(buf[n])
+		n++
+	}
+
+	t.HasRootSource, t.RootSource = false, [2]int32{}
+	if version >= TileVersionRootSource {
+		if len(buf) < n+1 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		t.HasRootSource = byteorder.Byte2Bool(buf[n])
+		n++
+
+		if t.HasRootSource {
+			if len(buf) < n+8 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			t.RootSource[0] = e.Int32(buf[n:])
+			t.RootSource[1] = e.Int32(buf[n+4:])
+			n += 8
+		}
+	}
+
+	return n, nil
+}
+
+// WriteBuf writes a tile of the version, buf must hold Size bytes.
+func (t *Tile) WriteBuf(buf []byte, version uint64) int {
+	e := byteorder.BigEndian
+	e.PutUint16(buf[0:], t.Foreground)
+	buf[2] = t.ForegroundHue
+	buf[3] = t.ForegroundColor
+	e.PutUint16(buf[4:], t.ForegroundMod)
+	buf[6] = t.ForegroundModHue
+	e.PutUint16(buf[7:], t.Background)
+	buf[9] = t.BackgroundHue
+	buf[10] = t.BackgroundColor
+	e.PutUint16(buf[11:], t.BackgroundMod)
+	buf[13] = t.BackgroundModHue
+	buf[14] = t.Liquid
+	e.PutFloat32(buf[15:], t.LiquidLevel)
+	e.PutFloat32(buf[19:], t.LiquidPressure)
+	buf[23] = byteorder.Bool2Byte(t.LiquidInfinite)
+	buf[24] = t.Collision
+	e.PutUint16(buf[25:], t.DungeonId)
+	buf[27] = t.Biome
+	buf[28] = t.Biome2
+	n := 29
+
+	if version >= TileVersionBiomeTransition {
+		buf[n] = byteorder.Bool2Byte(t.BiomeTransition)
+		n++
+	}
+
+	if version >= TileVersionRootSource {
+		buf[n] = byteorder.Bool2Byte(t.HasRootSource)
+		n++
+
+		if t.HasRootSource {
+			e.PutInt32(buf[n:], t.RootSource[0])
+			e.PutInt32(buf[n+4:], t.RootSource[1])
+			n += 8
+		}
+	}
+
+	return n
+}
+
+// TileSector is the record under a TileSectorType key, tiles are stored row
+// by row from the bottom, in the layout of Version.
+type TileSector struct {
+	Generation uint64
+	Version    uint64
+	Tiles      [SectorSize * SectorSize]Tile
+}
+
+func (s *TileSector) Tile(x, y int) *Tile {
+	return &s.Tiles[y*SectorSize+x]
+}
+
+func (s *TileSector) Read(rd io.Reader) error {
+	var u data_types.UVarint
+
+	if e := u.Read(rd, byteorder.BigEndian); e != nil {
+		return e
+	}
+	s.Generation = uint64(u)
+
+	if e := u.Read(rd, byteorder.BigEndian); e != nil {
+		return e
+	}
+	s.Version = uint64(u)
+
+	if e := checkTileVersion(s.Version); e != nil {
+		return e
+	}
+
+	buf, e := ioutil.ReadAll(rd)
+	if e != nil {
+		return e
+	}
+
+	off := 0
+	for k := range s.Tiles {
+		n, e := s.Tiles[k].ReadBuf(buf[off:], s.Version)
+		if e != nil {
+			return errors.Wrapf(e, "tile %d of version %d", k, s.Version)
+		}
+		off += n
+	}
+
+	if off != len(buf) {
+		return errors.Errorf("%d trailing bytes after tiles of version %d", len(buf)-off, s.Version)
+	}
+
+	return nil
+}
+
+func (s *TileSector) Write(wt io.Writer) error {
+	if e := checkTileVersion(s.Version); e != nil {
+		return e
+	}
+
+	u := data_types.UVarint(s.Generation)
+	if e := u.Write(wt, byteorder.BigEndian); e != nil {
+		return e
+	}
+
+	u = data_types.UVarint(s.Version)
+	if e := u.Write(wt, byteorder.BigEndian); e != nil {
+		return e
+	}
+
+	size := 0
+	for k := range s.Tiles {
+		size += s.Tiles[k].Size(s.Version)
+	}
+
+	buf := make([]byte, size)
+	for k, off := 0, 0; k < len(s.Tiles); k++ {
+		off += s.Tiles[k].WriteBuf(buf[off:], s.Version)
+	}
+
+	_, e := wt.Write(buf)
+	return e
+}
+
+func ReadTileSector(data []byte) (*TileSector, error) {
+	s := &TileSector{}
+	if e := s.Read(bytes.NewReader(data)); e != nil {
+		return nil, e
+	}
+	return s, nil
+}
+
+func (s *TileSector) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e := s.Write(buf); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// Modified reports whether a player placed or broke a block in the sector.
+func (s *TileSector) Modified() bool {
+	for k := range s.Tiles {
+		switch s.Tiles[k].DungeonId {
+		case ConstructionDungeon, DestroyedDungeon:
+			return true
+		}
+	}
+	return false
+}
+
+func LoadTileSector(h *btreedb5.BTreeDB5, x, y uint16) (*TileSector, error) {
+	data, e := Get(h, SectorKey(TileSectorType, x, y))
+	if e != nil {
+		return nil, e
+	}
+	return ReadTileSector(data)
+}
+
+func (s *TileSector) Store(h *btreedb5.BTreeDB5, x, y uint16) error {
+	data, e := s.Bytes()
+	if e != nil {
+		return e
+	}
+	return Put(h, SectorKey(TileSectorType, x, y), data)
+}
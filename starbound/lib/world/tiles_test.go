@@ -0,0 +1,123 @@
+package world
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// a dirt block of the 29 bytes all versions share: foreground 3 with hue 0
+// and color 1, no mod, background 3, no liquid, collision 5(block), dungeon
+// 65535, biomes 1 and 2
+const tileHead = "0003" + "00" + "01" + "ffff" + "00" +
+	"0003" + "00" + "00" + "ffff" + "00" +
+	"00" + "00000000" + "00000000" + "00" +
+	"05" + "ffff" + "01" + "02"
+
+// sector builds a tile sector record of generation 3 by hand, the first
+// tile is first, every other tile is rest.
+func sector(t *testing.T, version, first, rest string) []byte {
+	t.Helper()
+
+	s := "03" + version + first
+	for i := 1; i < SectorSize*SectorSize; i++ {
+		s += rest
+	}
+
+	data, e := hex.DecodeString(s)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return data
+}
+
+func TestTileSector(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		version uint64
+		data    []byte
+		first   Tile
+	}{
+		{
+			// 418 is a varint of two bytes, 83 22
+			name:    "418 with root source",
+			version: 418,
+			// biome transition, then the root source at -5,7
+			data:  sector(t, "8322", tileHead+"01"+"01"+"fffffffb"+"00000007", tileHead+"00"+"00"),
+			first: Tile{BiomeTransition: true, HasRootSource: true, RootSource: [2]int32{-5, 7}},
+		},
+		{
+			name:    "418",
+			version: 418,
+			data:    sector(t, "8322", tileHead+"01"+"00", tileHead+"00"+"00"),
+			first:   Tile{BiomeTransition: true},
+		},
+		{
+			name:    "417",
+			version: 417,
+			data:    sector(t, "8321", tileHead+"01", tileHead+"00"),
+			first:   Tile{BiomeTransition: true},
+		},
+		{
+			name:    "416",
+			version: 416,
+			data:    sector(t, "8320", tileHead, tileHead),
+		},
+	} {
+		s, e := ReadTileSector(v.data)
+		if e != nil {
+			t.Fatalf("%s: %v", v.name, e)
+		}
+
+		if s.Generation != 3 || s.Version != v.version {
+			t.Fatalf("%s: generation %d version %d", v.name, s.Generation, s.Version)
+		}
+
+		first := Tile{
+			Foreground: 3, ForegroundColor: 1, ForegroundMod: NoMod,
+			Background: 3, BackgroundMod: NoMod,
+			Collision: 5, DungeonId: NoDungeon, Biome: 1, Biome2: 2,
+		}
+		first.BiomeTransition = v.first.BiomeTransition
+		first.HasRootSource = v.first.HasRootSource
+		first.RootSource = v.first.RootSource
+
+		if *s.Tile(0, 0) != first {
+			t.Fatalf("%s: first tile %+v", v.name, *s.Tile(0, 0))
+		}
+
+		rest := first
+		rest.BiomeTransition, rest.HasRootSource, rest.RootSource = false, false, [2]int32{}
+		if *s.Tile(SectorSize-1, SectorSize-1) != rest {
+			t.Fatalf("%s: last tile %+v", v.name, *s.Tile(SectorSize-1, SectorSize-1))
+		}
+
+		r, e := s.Bytes()
+		if e != nil {
+			t.Fatalf("%s: %v", v.name, e)
+		}
+
+		if !bytes.Equal(r, v.data) {
+			t.Fatalf("%s: written bytes differ", v.name)
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		data []byte
+	}{
+		// 30 bytes tiles are one byte short in 418
+		{"short", sector(t, "8322", tileHead+"00", tileHead+"00")},
+		{"trailing", append(sector(t, "8321", tileHead+"00", tileHead+"00"), 0)},
+		{"old version", sector(t, "831f", tileHead, tileHead)},
+		{"new version", sector(t, "8323", tileHead+"0000", tileHead+"0000")},
+	} {
+		if _, e := ReadTileSector(v.data); e == nil {
+			t.Fatalf("%s: no error", v.name)
+		}
+	}
+
+	if _, e := (&TileSector{}).Bytes(); e == nil {
+		t.Fatal("version 0 written")
+	}
+}
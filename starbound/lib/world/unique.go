@@ -0,0 +1,149 @@
+package world
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/data_types"
+)
+
+// UniqueEntry locates an entity with a unique id.
+type UniqueEntry struct {
+	Id      data_types.String
+	SectorX uint32
+	SectorY uint32
+	X       float32
+	Y       float32
+}
+
+// UniqueIndex is the record under a UniqueIndexType key, a key is a short
+// hash of the ids, so one record may hold several of them.
+type UniqueIndex []UniqueEntry
+
+func (l *UniqueIndex) Read(rd io.Reader) error {
+	cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
+	if e != nil {
+		return e
+	}
+
+	r := UniqueIndex{}
+
+	for i, j := 0, int(cnt); i < j; i++ {
+		var v UniqueEntry
+
+		if e := v.Id.Read(rd, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		if v.SectorX, e = byteorder.Uint32(rd, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		if v.SectorY, e = byteorder.Uint32(rd, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		if v.X, e = byteorder.Float32(rd, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		if v.Y, e = byteorder.Float32(rd, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		r = append(r, v)
+	}
+
+	*l = r
+	return nil
+}
+
+func (l *UniqueIndex) Write(wt io.Writer) error {
+	if e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(*l))); e != nil {
+		return e
+	}
+
+	for _, v := range *l {
+		if e := v.Id.Write(wt, byteorder.BigEndian); e != nil {
+			return e
+		}
+
+		if e := byteorder.PutUint32(wt, byteorder.BigEndian, v.SectorX); e != nil {
+			return e
+		}
+
+		if e := byteorder.PutUint32(wt, byteorder.BigEndian, v.SectorY); e != nil {
+			return e
+		}
+
+		if e := byteorder.PutFloat32(wt, byteorder.BigEndian, v.X); e != nil {
+			return e
+		}
+
+		if e := byteorder.PutFloat32(wt, byteorder.BigEndian, v.Y); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func ReadUniqueIndex(data []byte) (UniqueIndex, error) {
+	var l UniqueIndex
+	rd := bytes.NewReader(data)
+	if e := l.Read(rd); e != nil {
+		return nil, e
+	}
+	if rd.Len() != 0 {
+		return nil, io.ErrShortBuffer
+	}
+	return l, nil
+}
+
+func (l UniqueIndex) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e := l.Write(buf); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// SectorUniques is the record under a SectorUniqueType key, the unique ids of
+// entities stored in the sector.
+type SectorUniques []data_types.String
+
+func (l *SectorUniques) Read(rd io.Reader) error {
+	cnt, e := byteorder.UVarint(rd, byteorder.BigEndian)
+	if e != nil {
+		return e
+	}
+
+	r := SectorUniques{}
+
+	for i, j := 0, int(cnt); i < j; i++ {
+		s, e := data_types.ReadString(rd, byteorder.BigEndian)
+		if e != nil {
+			return e
+		}
+
+		r = append(r, s)
+	}
+
+	*l = r
+	return nil
+}
+
+func (l *SectorUniques) Write(wt io.Writer) error {
+	if e := byteorder.PutUVarint(wt, byteorder.BigEndian, uint64(len(*l))); e != nil {
+		return e
+	}
+
+	for _, v := range *l {
+		if e := v.Write(wt, byteorder.BigEndian); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package world
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+)
+
+// Position is the tile position of an object. Wires refer to the objects on
+// both ends by it, since entity ids only live as long as the world is loaded.
+type Position [2]int64
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d,%d", p[0], p[1])
+}
+
+type WireObject struct {
+	Position Position `json:"position"`
+	Name     string   `json:"name"`
+	Inputs   int      `json:"inputs"`
+	Outputs  int      `json:"outputs"`
+}
+
+// WireEnd is a node of an object, the output node for Wire.From, the input
+// node for Wire.To.
+type WireEnd struct {
+	Position Position `json:"position"`
+	Node     int      `json:"node"`
+}
+
+// Wire is one connection. It is normally stored twice, on the output node of
+// one object and on the input node of the other, Output and Input tell which
+// of them were found.
+type Wire struct {
+	From   WireEnd `json:"from"`
+	To     WireEnd `json:"to"`
+	Output bool    `json:"output"`
+	Input  bool    `json:"input"`
+}
+
+type WireGraph struct {
+	Objects map[Position]*WireObject
+	Wires   []*Wire
+
+	wires map[[2]WireEnd]*Wire
+}
+
+func NewWireGraph() *WireGraph {
+	return &WireGraph{
+		Objects: map[Position]*WireObject{},
+		wires:   map[[2]WireEnd]*Wire{},
+	}
+}
+
+func position(v interface{}) (r Position, e error) {
+	l, ok := v.([]interface{})
+	if !ok || len(l) != 2 {
+		return r, errors.New("position is not an array of two numbers")
+	}
+
+	for k := range r {
+		r[k], e = integer(l[k])
+		if e != nil {
+			return r, e
+		}
+	}
+
+	return r, nil
+}
+
+// wireNodes returns the connections of every node in a inputWireNodes or
+// outputWireNodes array.
+func wireNodes(v interface{}) ([][]WireEnd, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	nodes, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("wire nodes is not an array")
+	}
+
+	r := make([][]WireEnd, len(nodes))
+	for k, node := range nodes {
+		c, e := jsonpatch.Get(node, jsonpatch.Pointer{"connections"})
+		if e != nil {
+			continue
+		}
+
+		l, ok := c.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("node %d: connections is not an array", k)
+		}
+
+		for i, conn := range l {
+			pair, ok := conn.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, errors.Errorf("node %d: connection %d is not a pair", k, i)
+			}
+
+			pos, e := position(pair[0])
+			if e != nil {
+				return nil, errors.Wrapf(e, "node %d: connection %d", k, i)
+			}
+
+			idx, e := integer(pair[1])
+			if e != nil {
+				return nil, errors.Wrapf(e, "node %d: connection %d", k, i)
+			}
+
+			r[k] = append(r[k], WireEnd{Position: pos, Node: int(idx)})
+		}
+	}
+
+	return r, nil
+}
+
+func (g *WireGraph) wire(from, to WireEnd) *Wire {
+	w, ok := g.wires[[2]WireEnd{from, to}]
+	if !ok {
+		w = &Wire{From: from, To: to}
+		g.wires[[2]WireEnd{from, to}] = w
+		g.Wires = append(g.Wires, w)
+	}
+	return w
+}
+
+// Add adds the wire nodes of all object entities in l. Objects without any
+// wire node are ignored.
+func (g *WireGraph) Add(l Entities) error {
+	for k, v := range l {
+		if v.Hdr.Id != "ObjectEntity" {
+			continue
+		}
+
+		in, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"inputWireNodes"})
+		inputs, e := wireNodes(in)
+		if e != nil {
+			return errors.Wrapf(e, "entity %d: inputWireNodes", k)
+		}
+
+		out, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"outputWireNodes"})
+		outputs, e := wireNodes(out)
+		if e != nil {
+			return errors.Wrapf(e, "entity %d: outputWireNodes", k)
+		}
+
+		if len(inputs) == 0 && len(outputs) == 0 {
+			continue
+		}
+
+		p, e := jsonpatch.Get(v.Body, jsonpatch.Pointer{"tilePosition"})
+		if e != nil {
+			return errors.Wrapf(e, "entity %d", k)
+		}
+
+		pos, e := position(p)
+		if e != nil {
+			return errors.Wrapf(e, "entity %d: tilePosition", k)
+		}
+
+		name, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"name"})
+		g.Objects[pos] = &WireObject{
+			Position: pos,
+			Name:     fmt.Sprint(name),
+			Inputs:   len(inputs),
+			Outputs:  len(outputs),
+		}
+
+		for i, l := range outputs {
+			for _, to := range l {
+				g.wire(WireEnd{Position: pos, Node: i}, to).Output = true
+			}
+		}
+
+		for i, l := range inputs {
+			for _, from := range l {
+				g.wire(from, WireEnd{Position: pos, Node: i}).Input = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sort orders the wires by the position of their output ends.
+func (g *WireGraph) Sort() {
+	less := func(a, b WireEnd) bool {
+		if a.Position != b.Position {
+			if a.Position[0] != b.Position[0] {
+				return a.Position[0] < b.Position[0]
+			}
+			return a.Position[1] < b.Position[1]
+		}
+		return a.Node < b.Node
+	}
+
+	sort.Slice(g.Wires, func(i, j int) bool {
+		a, b := g.Wires[i], g.Wires[j]
+		if a.From != b.From {
+			return less(a.From, b.From)
+		}
+		return less(a.To, b.To)
+	})
+}
+
+// Check returns a description of every wire pointing at a missing object or
+// node, or only stored on one of its ends.
+func (g *WireGraph) Check() []string {
+	r := []string{}
+
+	for _, w := range g.Wires {
+		from, ok := g.Objects[w.From.Position]
+		switch {
+		case !ok:
+			r = append(r, fmt.Sprintf("%s: no object at output end %s", w, w.From.Position))
+			continue
+		case w.From.Node >= from.Outputs:
+			r = append(r, fmt.Sprintf("%s: %s at %s has only %d output nodes", w, from.Name, from.Position, from.Outputs))
+			continue
+		}
+
+		to, ok := g.Objects[w.To.Position]
+		switch {
+		case !ok:
+			r = append(r, fmt.Sprintf("%s: no object at input end %s", w, w.To.Position))
+			continue
+		case w.To.Node >= to.Inputs:
+			r = append(r, fmt.Sprintf("%s: %s at %s has only %d input nodes", w, to.Name, to.Position, to.Inputs))
+			continue
+		}
+
+		switch {
+		case !w.Output:
+			r = append(r, fmt.Sprintf("%s: only stored on the input end", w))
+		case !w.Input:
+			r = append(r, fmt.Sprintf("%s: only stored on the output end", w))
+		}
+	}
+
+	return r
+}
+
+func (w *Wire) String() string {
+	return fmt.Sprintf("%s[%d] -> %s[%d]", w.From.Position, w.From.Node, w.To.Position, w.To.Node)
+}
+
+// Document returns the graph as json, objects and wires are sorted.
+func (g *WireGraph) Document() map[string]interface{} {
+	g.Sort()
+
+	objs := make([]*WireObject, 0, len(g.Objects))
+	for _, v := range g.Objects {
+		objs = append(objs, v)
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		a, b := objs[i].Position, objs[j].Position
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		return a[1] < b[1]
+	})
+
+	return map[string]interface{}{
+		"objects": objs,
+		"wires":   g.Wires,
+		"errors":  g.Check(),
+	}
+}
+
+// recordEscaper escapes text in a quoted record label, where |, {, } and <, >
+// delimit fields and ports.
+var recordEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`,
+	"|", `\|`, "{", `\{`, "}", `\}`, "<", `\<`, ">", `\>`)
+
+// WriteDOT writes the graph in graphviz format. Objects are records with one
+// port per node, missing objects are drawn dashed.
+func (g *WireGraph) WriteDOT(wt io.Writer) error {
+	g.Sort()
+
+	id := func(p Position) string {
+		return fmt.Sprintf("\"%s\"", p)
+	}
+
+	if _, e := fmt.Fprintf(wt, "digraph wires {\n\trankdir=LR;\n\tnode [shape=record];\n"); e != nil {
+		return e
+	}
+
+	doc := g.Document()
+	for _, v := range doc["objects"].([]*WireObject) {
+		label := ""
+		for i := 0; i < v.Inputs; i++ {
+			label += fmt.Sprintf("<i%d> in %d|", i, i)
+		}
+		label += fmt.Sprintf("%s\\n%s", recordEscaper.Replace(v.Name), v.Position)
+		for i := 0; i < v.Outputs; i++ {
+			label += fmt.Sprintf("|<o%d> out %d", i, i)
+		}
+
+		if _, e := fmt.Fprintf(wt, "\t%s [label=\"%s\"];\n", id(v.Position), label); e != nil {
+			return e
+		}
+	}
+
+	missing := map[Position]bool{}
+	for _, w := range g.Wires {
+		for _, p := range []Position{w.From.Position, w.To.Position} {
+			if _, ok := g.Objects[p]; !ok && !missing[p] {
+				missing[p] = true
+				if _, e := fmt.Fprintf(wt, "\t%s [label=\"missing\\n%s\", style=dashed];\n", id(p), p); e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	for _, w := range g.Wires {
+		broken := !w.Input || !w.Output
+
+		from := id(w.From.Position)
+		if v, ok := g.Objects[w.From.Position]; ok && w.From.Node < v.Outputs {
+			from += fmt.Sprintf(":o%d", w.From.Node)
+		} else {
+			broken = true
+		}
+
+		to := id(w.To.Position)
+		if v, ok := g.Objects[w.To.Position]; ok && w.To.Node < v.Inputs {
+			to += fmt.Sprintf(":i%d", w.To.Node)
+		} else {
+			broken = true
+		}
+
+		attr := ""
+		if broken {
+			attr = " [style=dashed, color=red]"
+		}
+
+		if _, e := fmt.Fprintf(wt, "\t%s -> %s%s;\n", from, to, attr); e != nil {
+			return e
+		}
+	}
+
+	_, e := fmt.Fprintf(wt, "}\n")
+	return e
+}
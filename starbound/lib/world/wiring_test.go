@@ -0,0 +1,30 @@
+package world
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	g := NewWireGraph()
+	g.Objects[Position{1, 2}] = &WireObject{Position: Position{1, 2}, Name: `a|b{c}<d>"e"\f`, Inputs: 1, Outputs: 1}
+	g.Objects[Position{3, 4}] = &WireObject{Position: Position{3, 4}, Name: "lamp", Inputs: 1}
+	g.wire(WireEnd{Position{1, 2}, 0}, WireEnd{Position{3, 4}, 0}).Output = true
+
+	buf := &bytes.Buffer{}
+	if e := g.WriteDOT(buf); e != nil {
+		t.Fatal(e)
+	}
+
+	out := `digraph wires {
+	rankdir=LR;
+	node [shape=record];
+	"1,2" [label="<i0> in 0|a\|b\{c\}\<d\>\"e\"\\f\n1,2|<o0> out 0"];
+	"3,4" [label="<i0> in 0|lamp\n3,4"];
+	"1,2":o0 -> "3,4":i0 [style=dashed, color=red];
+}
+`
+	if buf.String() != out {
+		t.Fatalf("got\n%s\nexpect\n%s", buf, out)
+	}
+}
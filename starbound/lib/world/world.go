@@ -0,0 +1,99 @@
+package world
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+)
+
+// record types, the first byte of a key
+const (
+	MetadataType byte = iota
+	TileSectorType
+	EntitySectorType
+	SectorUniqueType
+	UniqueIndexType
+)
+
+const (
+	Identifier = "World4"
+	BlockSize  = 2048
+	KeySize    = 5
+)
+
+func MetadataKey() btreedb5.Key {
+	return make(btreedb5.Key, KeySize)
+}
+
+func SectorKey(typ byte, x, y uint16) btreedb5.Key {
+	key := make(btreedb5.Key, KeySize)
+	key[0] = typ
+	byteorder.BigEndian.PutUint16(key[1:], x)
+	byteorder.BigEndian.PutUint16(key[3:], y)
+	return key
+}
+
+// ParseKey splits a key into its type and sector coordinates.
+func ParseKey(key btreedb5.Key) (typ byte, x, y uint16) {
+	return key[0], byteorder.BigEndian.Uint16(key[1:]), byteorder.BigEndian.Uint16(key[3:])
+}
+
+// Decompress inflates a stored record.
+func Decompress(data []byte) ([]byte, error) {
+	z, e := zlib.NewReader(bytes.NewReader(data))
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to inflate record")
+	}
+	defer z.Close()
+
+	r, e := ioutil.ReadAll(z)
+	if e != nil {
+		return nil, errors.Wrapf(e, "failed to inflate record")
+	}
+
+	return r, nil
+}
+
+// Compress deflates a record the same way makebtreedb does.
+func Compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	zw, e := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if e != nil {
+		return nil, e
+	}
+
+	if _, e := zw.Write(data); e != nil {
+		return nil, e
+	}
+
+	if e := zw.Close(); e != nil {
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Get fetches and inflates a record.
+func Get(h *btreedb5.BTreeDB5, key btreedb5.Key) ([]byte, error) {
+	data, e := h.Get(key)
+	if e != nil {
+		return nil, e
+	}
+
+	return Decompress(data)
+}
+
+// Put deflates and stores a record.
+func Put(h *btreedb5.BTreeDB5, key btreedb5.Key, data []byte) error {
+	z, e := Compress(data)
+	if e != nil {
+		return e
+	}
+
+	return h.Insert(key, z)
+}
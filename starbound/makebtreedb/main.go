@@ -14,19 +14,34 @@ import (
 
 	"github.com/xhebox/bstruct/byteorder"
 	"github.com/xhebox/sbutils/lib/btreedb5"
-	"github.com/xhebox/sbutils/lib/data_types"
+	_ "github.com/xhebox/sbutils/lib/celestial"
+	"github.com/xhebox/sbutils/lib/codec"
 	"github.com/xhebox/sbutils/lib/sbvj01"
+	_ "github.com/xhebox/sbutils/lib/world"
 )
 
+// metadata and entity are the json layouts dumpbtreedb writes.
+type metadata struct {
+	Size [2]uint32         `sbvj:"size"`
+	Hdr  sbvj01.VerJsonHdr `sbvj:"hdr"`
+	Body interface{}       `sbvj:"body"`
+}
+
+type entity struct {
+	Hdr  sbvj01.VerJsonHdr `sbvj:"hdr"`
+	Body interface{}       `sbvj:"body"`
+}
+
 func Exists(name string) bool {
 	_, err := os.Stat(name)
 	return !os.IsNotExist(err)
 }
 
 func main() {
-	var in, dir string
+	var in, dir, ident string
 	var root bool
 	flag.StringVar(&in, "i", "input", "db file")
+	flag.StringVar(&ident, "t", "World4", "identifier of a new db file")
 	flag.StringVar(&dir, "d", "dir", "records dir")
 	flag.BoolVar(&root, "r", false, "root")
 	flag.Parse()
@@ -40,13 +55,20 @@ func main() {
 			log.Fatalln(e)
 		}
 	} else {
-		h, e = btreedb5.New(in, "World4", 2048, 5)
+		f, e := codec.Lookup(ident)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		h, e = btreedb5.New(in, f.Identifier, f.BlockSize, f.KeySize)
 		if e != nil {
 			log.Fatalln(e)
 		}
 	}
 	defer h.Close()
 
+	format, _ := codec.Lookup(h.Identifier)
+
 	files, e := ioutil.ReadDir(dir)
 	if e != nil {
 		log.Fatalln(e)
@@ -74,66 +96,106 @@ func main() {
 		key := make(btreedb5.Key, h.KeySize)
 
 		switch {
-		case fname == "metadata":
-			content := map[string]interface{}{}
+		case strings.HasPrefix(fname, "json_"):
+			if format == nil {
+				log.Fatalf("no codec for %q\n", h.Identifier)
+			}
 
-			e := json.Unmarshal(fc, &content)
+			key, e = hex.DecodeString(fname[5:])
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			size := content["size"].([]interface{})
+			if len(key) != h.KeySize {
+				log.Fatalf("key size is not %d\n", h.KeySize)
+			}
 
-			e = byteorder.PutUint32(zw, byteorder.BigEndian, uint32(size[0].(float64)))
+			var doc interface{}
+
+			d := json.NewDecoder(bytes.NewReader(fc))
+			d.UseNumber()
+			if e := d.Decode(&doc); e != nil {
+				log.Fatalln(e)
+			}
+
+			data, e := format.Codec.Encode(key, doc)
+			if e != nil {
+				log.Fatalf("%s: %+v\n", fname, e)
+			}
+
+			f.Close()
+
+			if e := h.Insert(key, data); e != nil {
+				log.Fatalf("%+v\n", e)
+			}
+
+			h.Commit()
+			continue
+		case fname == "metadata":
+			doc, e := sbvj01.DecodeJSON(bytes.NewReader(fc))
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			e = byteorder.PutUint32(zw, byteorder.BigEndian, uint32(size[1].(float64)))
+			content := metadata{}
+			if e := sbvj01.FromValue(doc, &content); e != nil {
+				log.Fatalf("%s: %+v\n", fname, e)
+			}
+
+			e = byteorder.PutUint32(zw, byteorder.BigEndian, content.Size[0])
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			hdr := content["hdr"].(map[string]interface{})
+			e = byteorder.PutUint32(zw, byteorder.BigEndian, content.Size[1])
+			if e != nil {
+				log.Fatalln(e)
+			}
 
-			e = sbvj01.WriteHdr(zw, sbvj01.VerJsonHdr{
-				Id:        data_types.String(hdr["id"].(string)),
-				Versioned: hdr["versioned"].(bool),
-				Version:   int32(uint32(hdr["version"].(float64))),
-			})
+			e = sbvj01.WriteHdr(zw, content.Hdr)
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			e = sbvj01.Write(zw, content["body"])
+			e = sbvj01.Write(zw, content.Body)
 			if e != nil {
 				log.Fatalln(e)
 			}
 		case strings.HasPrefix(fname, "type2_"):
-			content := []interface{}{}
-
-			e := json.Unmarshal(fc, &content)
+			doc, e := sbvj01.DecodeJSON(bytes.NewReader(fc))
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			e = byteorder.PutUVarint(zw, byteorder.BigEndian, uint64(uint(len(content))))
+			content := []entity{}
+			if e := sbvj01.FromValue(doc, &content); e != nil {
+				log.Fatalf("%s: %+v\n", fname, e)
+			}
+
+			pos, e := hex.DecodeString(fname[6:])
 			if e != nil {
 				log.Fatalln(e)
 			}
 
-			for k := range content {
-				ii := content[k].(map[string]interface{})
+			if len(pos)+1 != h.KeySize {
+				log.Fatalf("key size is not %d\n", h.KeySize)
+			}
 
-				hdr := ii["hdr"].(map[string]interface{})
+			key[0] = 2
+			copy(key[1:], pos)
 
-				e = sbvj01.WriteHdr(zw, sbvj01.VerJsonHdr{
-					Id:        data_types.String(hdr["id"].(string)),
-					Versioned: hdr["versioned"].(bool),
-					Version:   int32(uint32(hdr["version"].(float64))),
-				})
+			e = byteorder.PutUVarint(zw, byteorder.BigEndian, uint64(uint(len(content))))
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			for _, v := range content {
+				e = sbvj01.WriteHdr(zw, v.Hdr)
+				if e != nil {
+					log.Fatalln(e)
+				}
 
-				e = sbvj01.Write(zw, ii["body"])
+				e = sbvj01.Write(zw, v.Body)
 				if e != nil {
 					log.Fatalln(e)
 				}
@@ -2,22 +2,73 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"flag"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 
-	"../lib/sbvj01"
-	"github.com/xhebox/bstruct/byteorder"
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/atomicfile"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/sbvj01"
 )
 
+// hasHdr tells whether doc is a document with header, as dumpsbvj01 outputs.
+func hasHdr(doc interface{}) bool {
+	m, ok := sbvj01.Members(doc)
+	if !ok {
+		return false
+	}
+
+	_, id := m["id"]
+	_, version := m["version"]
+	_, content := m["content"]
+	return id && version && content
+}
+
+// guess returns the mode of the output file if it is a versioned json, so it
+// is rewritten as it was. Otherwise it is nvj for an array of documents with
+// header, vj for one, and raw for anything else.
+func guess(out string, doc interface{}) (string, error) {
+	if out != "stdout" {
+		info, e := detect.File(out)
+		if e == nil {
+			switch info.Kind {
+			case detect.VJMagic, detect.VJ, detect.Raw, detect.NVJ:
+				return info.Kind.String(), nil
+			}
+		} else if !os.IsNotExist(e) {
+			return "", e
+		}
+	}
+
+	if l, ok := doc.([]interface{}); ok && len(l) != 0 {
+		all := true
+		for _, v := range l {
+			all = all && hasHdr(v)
+		}
+
+		if all {
+			return "nvj", nil
+		}
+	}
+
+	if hasHdr(doc) {
+		return "vj", nil
+	}
+
+	return "raw", nil
+}
+
 func main() {
 	var in, out, mode string
+	var annotated, canonical bool
 	flag.StringVar(&in, "i", "input", "input json")
 	flag.StringVar(&out, "o", "stdout", "output versioned json")
-	flag.StringVar(&mode, "m", "vj", "vjmagic/vj/raw")
+	flag.StringVar(&mode, "m", "", "vjmagic/vj/raw/nvj, detected if empty")
+	flag.BoolVar(&annotated, "a", false, "input is annotated json, as dumpsbvj01 -a outputs")
+	flag.BoolVar(&canonical, "c", false, "write the canonical form, with sorted keys")
 	flag.Parse()
 	log.SetFlags(log.Llongfile)
 
@@ -26,82 +77,85 @@ func main() {
 		log.Fatalln(e)
 	}
 
-	var outwt io.Writer
-	if out == "stdout" {
-		outwt = os.Stdout
-	} else {
-		f, e := os.OpenFile(out, os.O_CREATE|os.O_RDWR, 0644)
-		if e != nil {
-			log.Fatalln(e)
-		}
-		defer f.Close()
-
-		outwt = f
+	r, e := assetjson.Parse(contents)
+	if e != nil {
+		log.Fatalln(e)
 	}
 
-	switch mode {
-	case "raw":
-		var r interface{}
-
-		d := json.NewDecoder(bytes.NewReader(contents))
-		d.UseNumber()
-
-		if e := d.Decode(&r); e != nil {
-			log.Fatalln(e)
-		}
-
-		if e := sbvj01.WriteRaw(outwt, r, byteorder.BigEndian); e != nil {
+	if mode == "" {
+		if mode, e = guess(out, r); e != nil {
 			log.Fatalln(e)
 		}
-	case "vj":
-		var r interface{}
-
-		d := json.NewDecoder(bytes.NewReader(contents))
-		d.UseNumber()
+	}
 
-		if e := d.Decode(&r); e != nil {
+	var l sbvj01.List
+	var form sbvj01.Form
+	if mode == "nvj" {
+		if e := l.SetDocument(r); e != nil {
 			log.Fatalln(e)
 		}
-
-		v, ok := r.(map[string]interface{})
-		if !ok {
-			log.Fatalln("not a versioned json?")
-		}
-
-		version, e := v["version"].(json.Number).Int64()
+	} else {
+		form, e = sbvj01.ParseForm(mode)
 		if e != nil {
 			log.Fatalln(e)
 		}
 
-		vj := sbvj01.VersionedJson{Id: v["id"].(string), Version: int(version), Content: v["content"]}
-
-		if e := sbvj01.Write(outwt, vj); e != nil {
-			log.Fatalln(e)
+		vj := &sbvj01.VersionedJson{Content: r}
+		if form != sbvj01.FormRaw {
+			if e := vj.SetDocument(r); e != nil {
+				log.Fatalln(e)
+			}
 		}
-	case "vjmagic":
-		var r interface{}
 
-		d := json.NewDecoder(bytes.NewReader(contents))
-		d.UseNumber()
+		l = sbvj01.List{vj}
+	}
 
-		if e := d.Decode(&r); e != nil {
-			log.Fatalln(e)
+	for i, vj := range l {
+		where := "content"
+		if mode == "nvj" {
+			where = fmt.Sprintf("[%d].content", i)
 		}
 
-		v, ok := r.(map[string]interface{})
-		if !ok {
-			log.Fatalln("not a versioned json?")
+		if annotated {
+			vj.Content, e = sbvj01.Unannotate(vj.Content)
+			if e != nil {
+				log.Fatalf("%s: %v\n", where, e)
+			}
 		}
 
-		version, e := v["version"].(json.Number).Int64()
-		if e != nil {
-			log.Fatalln(e)
+		if canonical {
+			vj.Content, e = sbvj01.Canonical(vj.Content)
+			if e != nil {
+				log.Fatalf("%s: %v\n", where, e)
+			}
 		}
+	}
+
+	// the output is only touched once everything is encoded
+	buf := &bytes.Buffer{}
 
-		vj := sbvj01.VersionedJson{Id: v["id"].(string), Version: int(version), Content: v["content"]}
+	if mode == "nvj" {
+		e = l.Write(buf)
+	} else {
+		e = sbvj01.WriteVersioned(buf, l[0], form)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
 
-		if e := sbvj01.WriteMagic(outwt, vj); e != nil {
+	if out == "stdout" {
+		if _, e := os.Stdout.Write(buf.Bytes()); e != nil {
 			log.Fatalln(e)
 		}
+		return
+	}
+
+	perm := os.FileMode(0644)
+	if fi, e := os.Stat(out); e == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	if e := atomicfile.WriteFile(out, buf.Bytes(), perm); e != nil {
+		log.Fatalln(e)
 	}
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xhebox/sbutils/lib/detect"
+)
+
+// Hdr is a versioned json header found in a file.
+type Hdr struct {
+	Id        string `json:"id"`
+	Versioned bool   `json:"versioned"`
+	Version   int32  `json:"version"`
+}
+
+// Result is what a file is.
+type Result struct {
+	File        string   `json:"file"`
+	Kind        string   `json:"kind"`
+	Description string   `json:"description"`
+	Identifier  string   `json:"identifier,omitempty"`
+	BlockSize   int      `json:"blockSize,omitempty"`
+	KeySize     int      `json:"keySize,omitempty"`
+	Hdrs        []Hdr    `json:"headers,omitempty"`
+	Commands    []string `json:"commands"`
+}
+
+func result(file string, info *detect.Info) Result {
+	r := Result{
+		File:        file,
+		Kind:        info.Kind.String(),
+		Description: info.String(),
+		Identifier:  info.Identifier,
+		BlockSize:   info.BlockSize,
+		KeySize:     info.KeySize,
+		Commands:    info.Commands(),
+	}
+
+	for _, v := range info.Hdrs {
+		r.Hdrs = append(r.Hdrs, Hdr{Id: string(v.Id), Versioned: v.Versioned, Version: v.Version})
+	}
+
+	if r.Commands == nil {
+		r.Commands = []string{}
+	}
+
+	return r
+}
+
+func main() {
+	var js, unknown bool
+	flag.BoolVar(&js, "j", false, "output json")
+	flag.BoolVar(&unknown, "u", false, "also list unknown files of directories")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: [flags] FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	results := []Result{}
+	failed := false
+
+	add := func(file string, dir bool) {
+		info, e := detect.File(file)
+		if e != nil {
+			log.Printf("%s: %v\n", file, e)
+			failed = true
+			return
+		}
+
+		if dir && !unknown && info.Kind == detect.Unknown {
+			return
+		}
+
+		r := result(file, info)
+		if js {
+			results = append(results, r)
+			return
+		}
+
+		fmt.Printf("%s: %s\n", r.File, r.Description)
+		if len(r.Commands) != 0 {
+			fmt.Printf("\t%s\n", strings.Join(r.Commands, " "))
+		}
+	}
+
+	for _, arg := range flag.Args() {
+		fi, e := os.Stat(arg)
+		if e != nil {
+			log.Println(e)
+			failed = true
+			continue
+		}
+
+		if !fi.IsDir() {
+			add(arg, false)
+			continue
+		}
+
+		e = filepath.Walk(arg, func(file string, info os.FileInfo, e error) error {
+			if e != nil {
+				return e
+			}
+
+			if !info.IsDir() {
+				add(file, true)
+			}
+			return nil
+		})
+		if e != nil {
+			log.Println(e)
+			failed = true
+		}
+	}
+
+	if js {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if e := enc.Encode(results); e != nil {
+			log.Fatalln(e)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
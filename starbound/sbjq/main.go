@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/query"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// record returns a world record, decompressed if it is as stored in the
+// world file.
+func record(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0x78 {
+		return data
+	}
+
+	z, e := zlib.NewReader(bytes.NewReader(data))
+	if e != nil {
+		return data
+	}
+	defer z.Close()
+
+	r, e := ioutil.ReadAll(z)
+	if e != nil {
+		return data
+	}
+
+	return r
+}
+
+// modeOf detects the mode of a file, world records are not told apart from
+// other bytes and need -m.
+func modeOf(in string) (string, error) {
+	info, e := detect.File(in)
+	if e != nil {
+		return "", e
+	}
+
+	switch {
+	case info.Kind == detect.BTreeDB5 && info.Identifier == world.Identifier:
+		return "world", nil
+	case info.Kind == detect.VJMagic, info.Kind == detect.VJ, info.Kind == detect.Raw, info.Kind == detect.JSON:
+		return info.Kind.String(), nil
+	default:
+		return "", errors.Errorf("%s is %s, use -m to force a mode", in, info)
+	}
+}
+
+// load decodes the input of the mode into a document.
+func load(in, mode string) (interface{}, error) {
+	if mode == "" {
+		var e error
+		if mode, e = modeOf(in); e != nil {
+			return nil, e
+		}
+	}
+
+	if mode == "world" {
+		h, e := btreedb5.LoadReadOnly(in)
+		if e != nil {
+			return nil, e
+		}
+		defer h.Close()
+
+		m, e := world.LoadMetadata(h)
+		if e != nil {
+			return nil, e
+		}
+
+		return m.Document(), nil
+	}
+
+	data, e := ioutil.ReadFile(in)
+	if e != nil {
+		return nil, e
+	}
+
+	switch mode {
+	case "json":
+		return assetjson.Parse(data)
+	case "meta":
+		data = record(data)
+
+		m := &world.Metadata{}
+		if e := m.Read(bytes.NewReader(data)); e != nil {
+			return nil, withContext(e, data)
+		}
+
+		return m.Document(), nil
+	case "entities":
+		data = record(data)
+
+		l, e := world.ReadEntities(data)
+		if e != nil {
+			return nil, withContext(e, data)
+		}
+
+		return l.Document(), nil
+	default:
+		form, e := sbvj01.ParseForm(mode)
+		if e != nil {
+			return nil, e
+		}
+
+		rd := bytes.NewReader(data)
+
+		vj, e := sbvj01.ReadVersioned(rd, form)
+		if e != nil {
+			return nil, withContext(e, data)
+		}
+
+		if rd.Len() != 0 {
+			log.Printf("%d trailing bytes\n", rd.Len())
+		}
+
+		if form == sbvj01.FormRaw {
+			return vj.Content, nil
+		}
+		return vj.Document(), nil
+	}
+}
+
+// contextError is a decode error with the hex context of data.
+type contextError string
+
+func (e contextError) Error() string {
+	return string(e)
+}
+
+func withContext(e error, data []byte) error {
+	return contextError(sbvj01.ErrorContext(e, data, 0))
+}
+
+func main() {
+	var in, mode, out, q string
+	var raw, compact, annotated bool
+	flag.StringVar(&in, "i", "input", "input file")
+	flag.StringVar(&mode, "m", "", "vjmagic/vj/raw/meta/entities/world/json, detected if empty")
+	flag.StringVar(&q, "q", ".", "query")
+	flag.StringVar(&out, "o", "stdout", "output file")
+	flag.BoolVar(&raw, "r", false, "output strings without quotes")
+	flag.BoolVar(&compact, "c", false, "output one value per line")
+	flag.BoolVar(&annotated, "a", false, "annotated json that keeps the exact types")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	filter, e := query.Parse(q)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	doc, e := load(in, mode)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	res, e := filter.Run(doc)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	var outwt io.Writer
+	if out == "stdout" {
+		outwt = os.Stdout
+	} else {
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		defer f.Close()
+
+		outwt = f
+	}
+
+	wt := bufio.NewWriter(outwt)
+	defer wt.Flush()
+
+	enc := json.NewEncoder(wt)
+	enc.SetEscapeHTML(false)
+	if !compact {
+		enc.SetIndent("", "\t")
+	}
+
+	for _, v := range res {
+		if raw {
+			switch s := v.(type) {
+			case string:
+				wt.WriteString(s + "\n")
+				continue
+			case data_types.String:
+				wt.WriteString(string(s) + "\n")
+				continue
+			}
+		}
+
+		if annotated {
+			v = sbvj01.Annotate(v)
+		}
+
+		if e := enc.Encode(v); e != nil {
+			log.Fatalln(e)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/starjson"
+)
+
+func main() {
+	var in, patches, out string
+	var compact, verbose bool
+	flag.StringVar(&in, "i", "input", "input asset")
+	flag.StringVar(&patches, "p", "input.patch", "patch files, separated by commas, applied in order")
+	flag.StringVar(&out, "o", "stdout", "output json")
+	flag.BoolVar(&compact, "c", false, "output compact json")
+	flag.BoolVar(&verbose, "v", false, "print the patch sets skipped by failed tests")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	doc, e := assetjson.ReadFile(in)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	for _, name := range strings.Split(patches, ",") {
+		v, e := assetjson.ReadFile(name)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		p, e := starjson.ParsePatch(v)
+		if e != nil {
+			log.Fatalf("%s: %v\n", name, e)
+		}
+
+		r, skipped, e := p.Apply(doc)
+		if e != nil {
+			log.Fatalf("%s: %v\n", name, e)
+		}
+		doc = r
+
+		if verbose {
+			sets := make([]int, 0, len(skipped))
+			for k := range skipped {
+				sets = append(sets, k)
+			}
+			sort.Ints(sets)
+
+			for _, k := range sets {
+				log.Printf("%s: skipped %v\n", name, skipped[k])
+			}
+		}
+	}
+
+	var outwt io.Writer
+	if out == "stdout" {
+		outwt = os.Stdout
+	} else {
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		defer f.Close()
+
+		outwt = f
+	}
+
+	wt := bufio.NewWriter(outwt)
+	defer wt.Flush()
+
+	enc := json.NewEncoder(wt)
+	enc.SetEscapeHTML(false)
+	if !compact {
+		enc.SetIndent("", "\t")
+	}
+
+	if e := enc.Encode(doc); e != nil {
+		log.Fatalln(e)
+	}
+}
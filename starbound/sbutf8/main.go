@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xhebox/sbutils/lib/atomicfile"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/data_types"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// checker reports invalid strings of a value, and repairs them.
+type checker struct {
+	file   string
+	repair bool
+	sub    string
+	backup string
+	found  int
+	// repaired counts the invalid strings written back
+	repaired int
+	// conflict is set when keys of an object are the same once repaired, the
+	// value is not written then
+	conflict error
+}
+
+func (c *checker) str(where, path string, s string) string {
+	if data_types.ValidUTF8(s) {
+		return s
+	}
+
+	c.found++
+	if where == "" {
+		fmt.Printf("%s %s: %q\n", c.file, path, s)
+	} else {
+		fmt.Printf("%s %s %s: %q\n", c.file, where, path, s)
+	}
+
+	if c.repair {
+		return data_types.ReplaceUTF8(s, c.sub)
+	}
+	return s
+}
+
+func pathKey(k string) string {
+	for i, c := range k {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || (i > 0 && c >= '0' && c <= '9')) {
+			return fmt.Sprintf("[%q]", k)
+		}
+	}
+	if k == "" {
+		return `[""]`
+	}
+	return "." + k
+}
+
+// value checks the strings and keys of v, and returns it repaired.
+func (c *checker) value(where, path string, v interface{}) interface{} {
+	switch n := v.(type) {
+	case data_types.String:
+		return data_types.String(c.str(where, path, string(n)))
+	case string:
+		return c.str(where, path, n)
+	case []interface{}:
+		for i := range n {
+			n[i] = c.value(where, fmt.Sprintf("%s[%d]", path, i), n[i])
+		}
+		return n
+	case *sbvj01.Object:
+		r := sbvj01.NewObject()
+		keys := map[string]bool{}
+		for _, k := range n.Keys() {
+			w, _ := n.Get(k)
+			p := path + pathKey(k)
+
+			// checked without -r too, a repair would lose a member
+			fixed := data_types.ReplaceUTF8(k, c.sub)
+			if keys[fixed] && c.conflict == nil {
+				c.conflict = fmt.Errorf("%s: %q is the same as another key once repaired to %q", strings.TrimSpace(where+" "+p), k, fixed)
+			}
+			keys[fixed] = true
+
+			r.Set(c.str(where, p+" (key)", k), c.value(where, p, w))
+		}
+		return r
+	default:
+		return v
+	}
+}
+
+func (c *checker) hdr(where string, hdr sbvj01.VerJsonHdr) sbvj01.VerJsonHdr {
+	hdr.Id = data_types.String(c.str(where, "id", string(hdr.Id)))
+	return hdr
+}
+
+func (c *checker) vj(name string) error {
+	vj, e := sbvj01.ReadFile(name, sbvj01.FormMagic)
+	if e != nil {
+		return e
+	}
+
+	found := c.found
+	c.conflict = nil
+	vj.VerJsonHdr = c.hdr("", vj.VerJsonHdr)
+	vj.Content = c.value("", "$", vj.Content)
+
+	if c.conflict != nil {
+		return c.conflict
+	}
+	if !c.repair || c.found == found {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if e := sbvj01.WriteVersioned(buf, vj, sbvj01.FormMagic); e != nil {
+		return e
+	}
+
+	info, e := os.Stat(name)
+	if e != nil {
+		return e
+	}
+
+	if e := c.backupFile(name, info.Mode().Perm()); e != nil {
+		return e
+	}
+
+	if e := atomicfile.WriteFile(name, buf.Bytes(), info.Mode().Perm()); e != nil {
+		return e
+	}
+
+	c.repaired += c.found - found
+	return nil
+}
+
+// backupFile copies name to the backup suffix, by streams as worlds could be
+// big.
+func (c *checker) backupFile(name string, perm os.FileMode) error {
+	if c.backup == "" {
+		return nil
+	}
+
+	in, e := os.Open(name)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+
+	out, e := os.OpenFile(name+c.backup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if e != nil {
+		return e
+	}
+
+	if _, e := io.Copy(out, in); e != nil {
+		out.Close()
+		return e
+	}
+
+	return out.Close()
+}
+
+// world scans a world read only, it is only opened to be written if records
+// are repaired.
+func (c *checker) world(name string) error {
+	repaired, fixed, e := c.scanWorld(name)
+	if e != nil || len(repaired) == 0 {
+		return e
+	}
+
+	info, e := os.Stat(name)
+	if e != nil {
+		return e
+	}
+
+	if e := c.backupFile(name, info.Mode().Perm()); e != nil {
+		return e
+	}
+
+	h, e := btreedb5.Load(name)
+	if e != nil {
+		return e
+	}
+
+	for k, v := range repaired {
+		if e := world.Put(h, btreedb5.Key(k), v); e != nil {
+			if re := h.Rollback(); re != nil {
+				log.Printf("%s: rollback: %v\n", name, re)
+			}
+			h.Close()
+			return e
+		}
+	}
+
+	if e := h.Close(); e != nil {
+		return e
+	}
+
+	c.repaired += fixed
+	return nil
+}
+
+// scanWorld returns the repaired records, and the count of strings in them.
+func (c *checker) scanWorld(name string) (map[string][]byte, int, error) {
+	h, e := btreedb5.LoadReadOnly(name)
+	if e != nil {
+		return nil, 0, e
+	}
+	defer h.Close()
+
+	if h.Identifier != world.Identifier {
+		return nil, 0, nil
+	}
+
+	// records are stored after the iteration
+	repaired := map[string][]byte{}
+	fixed := 0
+	var ierr error
+	c.conflict = nil
+
+	e = h.Ascend(func(key btreedb5.Key, data []byte) {
+		typ, x, y := world.ParseKey(key)
+		if ierr != nil || (typ != world.MetadataType && typ != world.EntitySectorType) {
+			return
+		}
+
+		raw, e := world.Decompress(data)
+		if e != nil {
+			ierr = e
+			return
+		}
+
+		found := c.found
+		buf := &bytes.Buffer{}
+
+		switch typ {
+		case world.MetadataType:
+			m := &world.Metadata{}
+			if e = m.Read(bytes.NewReader(raw)); e != nil {
+				ierr = e
+				return
+			}
+
+			m.Hdr = c.hdr("metadata", m.Hdr)
+			m.Body = c.value("metadata", "$", m.Body)
+
+			e = m.Write(buf)
+		case world.EntitySectorType:
+			var l world.Entities
+			l, e = world.ReadEntities(raw)
+			if e != nil {
+				ierr = e
+				return
+			}
+
+			where := fmt.Sprintf("entities %d,%d", x, y)
+			for i := range l {
+				w := fmt.Sprintf("%s[%d]", where, i)
+				l[i].Hdr = c.hdr(w, l[i].Hdr)
+				l[i].Body = c.value(w, "$", l[i].Body)
+			}
+
+			e = l.Write(buf)
+		}
+
+		if e != nil {
+			ierr = e
+			return
+		}
+
+		// nothing is written, but the scan goes on without -r
+		if c.repair && c.conflict != nil {
+			ierr = c.conflict
+			return
+		}
+
+		if c.repair && c.found != found {
+			repaired[string(key)] = buf.Bytes()
+			fixed += c.found - found
+		}
+	})
+	if e != nil {
+		return nil, 0, e
+	}
+	if ierr != nil {
+		return nil, 0, ierr
+	}
+	if c.conflict != nil {
+		return nil, 0, c.conflict
+	}
+
+	return repaired, fixed, nil
+}
+
+// check dispatches a file by its magic, other files are skipped.
+func (c *checker) check(name string) error {
+	f, e := os.Open(name)
+	if e != nil {
+		return e
+	}
+
+	magic := make([]byte, 8)
+	n, e := io.ReadFull(bufio.NewReader(f), magic)
+	f.Close()
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return e
+	}
+	magic = magic[:n]
+
+	c.file = name
+
+	switch detect.Magic(magic) {
+	case detect.VJMagic:
+		return c.vj(name)
+	case detect.BTreeDB5:
+		return c.world(name)
+	default:
+		return nil
+	}
+}
+
+func main() {
+	var in, sub, backup string
+	var repair bool
+	flag.StringVar(&in, "i", "storage", "file or directory to scan")
+	flag.BoolVar(&repair, "r", false, "repair the invalid strings in place")
+	flag.StringVar(&sub, "s", data_types.Substitute, "substitute of invalid bytes when repairing")
+	flag.StringVar(&backup, "b", ".bak", "suffix of the backup of a repaired file, world or SBVJ01, empty for none")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	if !data_types.ValidUTF8(sub) {
+		log.Fatalf("substitute %q is not valid utf8\n", sub)
+	}
+
+	c := &checker{repair: repair, sub: sub, backup: backup}
+	failed := false
+
+	e := filepath.Walk(in, func(file string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+
+		// backups are left as they are
+		if info.IsDir() || (backup != "" && strings.HasSuffix(file, backup)) {
+			return nil
+		}
+
+		if e := c.check(file); e != nil {
+			log.Printf("%s: %v\n", file, e)
+			failed = true
+		}
+		return nil
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	if repair {
+		if c.repaired != 0 {
+			log.Printf("%d invalid strings repaired\n", c.repaired)
+		}
+		if c.found != c.repaired {
+			log.Printf("%d invalid strings left\n", c.found-c.repaired)
+		}
+	} else if c.found != 0 {
+		log.Printf("%d invalid strings\n", c.found)
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
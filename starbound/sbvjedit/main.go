@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/atomicfile"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+)
+
+// decode reads the whole data in the form.
+func decode(data []byte, form sbvj01.Form) (*sbvj01.VersionedJson, error) {
+	rd := bytes.NewReader(data)
+
+	vj, e := sbvj01.ReadVersioned(rd, form)
+	if e != nil {
+		return nil, fmt.Errorf("%s", sbvj01.ErrorContext(e, data, 0))
+	}
+
+	if rd.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes as %s", rd.Len(), form)
+	}
+
+	return vj, nil
+}
+
+// edit opens name in the editor, and waits for it.
+func edit(editor, name string) error {
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return fmt.Errorf("no editor, set $EDITOR or -e")
+	}
+
+	cmd := exec.Command(args[0], append(args[1:], name)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// ask asks a yes or no question, yes by default.
+func ask(rd *bufio.Reader, q string) bool {
+	fmt.Fprintf(os.Stderr, "%s [Y/n] ", q)
+
+	l, e := rd.ReadString('\n')
+	if e != nil && l == "" {
+		return false
+	}
+
+	l = strings.ToLower(strings.TrimSpace(l))
+	return l == "" || l == "y" || l == "yes"
+}
+
+// encode parses the edited json, and returns the new file.
+func encode(data []byte, vj *sbvj01.VersionedJson, form sbvj01.Form) ([]byte, error) {
+	doc, e := assetjson.Parse(data)
+	if e != nil {
+		return nil, e
+	}
+
+	content, e := sbvj01.Unannotate(doc)
+	if e != nil {
+		return nil, fmt.Errorf("content%v", e)
+	}
+
+	buf := &bytes.Buffer{}
+	r := &sbvj01.VersionedJson{VerJsonHdr: vj.VerJsonHdr, Content: content}
+	if e := sbvj01.WriteVersioned(buf, r, form); e != nil {
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+func main() {
+	var mode, editor, backup string
+	flag.StringVar(&mode, "m", "", "vjmagic/vj/raw, detected if empty")
+	flag.StringVar(&editor, "e", "", "editor, $VISUAL or $EDITOR by default")
+	flag.StringVar(&backup, "b", ".bak", "suffix of the backup of the original file, empty for none")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: [flags] FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+
+	if editor == "" {
+		editor = os.Getenv("VISUAL")
+	}
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	info, e := os.Stat(name)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	orig, e := ioutil.ReadFile(name)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	var vj *sbvj01.VersionedJson
+	var form sbvj01.Form
+	if mode == "" {
+		info, e := detect.Detect(orig)
+		if e != nil {
+			log.Fatalf("%s: %v\n", name, e)
+		}
+
+		var ok bool
+		if form, ok = info.Kind.Form(); !ok {
+			log.Fatalf("%s is %s, not a versioned json, use -m to force a form\n", name, info)
+		}
+	} else {
+		form, e = sbvj01.ParseForm(mode)
+		if e != nil {
+			log.Fatalln(e)
+		}
+	}
+
+	vj, e = decode(orig, form)
+	if e != nil {
+		log.Fatalf("%s: %v\n", name, e)
+	}
+
+	// the header is kept, only the content is edited
+	buf := &bytes.Buffer{}
+	if form == sbvj01.FormRaw {
+		fmt.Fprintf(buf, "// %s, %s. the content is annotated json, see dumpsbvj01 -a.\n", name, form)
+	} else {
+		version := "unversioned"
+		if vj.Versioned {
+			version = fmt.Sprintf("version %d", vj.Version)
+		}
+		fmt.Fprintf(buf, "// %s, %s, id %q, %s. the header is kept.\n", name, form, string(vj.Id), version)
+		fmt.Fprintf(buf, "// the content is annotated json, see dumpsbvj01 -a.\n")
+	}
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if e := enc.Encode(sbvj01.Annotate(vj.Content)); e != nil {
+		log.Fatalln(e)
+	}
+	dumped := buf.Bytes()
+
+	tmp, e := ioutil.TempFile("", "sbvjedit-*.json")
+	if e != nil {
+		log.Fatalln(e)
+	}
+	tmpname := tmp.Name()
+
+	_, e = tmp.Write(dumped)
+	if e2 := tmp.Close(); e == nil {
+		e = e2
+	}
+	if e != nil {
+		os.Remove(tmpname)
+		log.Fatalln(e)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	var out []byte
+	for {
+		if e := edit(editor, tmpname); e != nil {
+			log.Fatalf("%s: %v, the edit is kept in %s\n", editor, e, tmpname)
+		}
+
+		edited, e := ioutil.ReadFile(tmpname)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		if bytes.Equal(edited, dumped) {
+			os.Remove(tmpname)
+			log.Println("no change")
+			return
+		}
+
+		out, e = encode(edited, vj, form)
+		if e == nil {
+			break
+		}
+
+		log.Println(e)
+		if !ask(stdin, "edit again?") {
+			log.Fatalf("%s is not written, the edit is kept in %s\n", name, tmpname)
+		}
+	}
+
+	if bytes.Equal(out, orig) {
+		os.Remove(tmpname)
+		log.Println("no change")
+		return
+	}
+
+	if backup != "" {
+		if e := ioutil.WriteFile(name+backup, orig, info.Mode().Perm()); e != nil {
+			log.Fatalf("%v, the edit is kept in %s\n", e, tmpname)
+		}
+	}
+
+	if e := atomicfile.WriteFile(name, out, info.Mode().Perm()); e != nil {
+		log.Fatalf("%v, the edit is kept in %s\n", e, tmpname)
+	}
+
+	os.Remove(tmpname)
+}
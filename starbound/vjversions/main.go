@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/detect"
+	"github.com/xhebox/sbutils/lib/migrate"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// Record is one versioned json header found in a file.
+type Record struct {
+	File      string `json:"file"`
+	Key       string `json:"key"`
+	Id        string `json:"id"`
+	Versioned bool   `json:"versioned"`
+	Version   int32  `json:"version"`
+	Status    string `json:"status"`
+}
+
+func record(file, key string, hdr sbvj01.VerJsonHdr) Record {
+	return Record{
+		File:      file,
+		Key:       key,
+		Id:        string(hdr.Id),
+		Versioned: hdr.Versioned,
+		Version:   hdr.Version,
+		Status:    migrate.StatusOf(hdr).String(),
+	}
+}
+
+// scanWorld lists the metadata and entity headers of a world.
+func scanWorld(file, rel string) ([]Record, error) {
+	h, e := btreedb5.LoadReadOnly(file)
+	if e != nil {
+		return nil, e
+	}
+	defer h.Close()
+
+	if h.Identifier != world.Identifier {
+		return nil, nil
+	}
+
+	r := []Record{}
+
+	m, e := world.LoadMetadata(h)
+	if e != nil {
+		return nil, e
+	}
+	r = append(r, record(rel, "metadata", m.Hdr))
+
+	e = h.AscendRange(btreedb5.Key{world.EntitySectorType}, btreedb5.Key{world.EntitySectorType + 1}, func(key btreedb5.Key, data []byte) {
+		raw, e := world.Decompress(data)
+		if e != nil {
+			log.Printf("%s %x: %v\n", rel, key, e)
+			return
+		}
+
+		l, e := world.ReadEntities(raw)
+		if e != nil {
+			log.Printf("%s %x: %v\n", rel, key, e)
+			return
+		}
+
+		x, y := int(key[1])<<8|int(key[2]), int(key[3])<<8|int(key[4])
+		for _, v := range l {
+			r = append(r, record(rel, fmt.Sprintf("%d,%d", x, y), v.Hdr))
+		}
+	})
+
+	return r, e
+}
+
+// scan reads the headers of a file, nothing if it has none.
+func scan(file, rel string) ([]Record, error) {
+	f, e := os.Open(file)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+
+	magic, e := rd.Peek(8)
+	if e != nil && e != io.EOF {
+		return nil, e
+	}
+
+	switch detect.Magic(magic) {
+	case detect.VJMagic:
+		rd.Discard(len(sbvj01.Magic))
+
+		hdr, e := sbvj01.ReadHdr(rd)
+		if e != nil {
+			return nil, e
+		}
+
+		return []Record{record(rel, "", hdr)}, nil
+	case detect.BTreeDB5:
+		f.Close()
+		return scanWorld(file, rel)
+	default:
+		return nil, nil
+	}
+}
+
+// group is the records of one identifier and version
+type group struct {
+	Record
+	count int
+	files map[string]bool
+}
+
+func main() {
+	var dir string
+	var list, js bool
+	flag.StringVar(&dir, "d", "storage", "directory to scan, like storage or universe")
+	flag.BoolVar(&list, "l", false, "list every record instead of counting them")
+	flag.BoolVar(&js, "j", false, "output json")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	records := []Record{}
+
+	e := filepath.Walk(dir, func(file string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, e := filepath.Rel(dir, file)
+		if e != nil {
+			rel = file
+		}
+
+		r, e := scan(file, rel)
+		if e != nil {
+			log.Printf("%s: %v\n", rel, e)
+			return nil
+		}
+
+		records = append(records, r...)
+		return nil
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	if js && list {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if e := enc.Encode(records); e != nil {
+			log.Fatalln(e)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	defer tw.Flush()
+
+	if list {
+		fmt.Fprintln(tw, "file\tkey\tid\tversion\tstatus")
+		for _, v := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.File, v.Key, v.Id, version(v), v.Status)
+		}
+		return
+	}
+
+	groups := map[[2]interface{}]*group{}
+	for _, v := range records {
+		k := [2]interface{}{v.Id, version(v)}
+
+		g, ok := groups[k]
+		if !ok {
+			g = &group{Record: v, files: map[string]bool{}}
+			g.File, g.Key = "", ""
+			groups[k] = g
+		}
+
+		g.count++
+		g.files[v.File] = true
+	}
+
+	l := make([]*group, 0, len(groups))
+	for _, v := range groups {
+		l = append(l, v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Id != l[j].Id {
+			return l[i].Id < l[j].Id
+		}
+		return l[i].Version < l[j].Version
+	})
+
+	if js {
+		doc := []map[string]interface{}{}
+		for _, v := range l {
+			files := []string{}
+			for k := range v.files {
+				files = append(files, k)
+			}
+			sort.Strings(files)
+
+			doc = append(doc, map[string]interface{}{
+				"id":        v.Id,
+				"versioned": v.Versioned,
+				"version":   v.Version,
+				"status":    v.Status,
+				"count":     v.count,
+				"files":     files,
+			})
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if e := enc.Encode(doc); e != nil {
+			log.Fatalln(e)
+		}
+		return
+	}
+
+	fmt.Fprintln(tw, "id\tversion\tstatus\tlatest\tcount\tfiles")
+	for _, v := range l {
+		latest := "-"
+		if n, ok := migrate.Latest(v.Id); ok {
+			latest = fmt.Sprint(n)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\n", v.Id, version(v.Record), v.Status, latest, v.count, len(v.files))
+	}
+}
+
+func version(r Record) string {
+	if !r.Versioned {
+		return "-"
+	}
+	return fmt.Sprint(r.Version)
+}
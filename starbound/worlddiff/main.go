@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// Change is one entry of a patch, ops are put/remove/json/tiles.
+type Change struct {
+	Key   string          `json:"key"`
+	Op    string          `json:"op"`
+	Data  []byte          `json:"data,omitempty"`
+	Patch jsonpatch.Patch `json:"patch,omitempty"`
+	Tiles []TileChange    `json:"tiles,omitempty"`
+}
+
+// TileChange is a tile at x, y inside the sector.
+type TileChange struct {
+	X    int        `json:"x"`
+	Y    int        `json:"y"`
+	Tile world.Tile `json:"tile"`
+}
+
+type Summary struct {
+	Added   map[string]int
+	Removed map[string]int
+	Changed map[string]int
+	Tiles   int
+}
+
+func kind(key btreedb5.Key) string {
+	if len(key) != world.KeySize {
+		return "record"
+	}
+
+	switch key[0] {
+	case world.MetadataType:
+		return "metadata"
+	case world.TileSectorType:
+		return "tiles"
+	case world.EntitySectorType:
+		return "entities"
+	case world.SectorUniqueType:
+		return "uniques"
+	case world.UniqueIndexType:
+		return "index"
+	default:
+		return "record"
+	}
+}
+
+func name(key btreedb5.Key) string {
+	k := kind(key)
+	switch k {
+	case "tiles", "entities", "uniques":
+		_, x, y := world.ParseKey(key)
+		return fmt.Sprintf("%s %d,%d", k, x, y)
+	case "metadata":
+		return k
+	default:
+		return fmt.Sprintf("%s %s", k, hex.EncodeToString(key))
+	}
+}
+
+func records(h *btreedb5.BTreeDB5) (map[string][]byte, error) {
+	r := map[string][]byte{}
+	e := h.Ascend(func(key btreedb5.Key, data []byte) {
+		r[string(key)] = data
+	})
+	return r, e
+}
+
+// document decodes records that are json, nil otherwise
+func document(key btreedb5.Key, data []byte) interface{} {
+	switch kind(key) {
+	case "metadata":
+		m := &world.Metadata{}
+		if m.Read(bytes.NewReader(data)) == nil {
+			return m.Document()
+		}
+	case "entities":
+		if l, e := world.ReadEntities(data); e == nil {
+			return l.Document()
+		}
+	}
+	return nil
+}
+
+func diffTiles(a, b *world.TileSector) []TileChange {
+	r := []TileChange{}
+	for y := 0; y < world.SectorSize; y++ {
+		for x := 0; x < world.SectorSize; x++ {
+			if *a.Tile(x, y) != *b.Tile(x, y) {
+				r = append(r, TileChange{X: x, Y: y, Tile: *b.Tile(x, y)})
+			}
+		}
+	}
+	return r
+}
+
+func diff(key btreedb5.Key, a, b []byte) (*Change, error) {
+	c := &Change{Key: hex.EncodeToString(key)}
+
+	if b == nil {
+		c.Op = "remove"
+		return c, nil
+	}
+
+	rb, e := world.Decompress(b)
+	if e != nil {
+		return nil, e
+	}
+
+	if a == nil {
+		c.Op = "put"
+		c.Data = rb
+		return c, nil
+	}
+
+	ra, e := world.Decompress(a)
+	if e != nil {
+		return nil, e
+	}
+
+	if bytes.Equal(ra, rb) {
+		return nil, nil
+	}
+
+	if kind(key) == "tiles" {
+		sa, ea := world.ReadTileSector(ra)
+		sb, eb := world.ReadTileSector(rb)
+		if ea == nil && eb == nil && sa.Generation == sb.Generation && sa.Version == sb.Version {
+			c.Op = "tiles"
+			c.Tiles = diffTiles(sa, sb)
+			return c, nil
+		}
+	}
+
+	if da, db := document(key, ra), document(key, rb); da != nil && db != nil {
+		c.Op = "json"
+		c.Patch = jsonpatch.Diff(da, db)
+		if len(c.Patch) == 0 {
+			return nil, nil
+		}
+		return c, nil
+	}
+
+	c.Op = "put"
+	c.Data = rb
+	return c, nil
+}
+
+func Diff(ha, hb *btreedb5.BTreeDB5) ([]*Change, error) {
+	ra, e := records(ha)
+	if e != nil {
+		return nil, e
+	}
+
+	rb, e := records(hb)
+	if e != nil {
+		return nil, e
+	}
+
+	keys := []string{}
+	for k := range ra {
+		keys = append(keys, k)
+	}
+	for k := range rb {
+		if _, ok := ra[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	r := []*Change{}
+	for _, k := range keys {
+		c, e := diff(btreedb5.Key(k), ra[k], rb[k])
+		if e != nil {
+			return nil, errors.Wrapf(e, "%s", name(btreedb5.Key(k)))
+		}
+
+		if c != nil {
+			r = append(r, c)
+		}
+	}
+
+	return r, nil
+}
+
+func tileFields(a, b world.Tile) string {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	t := va.Type()
+
+	r := []string{}
+	for k := 0; k < t.NumField(); k++ {
+		x := va.Field(k).Interface()
+		y := vb.Field(k).Interface()
+		if x != y {
+			r = append(r, fmt.Sprintf("%s %v -> %v", t.Field(k).Tag.Get("json"), x, y))
+		}
+	}
+	return strings.Join(r, ", ")
+}
+
+func report(wt io.Writer, ha *btreedb5.BTreeDB5, changes []*Change, verbose bool) error {
+	s := Summary{Added: map[string]int{}, Removed: map[string]int{}, Changed: map[string]int{}}
+
+	for _, c := range changes {
+		key, e := hex.DecodeString(c.Key)
+		if e != nil {
+			return e
+		}
+
+		switch c.Op {
+		case "remove":
+			s.Removed[kind(key)]++
+			fmt.Fprintf(wt, "- %s\n", name(key))
+		case "put":
+			if _, e := ha.Get(key); e != nil {
+				s.Added[kind(key)]++
+				fmt.Fprintf(wt, "+ %s\n", name(key))
+			} else {
+				s.Changed[kind(key)]++
+				fmt.Fprintf(wt, "~ %s\n", name(key))
+			}
+		case "json":
+			s.Changed[kind(key)]++
+			fmt.Fprintf(wt, "~ %s: %d changes\n", name(key), len(c.Patch))
+
+			if !verbose {
+				continue
+			}
+
+			for _, o := range c.Patch {
+				if o.Op == "remove" {
+					fmt.Fprintf(wt, "\t%s %s\n", o.Op, o.Path)
+					continue
+				}
+
+				v, e := json.Marshal(o.Value)
+				if e != nil {
+					return e
+				}
+
+				fmt.Fprintf(wt, "\t%s %s %s\n", o.Op, o.Path, v)
+			}
+		case "tiles":
+			s.Changed[kind(key)]++
+			s.Tiles += len(c.Tiles)
+			fmt.Fprintf(wt, "~ %s: %d tiles\n", name(key), len(c.Tiles))
+
+			if !verbose {
+				continue
+			}
+
+			old, e := world.Get(ha, key)
+			if e != nil {
+				return e
+			}
+
+			sector, e := world.ReadTileSector(old)
+			if e != nil {
+				return e
+			}
+
+			_, sx, sy := world.ParseKey(key)
+			for _, t := range c.Tiles {
+				fmt.Fprintf(wt, "\t%d,%d %s\n",
+					int(sx)*world.SectorSize+t.X, int(sy)*world.SectorSize+t.Y,
+					tileFields(*sector.Tile(t.X, t.Y), t.Tile))
+			}
+		}
+	}
+
+	kinds := map[string]bool{}
+	for _, m := range []map[string]int{s.Added, s.Removed, s.Changed} {
+		for k := range m {
+			kinds[k] = true
+		}
+	}
+
+	names := []string{}
+	for k := range kinds {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(wt, "\nsummary:\n")
+	for _, k := range names {
+		fmt.Fprintf(wt, "\t%s: %d added, %d removed, %d changed\n", k, s.Added[k], s.Removed[k], s.Changed[k])
+	}
+	fmt.Fprintf(wt, "\ttiles changed: %d\n", s.Tiles)
+
+	return nil
+}
+
+func apply(h *btreedb5.BTreeDB5, c *Change) error {
+	key, e := hex.DecodeString(c.Key)
+	if e != nil {
+		return e
+	}
+
+	switch c.Op {
+	case "remove":
+		return h.Remove(key)
+	case "put":
+		return world.Put(h, key, c.Data)
+	case "tiles":
+		data, e := world.Get(h, key)
+		if e != nil {
+			return e
+		}
+
+		sector, e := world.ReadTileSector(data)
+		if e != nil {
+			return e
+		}
+
+		for _, t := range c.Tiles {
+			if t.X < 0 || t.X >= world.SectorSize || t.Y < 0 || t.Y >= world.SectorSize {
+				return errors.Errorf("tile %d,%d out of sector", t.X, t.Y)
+			}
+
+			*sector.Tile(t.X, t.Y) = t.Tile
+		}
+
+		data, e = sector.Bytes()
+		if e != nil {
+			return e
+		}
+
+		return world.Put(h, key, data)
+	case "json":
+		data, e := world.Get(h, key)
+		if e != nil {
+			return e
+		}
+
+		doc := document(key, data)
+		if doc == nil {
+			return errors.New("not a json record")
+		}
+
+		doc, e = c.Patch.Apply(doc)
+		if e != nil {
+			return e
+		}
+
+		buf := &bytes.Buffer{}
+		switch kind(key) {
+		case "metadata":
+			m := &world.Metadata{}
+			if e := m.SetDocument(doc); e != nil {
+				return e
+			}
+
+			if e := m.Write(buf); e != nil {
+				return e
+			}
+		case "entities":
+			var l world.Entities
+			if e := l.SetDocument(doc); e != nil {
+				return e
+			}
+
+			if e := l.Write(buf); e != nil {
+				return e
+			}
+		}
+
+		return world.Put(h, key, buf.Bytes())
+	default:
+		return errors.Errorf("unknown op %s", c.Op)
+	}
+}
+
+func main() {
+	var a, b, mode, out, patch string
+	var verbose bool
+	flag.StringVar(&a, "a", "old", "old world file, or the world to patch")
+	flag.StringVar(&b, "b", "new", "new world file")
+	flag.StringVar(&mode, "m", "report", "report/patch/apply")
+	flag.StringVar(&out, "o", "stdout", "output report or patch")
+	flag.StringVar(&patch, "f", "patch", "patch file to apply")
+	flag.BoolVar(&verbose, "v", false, "also show changed tiles and json")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	var outwt io.Writer
+	if out == "stdout" {
+		outwt = os.Stdout
+	} else {
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		defer f.Close()
+
+		outwt = f
+	}
+
+	// only the world to patch is written, diffing never touches the files
+	var ha *btreedb5.BTreeDB5
+	var e error
+	if mode == "apply" {
+		ha, e = btreedb5.Load(a)
+	} else {
+		ha, e = btreedb5.LoadReadOnly(a)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer ha.Close()
+
+	if mode == "apply" {
+		f, e := os.Open(patch)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		changes := []*Change{}
+
+		d := json.NewDecoder(f)
+		d.UseNumber()
+		if e := d.Decode(&changes); e != nil {
+			log.Fatalln(e)
+		}
+		f.Close()
+
+		for _, c := range changes {
+			if e := apply(ha, c); e != nil {
+				log.Fatalf("%s: %+v\n", c.Key, e)
+			}
+		}
+
+		return
+	}
+
+	hb, e := btreedb5.LoadReadOnly(b)
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer hb.Close()
+
+	changes, e := Diff(ha, hb)
+	if e != nil {
+		log.Fatalf("%+v\n", e)
+	}
+
+	switch mode {
+	case "report":
+		if e := report(outwt, ha, changes, verbose); e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+	case "patch":
+		r, e := json.MarshalIndent(changes, "", "\t")
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		if _, e := outwt.Write(r); e != nil {
+			log.Fatalln(e)
+		}
+	default:
+		log.Fatalf("unknown mode %s\n", mode)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// shortcuts for commonly edited properties
+var aliases = map[string]string{
+	"size":       "/size",
+	"spawn":      "/body/playerStart",
+	"properties": "/body/worldProperties",
+	"protected":  "/body/protectedDungeonIds",
+	"gravity":    "/body/worldTemplate/worldParameters/gravity",
+}
+
+func main() {
+	var in, mode, path, value, patch string
+	flag.StringVar(&in, "i", "input", "world file")
+	flag.StringVar(&mode, "m", "get", "get/set/patch/merge")
+	flag.StringVar(&path, "p", "", "json pointer, or one of size/spawn/properties/protected/gravity")
+	flag.StringVar(&value, "v", "null", "json value to set")
+	flag.StringVar(&patch, "f", "patch", "json patch(patch) or merge patch(merge) file")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	if p, ok := aliases[path]; ok {
+		path = p
+	}
+
+	ptr, e := jsonpatch.ParsePointer(path)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	var h *btreedb5.BTreeDB5
+	if mode == "get" {
+		h, e = btreedb5.LoadReadOnly(in)
+	} else {
+		h, e = btreedb5.Load(in)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	m, e := world.LoadMetadata(h)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	var doc interface{} = m.Document()
+
+	switch mode {
+	case "get":
+		v, e := jsonpatch.Get(doc, ptr)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		out, e := json.MarshalIndent(v, "", "\t")
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		os.Stdout.Write(append(out, '\n'))
+		return
+	case "set":
+		d := json.NewDecoder(bytes.NewReader([]byte(value)))
+		d.UseNumber()
+
+		var v interface{}
+		if e := d.Decode(&v); e != nil {
+			log.Fatalln(e)
+		}
+
+		doc, e = jsonpatch.Set(doc, ptr, v)
+		if e != nil {
+			log.Fatalln(e)
+		}
+	case "patch":
+		f, e := os.Open(patch)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		p, e := jsonpatch.Decode(f)
+		f.Close()
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		doc, e = p.Apply(doc)
+		if e != nil {
+			log.Fatalln(e)
+		}
+	case "merge":
+		fc, e := ioutil.ReadFile(patch)
+		if e != nil {
+			log.Fatalln(e)
+		}
+
+		d := json.NewDecoder(bytes.NewReader(fc))
+		d.UseNumber()
+
+		var p interface{}
+		if e := d.Decode(&p); e != nil {
+			log.Fatalln(e)
+		}
+
+		doc = jsonpatch.MergePatch(doc, p)
+	default:
+		log.Fatalf("unknown mode %s\n", mode)
+	}
+
+	if e := m.SetDocument(doc); e != nil {
+		log.Fatalln(e)
+	}
+
+	if e := m.Store(h); e != nil {
+		log.Fatalf("%+v\n", e)
+	}
+}
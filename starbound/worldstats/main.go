@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+// categories in output order
+var categories = []string{
+	"sectors",
+	"foreground",
+	"background",
+	"foregroundMod",
+	"backgroundMod",
+	"liquid",
+	"object",
+}
+
+type region [2]int
+
+// stats is category -> id -> count, liquid counts are volumes
+type stats map[string]map[string]float64
+
+func (s stats) add(cat, id string, n float64) {
+	m, ok := s[cat]
+	if !ok {
+		m = map[string]float64{}
+		s[cat] = m
+	}
+	m[id] += n
+}
+
+func material(id uint16) string {
+	switch id {
+	case world.EmptyMaterial:
+		return "empty"
+	case world.NullMaterial:
+		return "null"
+	case world.StructureMaterial:
+		return "structure"
+	default:
+		return strconv.Itoa(int(id))
+	}
+}
+
+type row struct {
+	region   region
+	category string
+	id       string
+	value    float64
+}
+
+// rows flattens the stats, regions and categories are in order, ids are
+// sorted by descending value
+func rows(all map[region]stats) []row {
+	regions := make([]region, 0, len(all))
+	for k := range all {
+		regions = append(regions, k)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i][1] != regions[j][1] {
+			return regions[i][1] < regions[j][1]
+		}
+		return regions[i][0] < regions[j][0]
+	})
+
+	r := []row{}
+	for _, reg := range regions {
+		for _, cat := range categories {
+			m := all[reg][cat]
+
+			l := make([]row, 0, len(m))
+			for id, v := range m {
+				l = append(l, row{reg, cat, id, v})
+			}
+			sort.Slice(l, func(i, j int) bool {
+				if l[i].value != l[j].value {
+					return l[i].value > l[j].value
+				}
+				return l[i].id < l[j].id
+			})
+
+			r = append(r, l...)
+		}
+	}
+
+	return r
+}
+
+func value(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeTable(wt io.Writer, l []row, grid int) error {
+	tw := tabwriter.NewWriter(wt, 0, 8, 2, ' ', 0)
+
+	var last row
+	for k, v := range l {
+		if k == 0 || v.region != last.region {
+			if k != 0 {
+				fmt.Fprintln(tw)
+			}
+			if grid > 0 {
+				x, y := v.region[0]*grid*world.SectorSize, v.region[1]*grid*world.SectorSize
+				n := grid * world.SectorSize
+				fmt.Fprintf(tw, "region %d,%d-%d,%d\n", x, y, x+n, y+n)
+			}
+		}
+
+		if k == 0 || v.region != last.region || v.category != last.category {
+			fmt.Fprintf(tw, "%s\t\t\n", v.category)
+		}
+
+		fmt.Fprintf(tw, "\t%s\t%s\n", v.id, value(v.value))
+		last = v
+	}
+
+	return tw.Flush()
+}
+
+func writeCSV(wt io.Writer, l []row, grid int) error {
+	w := csv.NewWriter(wt)
+
+	header := []string{"category", "id", "value"}
+	if grid > 0 {
+		header = append([]string{"x", "y"}, header...)
+	}
+	if e := w.Write(header); e != nil {
+		return e
+	}
+
+	for _, v := range l {
+		rec := []string{v.category, v.id, value(v.value)}
+		if grid > 0 {
+			x, y := v.region[0]*grid*world.SectorSize, v.region[1]*grid*world.SectorSize
+			rec = append([]string{strconv.Itoa(x), strconv.Itoa(y)}, rec...)
+		}
+		if e := w.Write(rec); e != nil {
+			return e
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func main() {
+	var in, format, out string
+	var grid int
+	flag.StringVar(&in, "i", "input", "world file")
+	flag.StringVar(&format, "f", "table", "table/csv")
+	flag.StringVar(&out, "o", "stdout", "output file")
+	flag.IntVar(&grid, "g", 0, "also group by regions of gxg sectors, 0 for the whole world")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	var outwt io.Writer
+	if out == "stdout" {
+		outwt = os.Stdout
+	} else {
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		defer f.Close()
+
+		outwt = f
+	}
+
+	h, e := btreedb5.LoadReadOnly(in)
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	all := map[region]stats{}
+	get := func(x, y uint16) stats {
+		var reg region
+		if grid > 0 {
+			reg = region{int(x) / grid, int(y) / grid}
+		}
+
+		s, ok := all[reg]
+		if !ok {
+			s = stats{}
+			all[reg] = s
+		}
+		return s
+	}
+
+	e = h.AscendRange(btreedb5.Key{world.TileSectorType}, btreedb5.Key{world.TileSectorType + 1}, func(key btreedb5.Key, data []byte) {
+		_, x, y := world.ParseKey(key)
+
+		raw, e := world.Decompress(data)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		sec, e := world.ReadTileSector(raw)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		s := get(x, y)
+		if sec.Modified() {
+			s.add("sectors", "modified", 1)
+		} else {
+			s.add("sectors", "generated", 1)
+		}
+
+		for k := range sec.Tiles {
+			t := &sec.Tiles[k]
+
+			s.add("foreground", material(t.Foreground), 1)
+			s.add("background", material(t.Background), 1)
+
+			if t.ForegroundMod != world.NoMod {
+				s.add("foregroundMod", strconv.Itoa(int(t.ForegroundMod)), 1)
+			}
+
+			if t.BackgroundMod != world.NoMod {
+				s.add("backgroundMod", strconv.Itoa(int(t.BackgroundMod)), 1)
+			}
+
+			if t.Liquid != world.EmptyLiquid {
+				s.add("liquid", strconv.Itoa(int(t.Liquid)), float64(t.LiquidLevel))
+			}
+		}
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	e = h.AscendRange(btreedb5.Key{world.EntitySectorType}, btreedb5.Key{world.EntitySectorType + 1}, func(key btreedb5.Key, data []byte) {
+		_, x, y := world.ParseKey(key)
+
+		raw, e := world.Decompress(data)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		l, e := world.ReadEntities(raw)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		s := get(x, y)
+		for _, v := range l {
+			if v.Hdr.Id != "ObjectEntity" {
+				continue
+			}
+
+			name, _ := jsonpatch.Get(v.Body, jsonpatch.Pointer{"name"})
+			s.add("object", fmt.Sprint(name), 1)
+		}
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	l := rows(all)
+
+	switch format {
+	case "table":
+		e = writeTable(outwt, l, grid)
+	case "csv":
+		e = writeCSV(outwt, l, grid)
+	default:
+		log.Fatalf("unknown format %s\n", format)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/sbutils/lib/assetjson"
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/sbvj01"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+const (
+	tilePixels = 8
+	// gids of liquids start after all materials
+	liquidBase = 1 << 16
+)
+
+// sectors caches the tile sectors touched by a map
+type sectors struct {
+	h     *btreedb5.BTreeDB5
+	cache map[[2]int]*world.TileSector
+	dirty map[[2]int]bool
+	// collisions of materials, from assets, or learned from the foreground
+	// of loaded sectors
+	collisions map[uint16]uint8
+}
+
+// maxTile bounds tile coordinates, sectors are indexed by uint16
+const maxTile = (1 << 16) * world.SectorSize
+
+// tile returns nil for coordinates out of the sectors of any world, callers
+// check the size of the world
+func (s *sectors) tile(x, y int) *world.Tile {
+	if x < 0 || y < 0 || x >= maxTile || y >= maxTile {
+		return nil
+	}
+
+	k := [2]int{x / world.SectorSize, y / world.SectorSize}
+
+	sector, ok := s.cache[k]
+	if !ok {
+		var e error
+		sector, e = world.LoadTileSector(s.h, uint16(k[0]), uint16(k[1]))
+		if e != nil {
+			log.Printf("tiles %d,%d: %v\n", k[0], k[1], e)
+			sector = nil
+		}
+		s.cache[k] = sector
+
+		// sectors are loaded before any of their tiles is changed, export
+		// does not need collisions
+		if sector != nil && s.collisions != nil {
+			for _, t := range sector.Tiles {
+				if _, ok := s.collisions[t.Foreground]; !ok && t.Foreground != world.EmptyMaterial {
+					s.collisions[t.Foreground] = t.Collision
+				}
+			}
+		}
+	}
+
+	if sector == nil {
+		return nil
+	}
+
+	return sector.Tile(x%world.SectorSize, y%world.SectorSize)
+}
+
+func (s *sectors) touch(x, y int) {
+	s.dirty[[2]int{x / world.SectorSize, y / world.SectorSize}] = true
+}
+
+func (s *sectors) collision(id uint16) uint8 {
+	c, ok := s.collisions[id]
+	if !ok {
+		log.Printf("collision of material %d unknown, use block\n", id)
+		c = world.CollisionBlock
+		s.collisions[id] = c
+	}
+	return c
+}
+
+func (s *sectors) store() error {
+	for k := range s.dirty {
+		if e := s.cache[k].Store(s.h, uint16(k[0]), uint16(k[1])); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func materialGid(id uint16) uint32 {
+	if id == world.EmptyMaterial {
+		return 0
+	}
+	return uint32(id) + 1
+}
+
+func liquidGid(id uint8) uint32 {
+	if id == world.EmptyLiquid {
+		return 0
+	}
+	return liquidBase + uint32(id)
+}
+
+func tilesetName(gid uint32) string {
+	if gid >= liquidBase {
+		return fmt.Sprintf("liquid_%d", gid-liquidBase)
+	}
+	return fmt.Sprintf("material_%d", gid-1)
+}
+
+// writeTileset writes a one tile tileset and a placeholder image for it
+func writeTileset(dir string, gid uint32) error {
+	name := tilesetName(gid)
+
+	img := image.NewRGBA(image.Rect(0, 0, tilePixels, tilePixels))
+	c := color.RGBA{R: uint8(gid * 97), G: uint8(gid * 57), B: uint8(gid * 31), A: 255}
+	if gid >= liquidBase {
+		c.A = 128
+	}
+	for y := 0; y < tilePixels; y++ {
+		for x := 0; x < tilePixels; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	f, e := os.Create(filepath.Join(dir, name+".png"))
+	if e != nil {
+		return e
+	}
+
+	e = png.Encode(f, img)
+	f.Close()
+	if e != nil {
+		return e
+	}
+
+	out, e := xml.MarshalIndent(Tileset{
+		Version:    "1.2",
+		Name:       name,
+		TileWidth:  tilePixels,
+		TileHeight: tilePixels,
+		TileCount:  1,
+		Columns:    1,
+		Image:      Image{Source: name + ".png", Width: tilePixels, Height: tilePixels},
+	}, "", " ")
+	if e != nil {
+		return e
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, name+".tsx"), append([]byte(xml.Header), out...), 0644)
+}
+
+func objects(h *btreedb5.BTreeDB5, r image.Rectangle) []Object {
+	res := []Object{}
+
+	for sy := r.Min.Y / world.SectorSize; sy <= (r.Max.Y-1)/world.SectorSize; sy++ {
+		for sx := r.Min.X / world.SectorSize; sx <= (r.Max.X-1)/world.SectorSize; sx++ {
+			l, e := world.LoadEntities(h, uint16(sx), uint16(sy))
+			if e != nil {
+				continue
+			}
+
+			for _, v := range l {
+				if v.Hdr.Id != "ObjectEntity" {
+					continue
+				}
+
+				pos, e := jsonpatch.Get(v.Body, jsonpatch.Pointer{"tilePosition"})
+				if e != nil {
+					continue
+				}
+
+				p, ok := pos.([]interface{})
+				if !ok || len(p) != 2 {
+					continue
+				}
+
+				x, ok1 := p[0].(int64)
+				y, ok2 := p[1].(int64)
+				if !ok1 || !ok2 || !image.Pt(int(x), int(y)).In(r) {
+					continue
+				}
+
+				o := Object{
+					Id:     len(res) + 1,
+					Type:   "object",
+					X:      float64((int(x) - r.Min.X) * tilePixels),
+					Y:      float64((r.Max.Y - 1 - int(y)) * tilePixels),
+					Width:  tilePixels,
+					Height: tilePixels,
+				}
+
+				if name, e := jsonpatch.Get(v.Body, jsonpatch.Pointer{"name"}); e == nil {
+					o.Name = fmt.Sprint(name)
+				}
+
+				for _, k := range []string{"direction", "parameters"} {
+					p, e := jsonpatch.Get(v.Body, jsonpatch.Pointer{k})
+					if e != nil {
+						continue
+					}
+
+					j, e := json.Marshal(p)
+					if e != nil {
+						continue
+					}
+
+					o.Properties = append(o.Properties, Property{Name: k, Value: string(j)})
+				}
+
+				res = append(res, o)
+			}
+		}
+	}
+
+	return res
+}
+
+func export(h *btreedb5.BTreeDB5, r image.Rectangle, out string) error {
+	s := &sectors{h: h, cache: map[[2]int]*world.TileSector{}}
+
+	w, ht := r.Dx(), r.Dy()
+	fg := make([]uint32, w*ht)
+	bg := make([]uint32, w*ht)
+	liquid := make([]uint32, w*ht)
+	used := map[uint32]bool{}
+
+	for row := 0; row < ht; row++ {
+		for col := 0; col < w; col++ {
+			t := s.tile(r.Min.X+col, r.Max.Y-1-row)
+			if t == nil {
+				continue
+			}
+
+			k := row*w + col
+			fg[k] = materialGid(t.Foreground)
+			bg[k] = materialGid(t.Background)
+			liquid[k] = liquidGid(t.Liquid)
+			used[fg[k]] = true
+			used[bg[k]] = true
+			used[liquid[k]] = true
+		}
+	}
+	delete(used, 0)
+
+	m := &Map{
+		Version:     "1.2",
+		Orientation: "orthogonal",
+		RenderOrder: "right-down",
+		Width:       w,
+		Height:      ht,
+		TileWidth:   tilePixels,
+		TileHeight:  tilePixels,
+		Properties: []Property{
+			{Name: "x", Type: "int", Value: strconv.Itoa(r.Min.X)},
+			{Name: "y", Type: "int", Value: strconv.Itoa(r.Min.Y)},
+		},
+	}
+
+	dir := filepath.Join(filepath.Dir(out), "tilesets")
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return e
+	}
+
+	gids := []uint32{}
+	for k := range used {
+		gids = append(gids, k)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+
+	for _, gid := range gids {
+		if e := writeTileset(dir, gid); e != nil {
+			return e
+		}
+
+		m.Tilesets = append(m.Tilesets, TilesetRef{FirstGid: gid, Source: "tilesets/" + tilesetName(gid) + ".tsx"})
+	}
+
+	for _, l := range []struct {
+		name string
+		gids []uint32
+	}{{"background", bg}, {"foreground", fg}, {"liquid", liquid}} {
+		layer := Layer{Name: l.name, Width: w, Height: ht}
+		layer.Data.SetGids(l.gids, w)
+		m.Layers = append(m.Layers, layer)
+	}
+
+	m.ObjectGroups = append(m.ObjectGroups, ObjectGroup{Name: "objects", Objects: objects(h, r)})
+
+	res, e := xml.MarshalIndent(m, "", " ")
+	if e != nil {
+		return e
+	}
+
+	return ioutil.WriteFile(out, append([]byte(xml.Header), res...), 0644)
+}
+
+// resolve turns gids back into material or liquid ids by tileset file names
+func resolve(m *Map) (func(uint32) (string, int, error), error) {
+	type ref struct {
+		first uint32
+		kind  string
+		id    int
+	}
+
+	refs := []ref{}
+	for _, v := range m.Tilesets {
+		name := strings.TrimSuffix(filepath.Base(v.Source), filepath.Ext(v.Source))
+
+		i := strings.LastIndexByte(name, '_')
+		if i == -1 {
+			return nil, errors.Errorf("tileset %s is not material_ID or liquid_ID", v.Source)
+		}
+
+		id, e := strconv.Atoi(name[i+1:])
+		if e != nil {
+			return nil, errors.Errorf("tileset %s is not material_ID or liquid_ID", v.Source)
+		}
+
+		refs = append(refs, ref{first: v.FirstGid, kind: name[:i], id: id})
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].first < refs[j].first })
+
+	return func(gid uint32) (string, int, error) {
+		i := sort.Search(len(refs), func(i int) bool { return refs[i].first > gid }) - 1
+		if i < 0 || refs[i].first != gid {
+			return "", 0, errors.Errorf("gid %d is not the first tile of a tileset", gid)
+		}
+		return refs[i].kind, refs[i].id, nil
+	}, nil
+}
+
+// readMaterials reads materialId and collisionKind of the .material files
+// under an unpacked assets directory, collisionKind is block by default as in
+// the game
+func readMaterials(dir string, r map[uint16]uint8) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+
+		if info.IsDir() || filepath.Ext(name) != ".material" {
+			return nil
+		}
+
+		doc, e := assetjson.ReadFile(name)
+		if e != nil {
+			return e
+		}
+
+		o, ok := doc.(*sbvj01.Object)
+		if !ok {
+			return errors.Errorf("%s is not an object", name)
+		}
+
+		v, _ := o.Get("materialId")
+		n, ok := v.(json.Number)
+		if !ok {
+			return errors.Errorf("%s has no materialId", name)
+		}
+
+		id, e := strconv.ParseUint(string(n), 10, 16)
+		if e != nil {
+			return errors.Wrapf(e, "%s materialId", name)
+		}
+
+		kind := "block"
+		if v, ok := o.Get("collisionKind"); ok {
+			if kind, ok = v.(string); !ok {
+				return errors.Errorf("%s collisionKind is not a string", name)
+			}
+		}
+
+		c, ok := world.CollisionKinds[kind]
+		if !ok {
+			return errors.Errorf("%s has unknown collisionKind %s", name, kind)
+		}
+
+		r[uint16(id)] = c
+		return nil
+	})
+}
+
+func (s *sectors) setMaterial(t *world.Tile, fg bool, id uint16) {
+	mat, mod := &t.Background, &t.BackgroundMod
+	if fg {
+		mat, mod = &t.Foreground, &t.ForegroundMod
+	}
+
+	if *mat == id {
+		return
+	}
+
+	if id == world.EmptyMaterial {
+		*mod = world.NoMod
+		t.DungeonId = world.DestroyedDungeon
+		if fg {
+			t.Collision = world.CollisionNone
+		}
+	} else {
+		t.DungeonId = world.ConstructionDungeon
+		if fg {
+			t.Collision = s.collision(id)
+		}
+	}
+
+	*mat = id
+}
+
+func setLiquid(t *world.Tile, id uint8) {
+	if t.Liquid == id {
+		return
+	}
+
+	if id == world.EmptyLiquid {
+		t.LiquidLevel, t.LiquidPressure, t.LiquidInfinite = 0, 0, false
+	} else if t.Liquid == world.EmptyLiquid {
+		t.LiquidLevel, t.LiquidPressure = 1, 1
+	}
+
+	t.Liquid = id
+}
+
+func load(h *btreedb5.BTreeDB5, in, assets string) error {
+	fc, e := ioutil.ReadFile(in)
+	if e != nil {
+		return e
+	}
+
+	m := &Map{}
+	if e := xml.Unmarshal(fc, m); e != nil {
+		return e
+	}
+
+	px, ok1 := m.Property("x")
+	py, ok2 := m.Property("y")
+	if !ok1 || !ok2 {
+		return errors.New("map has no x/y properties, not exported by worldtmx?")
+	}
+
+	x0, e := strconv.Atoi(px)
+	if e != nil {
+		return e
+	}
+
+	y0, e := strconv.Atoi(py)
+	if e != nil {
+		return e
+	}
+
+	meta, e := world.LoadMetadata(h)
+	if e != nil {
+		return e
+	}
+
+	// every layer is checked before any tile is written
+	bounds := image.Rect(0, 0, int(meta.Width), int(meta.Height))
+	for _, l := range m.Layers {
+		r := image.Rect(x0, y0, x0+l.Width, y0+l.Height)
+		if x0 < 0 || y0 < 0 || l.Width <= 0 || l.Height <= 0 || !r.In(bounds) {
+			return errors.Errorf("layer %s at %d,%d of %dx%d is out of the world of %dx%d", l.Name, x0, y0, l.Width, l.Height, meta.Width, meta.Height)
+		}
+	}
+
+	lookup, e := resolve(m)
+	if e != nil {
+		return e
+	}
+
+	s := &sectors{h: h, cache: map[[2]int]*world.TileSector{}, dirty: map[[2]int]bool{}, collisions: map[uint16]uint8{}}
+
+	if assets != "" {
+		if e := readMaterials(assets, s.collisions); e != nil {
+			return e
+		}
+	}
+
+	for _, l := range m.Layers {
+		if l.Name != "foreground" && l.Name != "background" && l.Name != "liquid" {
+			log.Printf("layer %s ignored\n", l.Name)
+			continue
+		}
+
+		gids, e := l.Data.Gids()
+		if e != nil {
+			return errors.Wrapf(e, "layer %s", l.Name)
+		}
+
+		if len(gids) != l.Width*l.Height {
+			return errors.Errorf("layer %s has %d tiles, not %dx%d", l.Name, len(gids), l.Width, l.Height)
+		}
+
+		for k, gid := range gids {
+			x, y := x0+k%l.Width, y0+l.Height-1-k/l.Width
+
+			t := s.tile(x, y)
+			if t == nil {
+				continue
+			}
+
+			kind, id := "", -1
+			if gid != 0 {
+				kind, id, e = lookup(gid)
+				if e != nil {
+					return errors.Wrapf(e, "layer %s at %d,%d", l.Name, x, y)
+				}
+			}
+
+			old := *t
+
+			switch l.Name {
+			case "foreground", "background":
+				if gid == 0 {
+					id = int(world.EmptyMaterial)
+				} else if kind != "material" || id < 0 || id >= int(world.EmptyMaterial) {
+					return errors.Errorf("layer %s at %d,%d: %s_%d is not a material", l.Name, x, y, kind, id)
+				}
+
+				s.setMaterial(t, l.Name == "foreground", uint16(id))
+			case "liquid":
+				if gid == 0 {
+					id = int(world.EmptyLiquid)
+				} else if kind != "liquid" || id <= 0 || id > 255 {
+					return errors.Errorf("layer %s at %d,%d: %s_%d is not a liquid", l.Name, x, y, kind, id)
+				}
+
+				setLiquid(t, uint8(id))
+			}
+
+			if *t != old {
+				s.touch(x, y)
+			}
+		}
+	}
+
+	log.Printf("%d sectors changed\n", len(s.dirty))
+
+	return s.store()
+}
+
+func main() {
+	var in, mode, file, rect, assets string
+	flag.StringVar(&in, "i", "input", "world file")
+	flag.StringVar(&mode, "m", "export", "export/import")
+	flag.StringVar(&file, "f", "world.tmx", "tmx file to export to or import from")
+	flag.StringVar(&rect, "r", "0,0,64,64", "tile rectangle x0,y0,x1,y1 to export")
+	flag.StringVar(&assets, "a", "", "unpacked assets directory, to read the collision of materials on import")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	// export only reads the world
+	var h *btreedb5.BTreeDB5
+	var e error
+	if mode == "import" {
+		h, e = btreedb5.Load(in)
+	} else {
+		h, e = btreedb5.LoadReadOnly(in)
+	}
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	switch mode {
+	case "export":
+		var x0, y0, x1, y1 int
+		if _, e := fmt.Sscanf(rect, "%d,%d,%d,%d", &x0, &y0, &x1, &y1); e != nil {
+			log.Fatalf("%q is not x0,y0,x1,y1\n", rect)
+		}
+
+		r := image.Rect(x0, y0, x1, y1)
+		if r.Empty() || r.Min.X < 0 || r.Min.Y < 0 {
+			log.Fatalf("invalid rectangle %v\n", r)
+		}
+
+		if e := export(h, r, file); e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+	case "import":
+		if e := load(h, file, assets); e != nil {
+			log.Fatalf("%+v\n", e)
+		}
+	default:
+		log.Fatalf("unknown mode %s\n", mode)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the subset of Tiled's tmx/tsx format used here
+
+const (
+	flipMask = 0xF0000000
+)
+
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:"value,attr"`
+}
+
+type Map struct {
+	XMLName      xml.Name      `xml:"map"`
+	Version      string        `xml:"version,attr"`
+	Orientation  string        `xml:"orientation,attr"`
+	RenderOrder  string        `xml:"renderorder,attr"`
+	Width        int           `xml:"width,attr"`
+	Height       int           `xml:"height,attr"`
+	TileWidth    int           `xml:"tilewidth,attr"`
+	TileHeight   int           `xml:"tileheight,attr"`
+	Properties   []Property    `xml:"properties>property"`
+	Tilesets     []TilesetRef  `xml:"tileset"`
+	Layers       []Layer       `xml:"layer"`
+	ObjectGroups []ObjectGroup `xml:"objectgroup"`
+}
+
+func (m *Map) Property(name string) (string, bool) {
+	for _, v := range m.Properties {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+type TilesetRef struct {
+	FirstGid uint32 `xml:"firstgid,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+type Layer struct {
+	Name   string `xml:"name,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Data   Data   `xml:"data"`
+}
+
+type Data struct {
+	Encoding    string `xml:"encoding,attr,omitempty"`
+	Compression string `xml:"compression,attr,omitempty"`
+	Text        string `xml:",innerxml"`
+}
+
+// Gids decodes csv or base64 layer data, flip flags are dropped.
+func (d *Data) Gids() ([]uint32, error) {
+	var r []uint32
+
+	switch d.Encoding {
+	case "csv":
+		for _, v := range strings.Split(d.Text, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+
+			gid, e := strconv.ParseUint(v, 10, 32)
+			if e != nil {
+				return nil, errors.Wrapf(e, "invalid gid")
+			}
+
+			r = append(r, uint32(gid)&^flipMask)
+		}
+	case "base64":
+		raw, e := base64.StdEncoding.DecodeString(strings.TrimSpace(d.Text))
+		if e != nil {
+			return nil, e
+		}
+
+		var rd io.Reader = bytes.NewReader(raw)
+		switch d.Compression {
+		case "":
+		case "zlib":
+			rd, e = zlib.NewReader(rd)
+		case "gzip":
+			rd, e = gzip.NewReader(rd)
+		default:
+			return nil, errors.Errorf("unsupported compression %s", d.Compression)
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		raw, e = ioutil.ReadAll(rd)
+		if e != nil {
+			return nil, e
+		}
+
+		for k := 0; k+4 <= len(raw); k += 4 {
+			r = append(r, binary.LittleEndian.Uint32(raw[k:])&^flipMask)
+		}
+	default:
+		return nil, errors.Errorf("unsupported encoding %s", d.Encoding)
+	}
+
+	return r, nil
+}
+
+func (d *Data) SetGids(gids []uint32, width int) {
+	var b strings.Builder
+	b.WriteByte('\n')
+	for k, v := range gids {
+		b.WriteString(strconv.FormatUint(uint64(v), 10))
+		if k != len(gids)-1 {
+			b.WriteByte(',')
+		}
+		if (k+1)%width == 0 {
+			b.WriteByte('\n')
+		}
+	}
+	d.Encoding = "csv"
+	d.Compression = ""
+	d.Text = b.String()
+}
+
+type ObjectGroup struct {
+	Name    string   `xml:"name,attr"`
+	Objects []Object `xml:"object"`
+}
+
+type Object struct {
+	Id         int        `xml:"id,attr"`
+	Name       string     `xml:"name,attr"`
+	Type       string     `xml:"type,attr"`
+	X          float64    `xml:"x,attr"`
+	Y          float64    `xml:"y,attr"`
+	Width      float64    `xml:"width,attr"`
+	Height     float64    `xml:"height,attr"`
+	Properties []Property `xml:"properties>property"`
+}
+
+type Tileset struct {
+	XMLName    xml.Name `xml:"tileset"`
+	Version    string   `xml:"version,attr"`
+	Name       string   `xml:"name,attr"`
+	TileWidth  int      `xml:"tilewidth,attr"`
+	TileHeight int      `xml:"tileheight,attr"`
+	TileCount  int      `xml:"tilecount,attr"`
+	Columns    int      `xml:"columns,attr"`
+	Image      Image    `xml:"image"`
+}
+
+type Image struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/jsonpatch"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+type sector [2]int
+
+// rects collects repeated -k flags
+type rects []image.Rectangle
+
+func (r *rects) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *rects) Set(s string) error {
+	var x0, y0, x1, y1 int
+	if _, e := fmt.Sscanf(s, "%d,%d,%d,%d", &x0, &y0, &x1, &y1); e != nil {
+		return fmt.Errorf("%q is not x0,y0,x1,y1", s)
+	}
+	// x1 and y1 are exclusive, as image.Rectangle and worldtmx -r
+	rect := image.Rect(x0, y0, x1, y1)
+	if rect.Empty() {
+		return fmt.Errorf("%q is an empty rectangle", s)
+	}
+
+	*r = append(*r, rect)
+	return nil
+}
+
+type stats struct {
+	kept    map[byte]int
+	removed map[byte]int
+	bytes   int
+}
+
+var names = map[byte]string{
+	world.MetadataType:     "metadata",
+	world.TileSectorType:   "tiles",
+	world.EntitySectorType: "entities",
+	world.SectorUniqueType: "uniques",
+	world.UniqueIndexType:  "index",
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// spawn returns the sector of playerStart
+func spawn(m *world.Metadata) (sector, bool) {
+	v, e := jsonpatch.Get(m.Body, jsonpatch.Pointer{"playerStart"})
+	if e != nil {
+		return sector{}, false
+	}
+
+	pos, ok := v.([]interface{})
+	if !ok || len(pos) != 2 {
+		return sector{}, false
+	}
+
+	x, ok1 := number(pos[0])
+	y, ok2 := number(pos[1])
+	if !ok1 || !ok2 {
+		return sector{}, false
+	}
+
+	return sector{int(x) / world.SectorSize, int(y) / world.SectorSize}, true
+}
+
+// built finds sectors with placed objects, or blocks placed or broken by
+// players
+func built(h *btreedb5.BTreeDB5, objects, tiles bool) map[sector]bool {
+	r := map[sector]bool{}
+
+	e := h.Ascend(func(key btreedb5.Key, data []byte) {
+		typ, x, y := world.ParseKey(key)
+
+		switch {
+		case typ == world.TileSectorType && tiles:
+			raw, e := world.Decompress(data)
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			s, e := world.ReadTileSector(raw)
+			if e != nil {
+				log.Printf("tiles %d,%d: %v\n", x, y, e)
+				return
+			}
+
+			if s.Modified() {
+				r[sector{int(x), int(y)}] = true
+			}
+		case typ == world.EntitySectorType && objects:
+			raw, e := world.Decompress(data)
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			l, e := world.ReadEntities(raw)
+			if e != nil {
+				log.Printf("entities %d,%d: %v\n", x, y, e)
+				return
+			}
+
+			for _, v := range l {
+				if v.Hdr.Id == "ObjectEntity" {
+					r[sector{int(x), int(y)}] = true
+					break
+				}
+			}
+		}
+	})
+	if e != nil {
+		log.Fatalf("%+v\n", e)
+	}
+
+	return r
+}
+
+// compact copies the records to keep into a new file
+func compact(h *btreedb5.BTreeDB5, out string, keep map[sector]bool) (*stats, error) {
+	s := &stats{kept: map[byte]int{}, removed: map[byte]int{}}
+
+	o, e := btreedb5.New(out, h.Identifier, h.BlockSize, h.KeySize)
+	if e != nil {
+		return nil, e
+	}
+	defer o.Close()
+
+	var ierr error
+	cnt := 0
+
+	insert := func(key btreedb5.Key, data []byte) {
+		if ierr != nil {
+			return
+		}
+
+		ierr = o.Insert(append(btreedb5.Key{}, key...), append([]byte{}, data...))
+
+		cnt++
+		if cnt%64 == 0 && ierr == nil {
+			ierr = o.Commit()
+		}
+	}
+
+	e = h.Ascend(func(key btreedb5.Key, data []byte) {
+		typ, x, y := world.ParseKey(key)
+
+		switch typ {
+		case world.TileSectorType, world.EntitySectorType, world.SectorUniqueType:
+			if !keep[sector{int(x), int(y)}] {
+				s.removed[typ]++
+				s.bytes += len(data)
+				return
+			}
+		case world.UniqueIndexType:
+			raw, e := world.Decompress(data)
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			l, e := world.ReadUniqueIndex(raw)
+			if e != nil {
+				log.Printf("index %x: %v, kept as is\n", key, e)
+				break
+			}
+
+			n := world.UniqueIndex{}
+			for _, v := range l {
+				if keep[sector{int(v.SectorX), int(v.SectorY)}] {
+					n = append(n, v)
+				}
+			}
+
+			if len(n) == len(l) {
+				break
+			}
+
+			if len(n) == 0 {
+				s.removed[typ]++
+				s.bytes += len(data)
+				return
+			}
+
+			raw, e = n.Bytes()
+			if e != nil {
+				log.Fatalln(e)
+			}
+
+			data, e = world.Compress(raw)
+			if e != nil {
+				log.Fatalln(e)
+			}
+		}
+
+		s.kept[typ]++
+		insert(key, data)
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	return s, ierr
+}
+
+func main() {
+	var in, out string
+	var keeps rects
+	var objects, tiles, dry bool
+	var radius int
+	flag.StringVar(&in, "i", "input", "world file")
+	flag.StringVar(&out, "o", "output", "trimmed world file")
+	flag.Var(&keeps, "k", "tile rectangle x0,y0,x1,y1 to keep, could be repeated")
+	flag.BoolVar(&objects, "b", true, "keep sectors with placed objects")
+	flag.BoolVar(&tiles, "t", true, "keep sectors with blocks placed or broken by players")
+	flag.IntVar(&radius, "r", 1, "also keep sectors within this radius of spawn and built sectors")
+	flag.BoolVar(&dry, "n", false, "only preview, do not write the output")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	// the output is removed first, so it must not be the input, or a link to it
+	if !dry {
+		if fi, e := os.Stat(in); e == nil {
+			if fo, e := os.Stat(out); e == nil && os.SameFile(fi, fo) {
+				log.Fatalf("%s is the input %s\n", out, in)
+			}
+		}
+	}
+
+	h, e := btreedb5.LoadReadOnly(in)
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	m, e := world.LoadMetadata(h)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	w := (int(m.Width) + world.SectorSize - 1) / world.SectorSize
+	ht := (int(m.Height) + world.SectorSize - 1) / world.SectorSize
+	if w == 0 || ht == 0 {
+		log.Fatalf("world size %dx%d in the metadata\n", m.Width, m.Height)
+	}
+
+	keep := map[sector]bool{}
+
+	// worlds wrap horizontally
+	around := func(c sector) {
+		for y := c[1] - radius; y <= c[1]+radius; y++ {
+			if y < 0 || y >= ht {
+				continue
+			}
+			for x := c[0] - radius; x <= c[0]+radius; x++ {
+				keep[sector{((x % w) + w) % w, y}] = true
+			}
+		}
+	}
+
+	for _, r := range keeps {
+		for y := r.Min.Y / world.SectorSize; y <= (r.Max.Y-1)/world.SectorSize; y++ {
+			for x := r.Min.X / world.SectorSize; x <= (r.Max.X-1)/world.SectorSize; x++ {
+				keep[sector{x, y}] = true
+			}
+		}
+	}
+
+	if c, ok := spawn(m); ok {
+		around(c)
+	}
+
+	for c := range built(h, objects, tiles) {
+		around(c)
+	}
+
+	target := out
+	if dry {
+		f, e := ioutil.TempFile("", "worldtrim")
+		if e != nil {
+			log.Fatalln(e)
+		}
+		target = f.Name()
+		f.Close()
+		defer os.Remove(target)
+	}
+
+	s, e := compact(h, target, keep)
+	if e != nil {
+		log.Fatalf("%+v\n", e)
+	}
+
+	before, e := os.Stat(in)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	after, e := os.Stat(target)
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	r := []string{}
+	for _, typ := range []byte{world.TileSectorType, world.EntitySectorType, world.SectorUniqueType, world.UniqueIndexType} {
+		r = append(r, fmt.Sprintf("%s: %d kept, %d removed", names[typ], s.kept[typ], s.removed[typ]))
+	}
+
+	fmt.Println(strings.Join(r, "\n"))
+	fmt.Printf("removed records: %d bytes\n", s.bytes)
+	fmt.Printf("file size: %d -> %d, %d bytes reclaimed\n", before.Size(), after.Size(), before.Size()-after.Size())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/xhebox/sbutils/lib/btreedb5"
+	"github.com/xhebox/sbutils/lib/world"
+)
+
+func main() {
+	var in, mode, out string
+	flag.StringVar(&in, "i", "input", "world file")
+	flag.StringVar(&mode, "m", "check", "check/dot/json")
+	flag.StringVar(&out, "o", "stdout", "output file")
+	flag.Parse()
+	log.SetFlags(log.Llongfile)
+
+	var outwt io.Writer
+	if out == "stdout" {
+		outwt = os.Stdout
+	} else {
+		f, e := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		defer f.Close()
+
+		outwt = f
+	}
+
+	h, e := btreedb5.LoadReadOnly(in)
+	if e != nil {
+		log.Fatalln(e)
+	}
+	defer h.Close()
+
+	g := world.NewWireGraph()
+
+	e = h.AscendRange(btreedb5.Key{world.EntitySectorType}, btreedb5.Key{world.EntitySectorType + 1}, func(key btreedb5.Key, data []byte) {
+		raw, e := world.Decompress(data)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		l, e := world.ReadEntities(raw)
+		if e != nil {
+			log.Printf("%x: %v\n", key, e)
+			return
+		}
+
+		if e := g.Add(l); e != nil {
+			log.Printf("%x: %v\n", key, e)
+		}
+	})
+	if e != nil {
+		log.Fatalln(e)
+	}
+
+	switch mode {
+	case "check":
+		errs := g.Check()
+		for _, v := range errs {
+			fmt.Fprintln(outwt, v)
+		}
+
+		fmt.Fprintf(outwt, "%d objects, %d wires, %d errors\n", len(g.Objects), len(g.Wires), len(errs))
+		if len(errs) != 0 {
+			os.Exit(1)
+		}
+	case "dot":
+		if e := g.WriteDOT(outwt); e != nil {
+			log.Fatalln(e)
+		}
+	case "json":
+		enc := json.NewEncoder(outwt)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		if e := enc.Encode(g.Document()); e != nil {
+			log.Fatalln(e)
+		}
+	default:
+		log.Fatalf("unknown mode %s\n", mode)
+	}
+}